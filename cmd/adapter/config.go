@@ -1,39 +1,260 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env"
 )
 
+// configFileEnv is the variable holding the path of the optional YAML config file.
+const configFileEnv = "CONFIG_FILE"
+
 // Config is main application configuration structure.
+// Values are read from the config file first and then overridden by the
+// environment variables which are set.
 type config struct {
-	Listen  string `env:"LISTEN" envDefault:"localhost:9000"`
-	Network string `env:"NETWORK" envDefault:"tcp"`
+	Listen  string `env:"LISTEN" envDefault:"localhost:9000" yaml:"listen"`
+	Network string `env:"NETWORK" envDefault:"tcp" yaml:"network"`
+
+	LogLevel string `env:"LOG_LEVEL" envDefault:"debug" yaml:"log_level" reload:"true"`
+	LogFmt   string `env:"LOG_FMT" envDefault:"console" yaml:"log_fmt"`
+
+	DbAddr     string        `env:"DB_ADDR" envDefault:"http://localhost:7700" yaml:"db_addr"`
+	MaxDbCount int           `env:"DB_CONN_COUNT" envDefault:"100" yaml:"db_conn_count"`
+	DbTimeout  time.Duration `env:"DB_TIMEOUT" envDefault:"100ms" yaml:"db_timeout"`
+	ApiKey     string        `env:"API_KEY" envDefault:"" yaml:"api_key"`
+
+	// The circuit breaker opens at the ratio of failed Meilisearch calls
+	// within the window, 0 disables it.
+	BreakerFailureRatio  float64       `env:"BREAKER_FAILURE_RATIO" envDefault:"0.5" yaml:"breaker_failure_ratio"`
+	BreakerMinRequests   int           `env:"BREAKER_MIN_REQUESTS" envDefault:"20" yaml:"breaker_min_requests"`
+	BreakerWindow        time.Duration `env:"BREAKER_WINDOW" envDefault:"10s" yaml:"breaker_window"`
+	BreakerProbeInterval time.Duration `env:"BREAKER_PROBE_INTERVAL" envDefault:"5s" yaml:"breaker_probe_interval"`
+
+	AuthFile string `env:"AUTH_FILE" envDefault:"" yaml:"auth_file" reload:"true"`
+	// TenantsFile declares the tenants and their quotas, any tenant is accepted without it.
+	TenantsFile string `env:"TENANTS_FILE" envDefault:"" yaml:"tenants_file" reload:"true"`
+	// ParsersFile declares the parsers of plain text records by tag.
+	ParsersFile string `env:"PARSERS_FILE" envDefault:"" yaml:"parsers_file" reload:"true"`
+
+	TLSCertFile     string `env:"TLS_CERT_FILE" envDefault:"" yaml:"tls_cert_file"`
+	TLSKeyFile      string `env:"TLS_KEY_FILE" envDefault:"" yaml:"tls_key_file"`
+	TLSClientCAFile string `env:"TLS_CLIENT_CA_FILE" envDefault:"" yaml:"tls_client_ca_file"`
+	TLSClientAuth   string `env:"TLS_CLIENT_AUTH" envDefault:"require" yaml:"tls_client_auth"`
+
+	Timeout       time.Duration `env:"TIMEOUT" envDefault:"100ms" yaml:"timeout"`
+	MaxRecordSize int           `env:"MAX_RECORD_SIZE" envDefault:"1048576" yaml:"max_record_size"`
+	MaxBodySize   int           `env:"MAX_BODY_SIZE" envDefault:"33554432" yaml:"max_body_size"`
+	WaitTimeout   time.Duration `env:"WAIT_TIMEOUT" envDefault:"5s" yaml:"wait_timeout"`
+	DrainTimeout  time.Duration `env:"DRAIN_TIMEOUT" envDefault:"10s" yaml:"drain_timeout"`
+	SpoolDir      string        `env:"SPOOL_DIR" envDefault:"" yaml:"spool_dir"`
+	SpoolReplay   time.Duration `env:"SPOOL_REPLAY_INTERVAL" envDefault:"30s" yaml:"spool_replay_interval"`
+	Name          string        `env:"HTTP_CLIENT_NAME" envDefault:"log-db-adapter" yaml:"http_client_name"`
+
+	// LokiIndexLabel is the stream label whose value is the tag of pushed Loki entries.
+	LokiIndexLabel string `env:"LOKI_INDEX_LABEL" envDefault:"job" yaml:"loki_index_label"`
+	// OtlpIndexAttribute is the resource attribute whose value is the tag of OTLP log records.
+	OtlpIndexAttribute string `env:"OTLP_INDEX_ATTRIBUTE" envDefault:"service.name" yaml:"otlp_index_attribute"`
 
-	LogLevel string `env:"LOG_LEVEL" envDefault:"debug"`
-	LogFmt   string `env:"LOG_FMT" envDefault:"console"`
+	// Syslog listeners, an empty address disables one. The TLS listener uses
+	// the TLS certificate of the API. Messages are written with SyslogTag,
+	// with the tag of their app-name if it is empty. TCP connections sending
+	// nothing for SyslogIdleTimeout are closed.
+	SyslogUDPAddr        string        `env:"SYSLOG_UDP_ADDR" envDefault:"" yaml:"syslog_udp_addr"`
+	SyslogTCPAddr        string        `env:"SYSLOG_TCP_ADDR" envDefault:"" yaml:"syslog_tcp_addr"`
+	SyslogTLSAddr        string        `env:"SYSLOG_TLS_ADDR" envDefault:"" yaml:"syslog_tls_addr"`
+	SyslogTag            string        `env:"SYSLOG_TAG" envDefault:"" yaml:"syslog_tag"`
+	SyslogMaxMessageSize int           `env:"SYSLOG_MAX_MESSAGE_SIZE" envDefault:"65536" yaml:"syslog_max_message_size"`
+	SyslogIdleTimeout    time.Duration `env:"SYSLOG_IDLE_TIMEOUT" envDefault:"5m" yaml:"syslog_idle_timeout"`
 
-	DbAddr     string        `env:"DB_ADDR" envDefault:"http://localhost:7700"`
-	MaxDbCount int           `env:"DB_CONN_COUNT" envDefault:"100"`
-	DbTimeout  time.Duration `env:"DB_TIMEOUT" envDefault:"100ms"`
-	ApiKey     string        `env:"API_KEY" envDefault:""`
+	// GELF UDP listener, disabled if the address is empty, and the HTTP input.
+	// The value of GelfIndexField is the tag of a message.
+	GelfUDPAddr        string        `env:"GELF_UDP_ADDR" envDefault:"" yaml:"gelf_udp_addr"`
+	GelfIndexField     string        `env:"GELF_INDEX_FIELD" envDefault:"_container_name" yaml:"gelf_index_field"`
+	GelfMaxMessageSize int           `env:"GELF_MAX_MESSAGE_SIZE" envDefault:"1048576" yaml:"gelf_max_message_size"`
+	GelfChunkTimeout   time.Duration `env:"GELF_CHUNK_TIMEOUT" envDefault:"5s" yaml:"gelf_chunk_timeout"`
 
-	Timeout time.Duration `env:"TIMEOUT" envDefault:"100ms"`
-	Name    string        `env:"HTTP_CLIENT_NAME" envDefault:"log-db-adapter"`
+	// Errors of every class within ResetTime trigger the action of the class
+	// at its max count: none, degrade, shed or exit. MaxErrorCount is the
+	// max count of internal errors.
+	MaxErrorCount          int           `env:"MAX_ERR_COUNT" envDefault:"100" yaml:"max_err_count"`
+	ResetTime              time.Duration `env:"RESET_TIME" envDefault:"10m" yaml:"reset_time"`
+	InternalErrorAction    string        `env:"INTERNAL_ERR_ACTION" envDefault:"exit" yaml:"internal_err_action"`
+	ClientErrorMaxCount    int           `env:"CLIENT_ERR_MAX_COUNT" envDefault:"0" yaml:"client_err_max_count"`
+	ClientErrorAction      string        `env:"CLIENT_ERR_ACTION" envDefault:"none" yaml:"client_err_action"`
+	TransientErrorMaxCount int           `env:"TRANSIENT_ERR_MAX_COUNT" envDefault:"100" yaml:"transient_err_max_count"`
+	TransientErrorAction   string        `env:"TRANSIENT_ERR_ACTION" envDefault:"degrade" yaml:"transient_err_action"`
+	PermanentErrorMaxCount int           `env:"PERMANENT_ERR_MAX_COUNT" envDefault:"10" yaml:"permanent_err_max_count"`
+	PermanentErrorAction   string        `env:"PERMANENT_ERR_ACTION" envDefault:"exit" yaml:"permanent_err_action"`
 
-	MaxErrorCount int           `env:"MAX_ERR_COUNT" envDefault:"100"`
-	ResetTime     time.Duration `env:"RESET_TIME" envDefault:"10m"`
+	AlertRulesFile    string        `env:"ALERT_RULES_FILE" envDefault:"" yaml:"alert_rules_file" reload:"true"`
+	AlertEvalInterval time.Duration `env:"ALERT_EVAL_INTERVAL" envDefault:"1s" yaml:"alert_eval_interval"`
+	AlertTimeout      time.Duration `env:"ALERT_TIMEOUT" envDefault:"5s" yaml:"alert_timeout"`
+	AlertWorkers      int           `env:"ALERT_WORKERS" envDefault:"4" yaml:"alert_workers"`
+	AlertQueueSize    int           `env:"ALERT_QUEUE_SIZE" envDefault:"100" yaml:"alert_queue_size"`
+	AlertMaxGroups    int           `env:"ALERT_MAX_GROUPS" envDefault:"1000" yaml:"alert_max_groups"`
+
+	// Rate limits are requests per second with a burst, 0 disables them.
+	RateLimitIP              float64       `env:"RATE_LIMIT_IP" envDefault:"0" yaml:"rate_limit_ip" reload:"true"`
+	RateLimitIPBurst         int           `env:"RATE_LIMIT_IP_BURST" envDefault:"100" yaml:"rate_limit_ip_burst" reload:"true"`
+	RateLimitCredential      float64       `env:"RATE_LIMIT_CREDENTIAL" envDefault:"0" yaml:"rate_limit_credential" reload:"true"`
+	RateLimitCredentialBurst int           `env:"RATE_LIMIT_CREDENTIAL_BURST" envDefault:"100" yaml:"rate_limit_credential_burst" reload:"true"`
+	RateLimitTag             float64       `env:"RATE_LIMIT_TAG" envDefault:"0" yaml:"rate_limit_tag" reload:"true"`
+	RateLimitTagBurst        int           `env:"RATE_LIMIT_TAG_BURST" envDefault:"100" yaml:"rate_limit_tag_burst" reload:"true"`
+	RateLimitIdleTimeout     time.Duration `env:"RATE_LIMIT_IDLE_TIMEOUT" envDefault:"10m" yaml:"rate_limit_idle_timeout"`
+
+	TracingEndpoint    string        `env:"TRACING_ENDPOINT" envDefault:"" yaml:"tracing_endpoint"`
+	TracingServiceName string        `env:"TRACING_SERVICE_NAME" envDefault:"logdb-adapter" yaml:"tracing_service_name"`
+	TracingSampleRatio float64       `env:"TRACING_SAMPLE_RATIO" envDefault:"1" yaml:"tracing_sample_ratio"`
+	TracingBatchSize   int           `env:"TRACING_BATCH_SIZE" envDefault:"512" yaml:"tracing_batch_size"`
+	TracingQueueSize   int           `env:"TRACING_QUEUE_SIZE" envDefault:"2048" yaml:"tracing_queue_size"`
+	TracingInterval    time.Duration `env:"TRACING_FLUSH_INTERVAL" envDefault:"5s" yaml:"tracing_flush_interval"`
+	TracingTimeout     time.Duration `env:"TRACING_TIMEOUT" envDefault:"5s" yaml:"tracing_timeout"`
+
+	// File is the config file the values were read from.
+	File string `yaml:"-"`
 }
 
 func load() (*config, error) {
 	log.Debug().Msg("loading configuration")
-	cfg := &config{}
-
-	if err := env.Parse(cfg); err != nil {
+	cfg, err := loadFile(os.Getenv(configFileEnv))
+	if err != nil {
+		return cfg, err
+	}
+	if err = cfg.validate(); err != nil {
 		return cfg, err
 	}
 	log.Debug().Interface("config", cfg).Msg("initialize configuration")
 	return cfg, nil
 }
+
+// loadFile reads the config file over the defaults and applies the
+// environment variables on top of it. Without a file only the environment is used.
+func loadFile(file string) (*config, error) {
+	envCfg := &config{}
+	if err := env.Parse(envCfg); err != nil {
+		return envCfg, err
+	}
+	if file == "" {
+		return envCfg, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return envCfg, err
+	}
+	cfg := *envCfg
+	cfg.File = file
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&cfg); err != nil {
+		return &cfg, fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+
+	fromEnv, cur := reflect.ValueOf(envCfg).Elem(), reflect.ValueOf(&cfg).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		name := cur.Type().Field(i).Tag.Get("env")
+		if _, ok := os.LookupEnv(name); ok && name != "" {
+			cur.Field(i).Set(fromEnv.Field(i))
+		}
+	}
+	return &cfg, nil
+}
+
+func (c *config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.Listen != "", "listen address is empty")
+	check(c.Network == "tcp" || c.Network == "tcp4" || c.Network == "tcp6" || c.Network == "unix",
+		"unknown network %s", c.Network)
+	_, err := zerolog.ParseLevel(strings.ToLower(c.LogLevel))
+	check(err == nil, "unknown log level %s", c.LogLevel)
+	check(c.LogFmt == "console" || c.LogFmt == "json", "unknown log format %s", c.LogFmt)
+	check(c.DbAddr != "", "database address is empty")
+	check(c.MaxDbCount > 0 && c.MaxDbCount <= 1<<16-1, "database connection count must be between 1 and 65535")
+	check(c.DbTimeout > 0, "database timeout must be positive")
+	check(c.BreakerMinRequests >= 0, "breaker min requests must not be negative")
+	err = createLogAdapterConfig(c).Breaker.Validate()
+	check(err == nil, "%v", err)
+	check(c.Timeout > 0, "timeout must be positive")
+	check(c.MaxRecordSize >= 0, "max record size must not be negative")
+	check(c.MaxBodySize > 0, "max body size must be positive")
+	check(c.WaitTimeout > 0, "wait timeout must be positive")
+	check(c.DrainTimeout >= 0, "drain timeout must not be negative")
+	check(c.SpoolReplay > 0, "spool replay interval must be positive")
+	check(c.LokiIndexLabel != "", "loki index label is empty")
+	check(c.OtlpIndexAttribute != "", "otlp index attribute is empty")
+	check(c.SyslogTLSAddr == "" || c.TLSCertFile != "", "syslog TLS listener requires a TLS certificate")
+	check(c.SyslogTag == "" || tenant.ValidateTag(c.SyslogTag) == nil, "invalid syslog tag %s", c.SyslogTag)
+	check(c.SyslogMaxMessageSize > 0, "syslog max message size must be positive")
+	check(c.SyslogIdleTimeout > 0, "syslog idle timeout must be positive")
+	check(c.GelfIndexField != "", "gelf index field is empty")
+	check(c.GelfMaxMessageSize > 0, "gelf max message size must be positive")
+	check(c.GelfChunkTimeout > 0, "gelf chunk timeout must be positive")
+	check(c.MaxErrorCount > 0, "max error count must be positive")
+	check(c.ResetTime > 0, "error reset time must be positive")
+	check(c.ClientErrorMaxCount >= 0 && c.TransientErrorMaxCount >= 0 && c.PermanentErrorMaxCount >= 0,
+		"error max counts must not be negative")
+	err = createErrorHandlerConf(c).Validate()
+	check(err == nil, "%v", err)
+	check(c.AlertEvalInterval > 0, "alert evaluation interval must be positive")
+	check(c.AlertTimeout > 0, "alert timeout must be positive")
+	check(c.AlertWorkers > 0, "alert workers must be positive")
+	check(c.AlertQueueSize > 0, "alert queue size must be positive")
+	check(c.AlertMaxGroups > 0, "alert max groups must be positive")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS certificate and key must be set together")
+	check(c.TLSClientCAFile == "" || c.TLSCertFile != "", "client CA requires a TLS certificate")
+	check(c.TLSClientAuth == "require" || c.TLSClientAuth == "optional", "unknown TLS client auth %s", c.TLSClientAuth)
+	err = createRateLimitConfig(c).Validate()
+	check(err == nil, "%v", err)
+	check(c.RateLimitIdleTimeout > 0, "rate limit idle timeout must be positive")
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "tracing sample ratio must be between 0 and 1")
+	check(c.TracingBatchSize > 0 && c.TracingBatchSize <= c.TracingQueueSize,
+		"tracing batch size must be positive and not larger than the queue size")
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// withReloaded returns a copy of the configuration with the reloadable settings of n.
+func (c *config) withReloaded(n *config) *config {
+	res := *c
+	cur, next := reflect.ValueOf(&res).Elem(), reflect.ValueOf(n).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		if cur.Type().Field(i).Tag.Get("reload") == "true" {
+			cur.Field(i).Set(next.Field(i))
+		}
+	}
+	return &res
+}
+
+// staticChanges returns the settings which differ between the configurations
+// but are applied only on restart.
+func (c *config) staticChanges(n *config) []string {
+	var res []string
+	cur, next := reflect.ValueOf(c).Elem(), reflect.ValueOf(n).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		f := cur.Type().Field(i)
+		if f.Tag.Get("yaml") == "-" || f.Tag.Get("reload") == "true" {
+			continue
+		}
+		if !reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			res = append(res, strings.Split(f.Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return res
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/polyse/logdb/internal/alert"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type ConfigUnitTestSuite struct {
+	suite.Suite
+	dir string
+	env []string
+}
+
+func TestRunConfigUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigUnitTestSuite))
+}
+
+func (s *ConfigUnitTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "config")
+	s.NoError(err)
+	s.dir = dir
+}
+
+func (s *ConfigUnitTestSuite) TearDownTest() {
+	for _, name := range s.env {
+		s.NoError(os.Unsetenv(name))
+	}
+	s.env = nil
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *ConfigUnitTestSuite) setenv(name, value string) {
+	s.NoError(os.Setenv(name, value))
+	s.env = append(s.env, name)
+}
+
+func (s *ConfigUnitTestSuite) file(name, content string) string {
+	file := filepath.Join(s.dir, name)
+	s.NoError(ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func (s *ConfigUnitTestSuite) Test_LoadFile_Defaults() {
+	cfg, err := loadFile("")
+	s.NoError(err)
+	s.Equal("localhost:9000", cfg.Listen)
+	s.Equal(100*time.Millisecond, cfg.DbTimeout)
+	s.Empty(cfg.File)
+	s.NoError(cfg.validate())
+}
+
+func (s *ConfigUnitTestSuite) Test_LoadFile_Env_Overrides_File() {
+	file := s.file("config.yaml", "listen: 0.0.0.0:8000\nlog_level: info\ndb_timeout: 2s\n")
+	s.setenv("LOG_LEVEL", "warn")
+
+	cfg, err := loadFile(file)
+	s.NoError(err)
+	s.Equal(file, cfg.File)
+	s.Equal("0.0.0.0:8000", cfg.Listen, "file overrides the default")
+	s.Equal(2*time.Second, cfg.DbTimeout)
+	s.Equal("warn", cfg.LogLevel, "environment overrides the file")
+	s.Equal("tcp", cfg.Network, "default is kept")
+}
+
+func (s *ConfigUnitTestSuite) Test_LoadFile_Errors() {
+	_, err := loadFile(s.file("unknown.yaml", "listen: a\nno_such_setting: 1\n"))
+	s.Error(err)
+	_, err = loadFile(s.file("invalid.yaml", "db_timeout: soon\n"))
+	s.Error(err)
+	_, err = loadFile(filepath.Join(s.dir, "missing.yaml"))
+	s.True(errors.Is(err, os.ErrNotExist))
+
+	s.setenv("DB_TIMEOUT", "soon")
+	_, err = loadFile("")
+	s.Error(err)
+}
+
+func (s *ConfigUnitTestSuite) Test_Validate() {
+	cfg, err := loadFile("")
+	s.NoError(err)
+	cfg.Network = "udp"
+	cfg.LogLevel = "loud"
+	cfg.MaxDbCount = 0
+	cfg.TLSCertFile = "cert.pem"
+	err = cfg.validate()
+	s.Error(err)
+	for _, msg := range []string{"unknown network udp", "unknown log level loud",
+		"database connection count must be between 1 and 65535", "TLS certificate and key must be set together"} {
+		s.Contains(err.Error(), msg)
+	}
+}
+
+func (s *ConfigUnitTestSuite) Test_WithReloaded_And_StaticChanges() {
+	cur, err := loadFile("")
+	s.NoError(err)
+	next := *cur
+	next.LogLevel = "error"
+	next.RateLimitIP = 5
+	next.Listen = "0.0.0.0:9001"
+	next.DbTimeout = time.Second
+	next.File = "other.yaml"
+
+	res := cur.withReloaded(&next)
+	s.Equal("error", res.LogLevel)
+	s.Equal(5.0, res.RateLimitIP)
+	s.Equal("localhost:9000", res.Listen, "static settings are kept")
+	s.Equal(100*time.Millisecond, res.DbTimeout)
+	s.Equal("debug", cur.LogLevel, "the current configuration is not changed")
+
+	s.Equal([]string{"listen", "db_timeout"}, cur.staticChanges(&next))
+	s.Empty(cur.staticChanges(res))
+}
+
+func (s *ConfigUnitTestSuite) newReloader(cfg *config) *reloader {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	alerter, err := alert.NewAlerter(ctx, &alert.Config{EvalInterval: time.Hour, Timeout: time.Second})
+	s.NoError(err)
+	authStore, err := auth.NewStore("")
+	s.NoError(err)
+	tenants, err := tenant.NewRegistry("")
+	s.NoError(err)
+	parsers, err := parser.NewRegistry("")
+	s.NoError(err)
+	limiter := ratelimit.NewLimiter(ctx, createRateLimitConfig(cfg))
+	return newReloader(cfg, alerter, authStore, tenants, parsers, limiter, nil)
+}
+
+func (s *ConfigUnitTestSuite) Test_Reload() {
+	cfg, err := loadFile("")
+	s.NoError(err)
+	r := s.newReloader(cfg)
+	next := *cfg
+	next.LogLevel = "info"
+	next.Listen = "0.0.0.0:9001"
+	next.ParsersFile = s.file("parsers.json", `[{"tag":"app","type":"logfmt"}]`)
+	next.TenantsFile = s.file("tenants.json", `[{"id":"team-a"}]`)
+	r.load = func() (*config, error) {
+		c := next
+		return &c, nil
+	}
+
+	r.reload()
+	s.Equal("info", r.cfg.LogLevel)
+	s.Equal("localhost:9000", r.cfg.Listen, "static settings require a restart")
+	s.IsType(&parser.LogfmtParser{}, r.parsers.Lookup("app"))
+	_, err = r.tenants.Lookup("team-b")
+	s.True(errors.Is(err, tenant.ErrUnknownTenant))
+	s.Contains(r.files(), next.ParsersFile)
+
+	// an invalid file keeps the whole current configuration
+	next.LogLevel = "warn"
+	next.ParsersFile = s.file("parsers.json", `[{"tag":"app","type":"xml"}]`)
+	r.reload()
+	s.Equal("info", r.cfg.LogLevel)
+	s.IsType(&parser.LogfmtParser{}, r.parsers.Lookup("app"))
+
+	r.load = func() (*config, error) { return nil, errors.New("invalid configuration") }
+	r.reload()
+	s.Equal("info", r.cfg.LogLevel)
+}
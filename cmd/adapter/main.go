@@ -2,10 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/alert"
 	"github.com/polyse/logdb/internal/api"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/breaker"
+	"github.com/polyse/logdb/internal/certs"
 	"github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/gelf"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/syslog"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/polyse/logdb/internal/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	ml "github.com/senyast4745/meilisearch-go"
@@ -34,18 +45,80 @@ func main() {
 	}
 	log.Debug().Msg("logger initialized")
 
+	log.Debug().Msg("initialize tracing")
+	stopTracing, err := tracing.Init(ctx, createTracingConfig(cfg))
+	if err != nil {
+		log.Error().Err(err).Msg("error while init tracing")
+		return
+	}
+	closer.Bind(stopTracing)
+
 	log.Debug().Msg("initialize error handler")
 	errConf := createErrorHandlerConf(cfg)
-	errCtx, errorChan := errors.NewHandler(ctx, errConf)
+	errCtx, errHandler := errors.NewHandler(ctx, errConf)
+
+	log.Debug().Msg("initialize alerter")
+	alerter, err := alert.NewAlerter(ctx, createAlertConfig(cfg))
+	if err != nil {
+		log.Error().Err(err).Msg("error while loading alert rules")
+		return
+	}
+	log.Debug().Msg("initialize credentials")
+	authStore, err := auth.NewStore(cfg.AuthFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error while loading credentials")
+		return
+	}
+	log.Debug().Msg("initialize tenants")
+	tenants, err := tenant.NewRegistry(cfg.TenantsFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error while loading tenants")
+		return
+	}
+	log.Debug().Msg("initialize parsers")
+	parsers, err := parser.NewRegistry(cfg.ParsersFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error while loading parsers")
+		return
+	}
+	log.Debug().Msg("initialize certificates")
+	certLoader, err := certs.NewLoader(createCertsConfig(cfg))
+	if err != nil {
+		log.Error().Err(err).Msg("error while loading certificates")
+		return
+	}
+	limiter := ratelimit.NewLimiter(ctx, createRateLimitConfig(cfg))
+	go newReloader(cfg, alerter, authStore, tenants, parsers, limiter, certLoader).watch(ctx)
 
 	log.Debug().Msg("initialize adapter api")
-	adapterApi, closeApi, err := initLogAdapterApi(ctx, cfg, errorChan)
+	adapterApi, closeApi, err := initLogAdapterApi(ctx, cfg, errHandler, alerter, authStore, tenants, limiter, certLoader, parsers)
 	closer.Bind(closeApi)
 	if err != nil {
 		log.Error().Err(err).Msg("error while init adapter api")
 		return
 	}
 
+	go adapterApi.ReplaySpool(ctx)
+
+	log.Debug().Msg("initialize syslog listeners")
+	_, closeSyslog, err := syslog.NewServer(ctx, createSyslogConfig(cfg, certLoader), adapterApi)
+	if err != nil {
+		log.Error().Err(err).Msg("error while starting syslog listeners")
+		return
+	}
+	// bound after the API, so listeners stop before accepted writes are drained
+	closer.Bind(closeSyslog)
+
+	if cfg.GelfUDPAddr != "" {
+		log.Debug().Msg("initialize gelf listener")
+		_, closeGelf, err := gelf.NewServer(ctx, createGelfConfig(cfg), adapterApi)
+		if err != nil {
+			log.Error().Err(err).Msg("error while starting gelf listener")
+			return
+		}
+		closer.Bind(closeGelf)
+	}
+
 	go func() {
 		<-errCtx.Done()
 		log.Info().Msg("Stopping app")
@@ -61,8 +134,19 @@ func main() {
 
 func createErrorHandlerConf(c *config) *errors.Config {
 	return &errors.Config{
-		MaxErrorCount: uint32(c.MaxErrorCount),
-		ResetTime:     c.ResetTime,
+		Policies: map[errors.Class]errors.Policy{
+			errors.Client:    {MaxCount: uint32(c.ClientErrorMaxCount), Action: errors.Action(c.ClientErrorAction)},
+			errors.Transient: {MaxCount: uint32(c.TransientErrorMaxCount), Action: errors.Action(c.TransientErrorAction)},
+			errors.Permanent: {MaxCount: uint32(c.PermanentErrorMaxCount), Action: errors.Action(c.PermanentErrorAction)},
+			errors.Internal:  {MaxCount: uint32(c.MaxErrorCount), Action: errors.Action(c.InternalErrorAction)},
+		},
+		ResetTime: c.ResetTime,
+	}
+}
+
+func createReadinessChecks(h *errors.Handler) []api.ReadinessCheck {
+	return []api.ReadinessCheck{
+		{Name: "error_handler", Check: h.Check},
 	}
 }
 
@@ -70,15 +154,104 @@ func createLogAdapterConfig(c *config) *adapter.Config {
 	return &adapter.Config{Config: ml.Config{
 		Host:   c.DbAddr,
 		APIKey: c.ApiKey,
-	}, Timeout: c.DbTimeout}
+	}, Timeout: c.DbTimeout, Breaker: breaker.Config{
+		FailureRatio:  c.BreakerFailureRatio,
+		MinRequests:   uint32(c.BreakerMinRequests),
+		Window:        c.BreakerWindow,
+		ProbeInterval: c.BreakerProbeInterval,
+	}}
+}
+
+func createAlertConfig(c *config) *alert.Config {
+	return &alert.Config{
+		RulesFile:    c.AlertRulesFile,
+		EvalInterval: c.AlertEvalInterval,
+		Timeout:      c.AlertTimeout,
+		Workers:      c.AlertWorkers,
+		QueueSize:    c.AlertQueueSize,
+		MaxGroups:    c.AlertMaxGroups,
+	}
+}
+
+func createTracingConfig(c *config) *tracing.Config {
+	return &tracing.Config{
+		Endpoint:      c.TracingEndpoint,
+		ServiceName:   c.TracingServiceName,
+		SampleRatio:   c.TracingSampleRatio,
+		BatchSize:     c.TracingBatchSize,
+		QueueSize:     c.TracingQueueSize,
+		FlushInterval: c.TracingInterval,
+		Timeout:       c.TracingTimeout,
+	}
+}
+
+func createRateLimitConfig(c *config) *ratelimit.Config {
+	return &ratelimit.Config{
+		IP:          ratelimit.Limit{Rate: c.RateLimitIP, Burst: c.RateLimitIPBurst},
+		Credential:  ratelimit.Limit{Rate: c.RateLimitCredential, Burst: c.RateLimitCredentialBurst},
+		Tag:         ratelimit.Limit{Rate: c.RateLimitTag, Burst: c.RateLimitTagBurst},
+		IdleTimeout: c.RateLimitIdleTimeout,
+	}
+}
+
+func createCertsConfig(c *config) *certs.Config {
+	clientAuth := tls.RequireAndVerifyClientCert
+	if c.TLSClientAuth == "optional" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return &certs.Config{
+		CertFile:     c.TLSCertFile,
+		KeyFile:      c.TLSKeyFile,
+		ClientCAFile: c.TLSClientCAFile,
+		ClientAuth:   clientAuth,
+	}
+}
+
+func createApiConfig(c *config, l *certs.Loader) *api.Config {
+	conf := &api.Config{
+		Addr:                c.Listen,
+		Network:             c.Network,
+		MaxDbConn:           uint16(c.MaxDbCount),
+		Timeout:             c.Timeout,
+		MaxRecordSize:       c.MaxRecordSize,
+		MaxBodySize:         c.MaxBodySize,
+		WaitTimeout:         c.WaitTimeout,
+		DrainTimeout:        c.DrainTimeout,
+		SpoolDir:            c.SpoolDir,
+		SpoolReplayInterval: c.SpoolReplay,
+
+		LokiIndexLabel:     c.LokiIndexLabel,
+		OtlpIndexAttribute: c.OtlpIndexAttribute,
+		GelfIndexField:     c.GelfIndexField,
+		GelfMaxMessageSize: c.GelfMaxMessageSize,
+	}
+	if l != nil {
+		conf.TLS = l.TLSConfig()
+	}
+	return conf
+}
+
+func createSyslogConfig(c *config, l *certs.Loader) *syslog.Config {
+	conf := &syslog.Config{
+		UDPAddr:        c.SyslogUDPAddr,
+		TCPAddr:        c.SyslogTCPAddr,
+		TLSAddr:        c.SyslogTLSAddr,
+		Tag:            c.SyslogTag,
+		MaxMessageSize: c.SyslogMaxMessageSize,
+		IdleTimeout:    c.SyslogIdleTimeout,
+	}
+	if l != nil && c.SyslogTLSAddr != "" {
+		conf.TLS = l.TLSConfig()
+	}
+	return conf
 }
 
-func createApiConfig(c *config) *api.Config {
-	return &api.Config{
-		Addr:      c.Listen,
-		Network:   c.Network,
-		MaxDbConn: uint16(c.MaxDbCount),
-		Timeout:   c.Timeout,
+func createGelfConfig(c *config) *gelf.Config {
+	return &gelf.Config{
+		UDPAddr:        c.GelfUDPAddr,
+		IndexField:     c.GelfIndexField,
+		MaxMessageSize: c.GelfMaxMessageSize,
+		ChunkTimeout:   c.GelfChunkTimeout,
 	}
 }
 
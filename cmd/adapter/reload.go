@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/polyse/logdb/internal/alert"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/certs"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadDelay groups the file events of one save into a single reload.
+const reloadDelay = 200 * time.Millisecond
+
+// reloader applies the reloadable settings when the config, the alert
+// rules, the credentials, the tenants, the parsers or the certificate files change or the process
+// receives SIGHUP. The listener and the other settings are kept until restart.
+type reloader struct {
+	lock    sync.Mutex
+	cfg     *config
+	alerter *alert.Alerter
+	auth    *auth.Store
+	tenants *tenant.Registry
+	parsers *parser.Registry
+	limiter *ratelimit.Limiter
+	certs   *certs.Loader
+	load    func() (*config, error)
+}
+
+func newReloader(cfg *config, alerter *alert.Alerter, authStore *auth.Store, tenants *tenant.Registry,
+	parsers *parser.Registry, limiter *ratelimit.Limiter, certLoader *certs.Loader) *reloader {
+	return &reloader{cfg: cfg, alerter: alerter, auth: authStore, tenants: tenants, parsers: parsers, limiter: limiter,
+		certs: certLoader, load: load}
+}
+
+// watch reloads the configuration until the context is done.
+func (r *reloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warn().Err(err).Msg("can not watch config files, only SIGHUP reloads them")
+	} else {
+		defer w.Close()
+		r.addWatches(w)
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if w != nil {
+		events, errs = w.Events, w.Errors
+	}
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info().Msg("SIGHUP received, reloading configuration")
+			r.reload()
+		case ev := <-events:
+			if r.watched(ev.Name) {
+				timer.Reset(reloadDelay)
+			}
+		case err := <-errs:
+			log.Warn().Err(err).Msg("config watch error")
+		case <-timer.C:
+			log.Info().Msg("config file changed, reloading configuration")
+			r.reload()
+			r.addWatches(w)
+		}
+	}
+}
+
+// reload loads the configuration again and applies the reloadable settings.
+// An invalid configuration is ignored and the current one is kept.
+func (r *reloader) reload() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	next, err := r.load()
+	if err != nil {
+		log.Error().Err(err).Msg("can not reload configuration, keeping the current one")
+		return
+	}
+	if changed := r.cfg.staticChanges(next); len(changed) > 0 {
+		log.Warn().Strs("settings", changed).Msg("settings changed but require restart")
+	}
+	var rules []*alert.Rule
+	if next.AlertRulesFile != "" {
+		if rules, err = alert.LoadRules(next.AlertRulesFile); err != nil {
+			log.Error().Err(err).Msg("can not reload alert rules, keeping the current configuration")
+			return
+		}
+	}
+	var creds []*auth.Credential
+	if next.AuthFile != "" {
+		if creds, err = auth.LoadCredentials(next.AuthFile); err != nil {
+			log.Error().Err(err).Msg("can not reload credentials, keeping the current configuration")
+			return
+		}
+	}
+	var tenants []*tenant.Tenant
+	if next.TenantsFile != "" {
+		if tenants, err = tenant.LoadTenants(next.TenantsFile); err != nil {
+			log.Error().Err(err).Msg("can not reload tenants, keeping the current configuration")
+			return
+		}
+	}
+	var parsers *parser.Config
+	if next.ParsersFile != "" {
+		if parsers, err = parser.LoadParsers(next.ParsersFile); err != nil {
+			log.Error().Err(err).Msg("can not reload parsers, keeping the current configuration")
+			return
+		}
+	}
+	if r.certs != nil {
+		if err = r.certs.Reload(); err != nil {
+			log.Error().Err(err).Msg("can not reload certificates, keeping the current configuration")
+			return
+		}
+	}
+	r.alerter.SetRules(rules)
+	r.auth.Set(creds, next.AuthFile != "")
+	r.tenants.Set(tenants, next.TenantsFile != "")
+	r.parsers.Set(parsers)
+	if limits := createRateLimitConfig(next); !reflect.DeepEqual(limits, createRateLimitConfig(r.cfg)) {
+		r.limiter.SetConfig(limits)
+	}
+	lvl, _ := zerolog.ParseLevel(strings.ToLower(next.LogLevel))
+	zerolog.SetGlobalLevel(lvl)
+
+	r.cfg = r.cfg.withReloaded(next)
+	log.Info().Msg("configuration reloaded")
+}
+
+// addWatches watches the directories of the files, so the files are
+// still followed after editors replace them.
+func (r *reloader) addWatches(w *fsnotify.Watcher) {
+	if w == nil {
+		return
+	}
+	r.lock.Lock()
+	files := r.files()
+	r.lock.Unlock()
+	for _, f := range files {
+		if err := w.Add(filepath.Dir(f)); err != nil {
+			log.Warn().Err(err).Str("file", f).Msg("can not watch file")
+		}
+	}
+}
+
+func (r *reloader) watched(name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, f := range r.files() {
+		if filepath.Clean(name) == filepath.Clean(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reloader) files() []string {
+	var res []string
+	for _, f := range []string{r.cfg.File, r.cfg.AlertRulesFile, r.cfg.AuthFile, r.cfg.TenantsFile, r.cfg.ParsersFile} {
+		if f != "" {
+			res = append(res, f)
+		}
+	}
+	if r.certs != nil {
+		res = append(res, r.certs.Files()...)
+	}
+	return res
+}
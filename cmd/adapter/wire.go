@@ -6,11 +6,20 @@ import (
 	"context"
 	"github.com/google/wire"
 	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/alert"
 	"github.com/polyse/logdb/internal/api"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/certs"
+	"github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/tenant"
 )
 
-func initLogAdapterApi(ctx context.Context, c *config, ch chan<- error) (*api.API, func(), error) {
-	wire.Build(createLogAdapterConfig, createApiConfig,
+func initLogAdapterApi(ctx context.Context, c *config, h *errors.Handler, a *alert.Alerter, s *auth.Store, t *tenant.Registry,
+	r *ratelimit.Limiter, l *certs.Loader, p *parser.Registry) (*api.API, func(), error) {
+	wire.Build(createLogAdapterConfig, createApiConfig, createReadinessChecks,
+		wire.Bind(new(adapter.Observer), new(*alert.Alerter)), wire.Bind(new(auth.Authenticator), new(*auth.Store)),
 		adapter.NewAdapter, wire.Bind(new(adapter.Adapter), new(*adapter.SimpleAdapter)), api.NewAdapterApi)
 	return nil, nil, nil
 }
@@ -8,19 +8,28 @@ package main
 import (
 	"context"
 	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/alert"
 	"github.com/polyse/logdb/internal/api"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/certs"
+	"github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/tenant"
 )
 
 // Injectors from wire.go:
 
-func initLogAdapterApi(ctx context.Context, c *config, ch chan<- error) (*api.API, func(), error) {
-	apiConfig := createApiConfig(c)
+func initLogAdapterApi(ctx context.Context, c *config, h *errors.Handler, a *alert.Alerter, s *auth.Store, t *tenant.Registry,
+	r *ratelimit.Limiter, l *certs.Loader, p *parser.Registry) (*api.API, func(), error) {
+	apiConfig := createApiConfig(c, l)
 	adapterConfig := createLogAdapterConfig(c)
-	simpleAdapter, err := adapter.NewAdapter(adapterConfig)
+	simpleAdapter, err := adapter.NewAdapter(adapterConfig, a)
 	if err != nil {
 		return nil, nil, err
 	}
-	apiAPI, cleanup, err := api.NewAdapterApi(ctx, apiConfig, simpleAdapter, ch)
+	v := createReadinessChecks(h)
+	apiAPI, cleanup, err := api.NewAdapterApi(ctx, apiConfig, simpleAdapter, h, v, s, t, r, p)
 	if err != nil {
 		return nil, nil, err
 	}
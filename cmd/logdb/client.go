@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/tenant"
+	ml "github.com/senyast4745/meilisearch-go"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client talks to the adapter HTTP API.
+type client struct {
+	addr    string
+	token   string
+	tenant  string
+	timeout time.Duration
+	cli     *fasthttp.Client
+}
+
+func newClient(c *config) *client {
+	return &client{
+		addr:    strings.TrimRight(c.Addr, "/") + "/api",
+		token:   c.Token,
+		tenant:  c.Tenant,
+		timeout: c.Timeout,
+		// the tags are escaped in the paths and must stay so
+		cli: &fasthttp.Client{Name: "logdb-cli", TLSConfig: c.tls, DisablePathNormalizing: true},
+	}
+}
+
+// send writes a record, with wait it returns once the record is searchable.
+func (c *client) send(tag string, data []byte, wait bool) error {
+	var q url.Values
+	if wait {
+		q = url.Values{"wait": {"true"}}
+	}
+	return c.do(http.MethodPut, "/logs/"+url.PathEscape(tag), q, data, nil)
+}
+
+func (c *client) search(tag string, req *adapter.SearchRequest) (*adapter.SearchResponse, error) {
+	q := url.Values{}
+	if req.Query != "" {
+		q.Set("q", req.Query)
+	}
+	if req.Filters != "" {
+		q.Set("filters", req.Filters)
+	}
+	if req.Offset != 0 {
+		q.Set("offset", strconv.FormatInt(req.Offset, 10))
+	}
+	if req.Limit != 0 {
+		q.Set("limit", strconv.FormatInt(req.Limit, 10))
+	}
+	resp := &adapter.SearchResponse{}
+	return resp, c.do(http.MethodGet, "/logs/"+url.PathEscape(tag)+"/search", q, nil, resp)
+}
+
+func (c *client) fields(tag string) ([]string, error) {
+	var fields []string
+	return fields, c.do(http.MethodGet, "/logs/"+url.PathEscape(tag)+"/fields", nil, nil, &fields)
+}
+
+func (c *client) indexes() ([]ml.Index, error) {
+	var indexes []ml.Index
+	return indexes, c.do(http.MethodGet, "/indexes", nil, nil, &indexes)
+}
+
+func (c *client) deleteIndex(tag string) error {
+	return c.do(http.MethodDelete, "/indexes/"+url.PathEscape(tag), nil, nil, nil)
+}
+
+func (c *client) do(method, path string, query url.Values, body []byte, out interface{}) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	uri := c.addr + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req.SetRequestURI(uri)
+	req.Header.SetMethod(method)
+	if c.token != "" {
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set(tenant.Header, c.tenant)
+	}
+	if body != nil {
+		req.Header.SetContentType("application/json")
+		req.SetBody(body)
+	}
+	if err := c.cli.DoTimeout(req, resp, c.timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode(), strings.TrimSpace(string(resp.Body())))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Body(), out)
+}
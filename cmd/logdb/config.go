@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/caarlos0/env"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultDotfile = ".logdbrc"
+
+// config is the command line client configuration, it is read from
+// the environment and from the dotfile ($LOGDB_CONFIG or ~/.logdbrc),
+// environment variables win.
+type config struct {
+	Addr    string        `env:"LOGDB_ADDR" envDefault:"http://localhost:9000"`
+	Format  string        `env:"LOGDB_FORMAT" envDefault:"table"`
+	Timeout time.Duration `env:"LOGDB_TIMEOUT" envDefault:"5s"`
+	// Token is an API key or a bearer token sent with every request.
+	Token string `env:"LOGDB_TOKEN" envDefault:""`
+	// Tenant selects the tenant unless the token is bound to one.
+	Tenant string `env:"LOGDB_TENANT" envDefault:""`
+	// CAFile verifies the adapter certificate, CertFile and KeyFile are the client certificate for mutual TLS.
+	CAFile   string `env:"LOGDB_CA_FILE" envDefault:""`
+	CertFile string `env:"LOGDB_CERT_FILE" envDefault:""`
+	KeyFile  string `env:"LOGDB_KEY_FILE" envDefault:""`
+
+	tls *tls.Config
+}
+
+func load() (*config, error) {
+	file := os.Getenv("LOGDB_CONFIG")
+	if file == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			file = filepath.Join(home, defaultDotfile)
+		}
+	}
+	if err := loadDotfile(file); err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := env.Parse(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.loadTLS()
+}
+
+func (c *config) loadTLS() error {
+	if c.CAFile == "" && c.CertFile == "" {
+		return nil
+	}
+	c.tls = &tls.Config{}
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return err
+		}
+		c.tls.RootCAs = x509.NewCertPool()
+		if !c.tls.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return err
+		}
+		c.tls.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// loadDotfile sets variables from KEY=value lines of the file unless they are already set.
+func loadDotfile(file string) error {
+	if file == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, val := strings.TrimSpace(kv[0]), strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		if _, ok := os.LookupEnv(key); !ok {
+			if err = os.Setenv(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/auth"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: logdb <command> [flags] [args]
+
+Commands:
+  send TAG                 send stdin lines to the tag
+  search TAG [QUERY...]    search records of the tag
+  tail TAG                 follow new records of the tag
+  indexes [list]           list indexes
+  indexes delete TAG...    delete indexes
+  fields TAG               show fields stored in the tag
+  token                    sign a bearer token with a credential secret
+
+Run 'logdb <command> -h' for command flags.
+`
+
+const (
+	// maxLineSize is the longest stdin line accepted by send.
+	maxLineSize = 1 << 20
+	// tailPageSize is the number of records tail requests at once.
+	tailPageSize = 1000
+)
+
+type command func(cfg *config, args []string) error
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	commands := map[string]command{
+		"send":    send,
+		"search":  search,
+		"tail":    tail,
+		"indexes": indexes,
+		"fields":  fields,
+		"token":   token,
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cfg, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error while loading config:", err)
+		os.Exit(1)
+	}
+	if err = cmd(cfg, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns flags shared by every command.
+func newFlagSet(name string, cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "adapter address")
+	fs.StringVar(&cfg.Format, "o", cfg.Format, "output format: table, json or raw")
+	return fs
+}
+
+func send(cfg *config, args []string) error {
+	fs := newFlagSet("send", cfg)
+	field := fs.String("field", "message", "field of the record wrapping non JSON lines")
+	wait := fs.Bool("wait", false, "wait until every record is searchable")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("send: exactly one tag expected")
+	}
+	c := newClient(cfg)
+	tag := fs.Arg(0)
+
+	sent, failed := 0, 0
+	sc := bufio.NewScanner(os.Stdin)
+	sc.Buffer(make([]byte, 64*1024), maxLineSize)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		data := []byte(line)
+		if !isJSONObject(data) {
+			var err error
+			if data, err = json.Marshal(map[string]string{*field: line}); err != nil {
+				return err
+			}
+		}
+		if err := c.send(tag, data, *wait); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+	fmt.Fprintf(os.Stderr, "sent %d, failed %d\n", sent, failed)
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("send: %d lines were not sent", failed)
+	}
+	return nil
+}
+
+func search(cfg *config, args []string) error {
+	fs := newFlagSet("search", cfg)
+	req := &adapter.SearchRequest{}
+	fs.StringVar(&req.Filters, "filters", "", "Meilisearch filters")
+	fs.Int64Var(&req.Limit, "limit", 20, "maximum number of records")
+	fs.Int64Var(&req.Offset, "offset", 0, "number of records to skip")
+	columns := fs.String("fields", "", "comma separated table columns")
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("search: tag expected")
+	}
+	p, err := newPrinter(os.Stdout, cfg.Format, splitList(*columns))
+	if err != nil {
+		return err
+	}
+	req.Query = strings.Join(fs.Args()[1:], " ")
+	resp, err := newClient(cfg).search(fs.Arg(0), req)
+	if err != nil {
+		return err
+	}
+	if cfg.Format == formatJSON {
+		return p.json(resp)
+	}
+	return p.documents(resp.Hits)
+}
+
+func tail(cfg *config, args []string) error {
+	fs := newFlagSet("tail", cfg)
+	filters := fs.String("filters", "", "Meilisearch filters")
+	interval := fs.Duration("interval", time.Second, "polling interval")
+	since := fs.Duration("since", 0, "also print records saved during this period")
+	columns := fs.String("fields", "", "comma separated table columns")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("tail: exactly one tag expected")
+	}
+	p, err := newPrinter(os.Stdout, cfg.Format, splitList(*columns))
+	if err != nil {
+		return err
+	}
+	c := newClient(cfg)
+	tag := fs.Arg(0)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+
+	last := adapter.Document{adapter.TimestampKey: float64(time.Now().Add(-*since).Unix())}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		docs, err := poll(c, tag, *filters, last)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			if err = p.documents(docs); err != nil {
+				return err
+			}
+			last = docs[len(docs)-1]
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll returns the documents of the tag saved after the last one, ordered by
+// @timestamp, @nanos and @seq. It requests pages until one comes back short,
+// so bursts larger than a page are not lost. Records saved meanwhile shift
+// the pages, the documents seen twice are skipped.
+func poll(c *client, tag, filters string, last adapter.Document) ([]adapter.Document, error) {
+	f := fmt.Sprintf("%s >= %d", adapter.TimestampKey, last.Timestamp())
+	if filters != "" {
+		f += " AND (" + filters + ")"
+	}
+	var res []adapter.Document
+	seen := map[string]struct{}{}
+	for offset := int64(0); ; offset += tailPageSize {
+		resp, err := c.search(tag, &adapter.SearchRequest{Filters: f, Offset: offset, Limit: tailPageSize})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Hits {
+			if _, ok := seen[d.Id()]; ok || !last.Less(d) {
+				continue
+			}
+			seen[d.Id()] = struct{}{}
+			res = append(res, d)
+		}
+		if len(resp.Hits) < tailPageSize {
+			break
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Less(res[j])
+	})
+	return res, nil
+}
+
+func indexes(cfg *config, args []string) error {
+	fs := newFlagSet("indexes", cfg)
+	_ = fs.Parse(args)
+	c := newClient(cfg)
+	switch fs.Arg(0) {
+	case "", "list":
+		p, err := newPrinter(os.Stdout, cfg.Format, nil)
+		if err != nil {
+			return err
+		}
+		list, err := c.indexes()
+		if err != nil {
+			return err
+		}
+		return p.indexes(list)
+	case "delete":
+		if fs.NArg() < 2 {
+			return fmt.Errorf("indexes delete: tag expected")
+		}
+		for _, tag := range fs.Args()[1:] {
+			if err := c.deleteIndex(tag); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "deleted", tag)
+		}
+		return nil
+	}
+	return fmt.Errorf("indexes: unknown subcommand %s", fs.Arg(0))
+}
+
+func fields(cfg *config, args []string) error {
+	fs := newFlagSet("fields", cfg)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("fields: exactly one tag expected")
+	}
+	p, err := newPrinter(os.Stdout, cfg.Format, nil)
+	if err != nil {
+		return err
+	}
+	list, err := newClient(cfg).fields(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return p.fieldNames(list)
+}
+
+func token(cfg *config, args []string) error {
+	fs := newFlagSet("token", cfg)
+	kid := fs.String("kid", "", "name of the credential whose secret signs the token")
+	secret := fs.String("secret", os.Getenv("LOGDB_TOKEN_SECRET"), "credential secret, defaults to $LOGDB_TOKEN_SECRET")
+	sub := fs.String("sub", "", "token subject")
+	tags := fs.String("tags", "", "comma separated tag patterns narrowing the credential ones")
+	scopes := fs.String("scopes", "", "comma separated scopes narrowing the credential ones")
+	ttl := fs.Duration("ttl", 24*time.Hour, "token lifetime, zero for no expiration")
+	_ = fs.Parse(args)
+	if *kid == "" || *secret == "" {
+		return fmt.Errorf("token: kid and secret expected")
+	}
+	now := time.Now()
+	claims := &auth.Claims{
+		Subject:  *sub,
+		IssuedAt: now.Unix(),
+		Tags:     splitList(*tags),
+	}
+	if *ttl > 0 {
+		claims.ExpiresAt = now.Add(*ttl).Unix()
+	}
+	for _, s := range splitList(*scopes) {
+		claims.Scopes = append(claims.Scopes, auth.Scope(s))
+	}
+	t, err := auth.NewToken(*kid, []byte(*secret), claims)
+	if err != nil {
+		return err
+	}
+	fmt.Println(t)
+	return nil
+}
+
+func isJSONObject(data []byte) bool {
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(data, &obj) == nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
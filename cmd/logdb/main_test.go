@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type CliUnitTestSuite struct {
+	suite.Suite
+	cfg *config
+}
+
+func TestRunCliUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(CliUnitTestSuite))
+}
+
+func (s *CliUnitTestSuite) SetupTest() {
+	s.cfg = &config{Addr: "http://127.0.0.1:1", Format: formatTable, Timeout: time.Second}
+}
+
+func (s *CliUnitTestSuite) Test_Arguments() {
+	s.NoError(os.Unsetenv("LOGDB_TOKEN_SECRET"))
+	for _, tc := range []struct {
+		name string
+		cmd  command
+		args []string
+		err  string
+	}{
+		{"send without tag", send, nil, "send: exactly one tag expected"},
+		{"send with two tags", send, []string{"a", "b"}, "send: exactly one tag expected"},
+		{"search without tag", search, []string{"-limit", "5"}, "search: tag expected"},
+		{"search with unknown format", search, []string{"-o", "xml", "app"}, "unknown output format xml"},
+		{"tail without tag", tail, nil, "tail: exactly one tag expected"},
+		{"tail with unknown format", tail, []string{"-o", "xml", "app"}, "unknown output format xml"},
+		{"fields with two tags", fields, []string{"a", "b"}, "fields: exactly one tag expected"},
+		{"indexes delete without tag", indexes, []string{"delete"}, "indexes delete: tag expected"},
+		{"indexes with unknown subcommand", indexes, []string{"rename"}, "indexes: unknown subcommand rename"},
+		{"token without secret", token, []string{"-kid", "ci"}, "token: kid and secret expected"},
+	} {
+		cfg := *s.cfg
+		err := tc.cmd(&cfg, tc.args)
+		if s.Error(err, tc.name) {
+			s.Equal(tc.err, err.Error(), tc.name)
+		}
+	}
+}
+
+func (s *CliUnitTestSuite) Test_SplitList() {
+	for in, out := range map[string][]string{
+		"":               nil,
+		"a":              {"a"},
+		" a , b ,, c ":   {"a", "b", "c"},
+		"message,@nanos": {"message", "@nanos"},
+	} {
+		s.Equal(out, splitList(in), in)
+	}
+}
+
+func (s *CliUnitTestSuite) Test_LoadDotfile() {
+	dir, err := ioutil.TempDir("", "logdb")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, defaultDotfile)
+	s.NoError(ioutil.WriteFile(file, []byte("# comment\nLOGDB_TEST_ADDR = 'http://logs:9000'\nLOGDB_TEST_TENANT=a\ninvalid\n"), 0600))
+	s.NoError(os.Setenv("LOGDB_TEST_TENANT", "b"))
+	defer os.Unsetenv("LOGDB_TEST_TENANT")
+	defer os.Unsetenv("LOGDB_TEST_ADDR")
+
+	s.NoError(loadDotfile(file))
+	s.Equal("http://logs:9000", os.Getenv("LOGDB_TEST_ADDR"))
+	s.Equal("b", os.Getenv("LOGDB_TEST_TENANT"), "the environment wins")
+	s.NoError(loadDotfile(filepath.Join(dir, "missing")))
+}
+
+func (s *CliUnitTestSuite) Test_Poll_Pages() {
+	// 2500 records newest first like the ranking rules of the indexes, the
+	// second half of them within one second
+	var stored []adapter.Document
+	for i := 2500; i > 0; i-- {
+		stored = append(stored, adapter.Document{
+			adapter.IdKey:        strconv.Itoa(i),
+			adapter.TimestampKey: float64(100 + i/1250),
+			adapter.NanosKey:     float64(i),
+			adapter.SeqKey:       float64(2500 - i),
+		})
+	}
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.EscapedPath()+" "+r.URL.Query().Get("filters"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		hits := []adapter.Document{}
+		if offset < len(stored) {
+			end := offset + limit
+			if end > len(stored) {
+				end = len(stored)
+			}
+			hits = stored[offset:end]
+		}
+		s.NoError(json.NewEncoder(w).Encode(&adapter.SearchResponse{Hits: hits}))
+		if offset == 0 {
+			// a record saved meanwhile shifts the next pages by one
+			stored = append([]adapter.Document{{
+				adapter.IdKey: "2501", adapter.TimestampKey: float64(102), adapter.NanosKey: float64(2501), adapter.SeqKey: float64(2500),
+			}}, stored...)
+		}
+	}))
+	defer srv.Close()
+	s.cfg.Addr = srv.URL
+
+	last := stored[len(stored)-1]
+	docs, err := poll(newClient(s.cfg), "ns/app", "level = error", last)
+	s.NoError(err)
+	s.Len(requests, 3, "pages are requested until one comes back short")
+	s.Equal("/api/logs/ns%2Fapp/search @timestamp >= 100 AND (level = error)", requests[0])
+	var ids []string
+	for _, d := range docs {
+		ids = append(ids, d.Id())
+	}
+	s.Len(ids, 2499, "every record newer than the last one is returned once")
+	s.Equal("2", ids[0])
+	s.Equal("2500", ids[len(ids)-1], "the record saved meanwhile is left to the next poll")
+	for i := 1; i < len(docs); i++ {
+		s.True(docs[i-1].Less(docs[i]), fmt.Sprintf("%s before %s", ids[i-1], ids[i]))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/polyse/logdb/internal/adapter"
+	ml "github.com/senyast4745/meilisearch-go"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatRaw   = "raw"
+)
+
+// printer writes command results in one of the output formats.
+type printer struct {
+	w      io.Writer
+	format string
+	// fields are the document columns of the table format.
+	fields []string
+}
+
+func newPrinter(w io.Writer, format string, fields []string) (*printer, error) {
+	switch format {
+	case formatTable, formatJSON, formatRaw:
+	default:
+		return nil, fmt.Errorf("unknown output format %s", format)
+	}
+	return &printer{w: w, format: format, fields: fields}, nil
+}
+
+func (p *printer) documents(docs []adapter.Document) error {
+	switch p.format {
+	case formatJSON:
+		return p.json(docs)
+	case formatRaw:
+		enc := json.NewEncoder(p.w)
+		for _, d := range docs {
+			if err := enc.Encode(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	if len(p.fields) == 0 {
+		fmt.Fprintln(tw, "TIME\tID\tDATA")
+	} else {
+		fmt.Fprintf(tw, "TIME\t%s\n", strings.ToUpper(strings.Join(p.fields, "\t")))
+	}
+	for _, d := range docs {
+		row := []string{time.Unix(d.Timestamp(), 0).Format(time.RFC3339)}
+		if len(p.fields) == 0 {
+			row = append(row, d.Id(), userData(d))
+		} else {
+			for _, f := range p.fields {
+				row = append(row, cell(d[f]))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) indexes(indexes []ml.Index) error {
+	switch p.format {
+	case formatJSON:
+		return p.json(indexes)
+	case formatRaw:
+		for _, ind := range indexes {
+			fmt.Fprintln(p.w, ind.UID)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UID\tCREATED\tUPDATED")
+	for _, ind := range indexes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ind.UID, ind.CreatedAt.Format(time.RFC3339), ind.UpdatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) fieldNames(fields []string) error {
+	if p.format == formatJSON {
+		return p.json(fields)
+	}
+	if p.format == formatTable {
+		fmt.Fprintln(p.w, "FIELD")
+	}
+	for _, f := range fields {
+		fmt.Fprintln(p.w, f)
+	}
+	return nil
+}
+
+func (p *printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// userData returns the document without the fields added by the adapter.
+func userData(d adapter.Document) string {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		if !strings.HasPrefix(k, "@") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+cell(d[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func cell(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return val
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
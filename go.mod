@@ -4,31 +4,36 @@ go 1.15
 
 require (
 	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gogo/protobuf v1.2.1 // indirect
 	github.com/golangci/golangci-lint v1.32.2 // indirect
-	github.com/google/go-cmp v0.5.2 // indirect
 	github.com/google/uuid v1.1.2
 	github.com/google/wire v0.4.0
-	github.com/klauspost/compress v1.11.0 // indirect
+	github.com/klauspost/compress v1.11.0
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/onsi/ginkgo v1.14.1 // indirect
 	github.com/onsi/gomega v1.10.2 // indirect
 	github.com/polyse/logDb v0.0.0-20201105113143-9ab93347ca8a
+	github.com/prometheus/client_golang v1.8.0
 	github.com/rs/zerolog v1.20.0
 	github.com/savsgio/atreugo/v11 v11.5.3
 	github.com/senyast4745/meilisearch-go v0.12.2-0.20201028233758-4a594e8eadaa
 	github.com/sirupsen/logrus v1.7.0 // indirect
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
 	github.com/testcontainers/testcontainers-go v0.9.0
 	github.com/valyala/fasthttp v1.16.0
 	github.com/valyala/fastjson v1.6.1
 	github.com/xlab/closer v0.0.0-20190328110542-03326addb7c2
-	golang.org/x/sys v0.0.0-20201009025420-dfb3f7c4e634 // indirect
-	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.opentelemetry.io/proto/otlp v0.9.0
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	golang.org/x/tools v0.0.0-20201105001634-bc3cf281b174 // indirect
-	google.golang.org/genproto v0.0.0-20191108220845-16a3f7862a1a // indirect
-	google.golang.org/grpc v1.21.1 // indirect
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
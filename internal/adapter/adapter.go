@@ -1,34 +1,73 @@
 package adapter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/polyse/logdb/internal/breaker"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/tracing"
 	"github.com/rs/zerolog/log"
 	ml "github.com/senyast4745/meilisearch-go"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fastjson"
-	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Adapter interface {
-	SaveData(data []byte, indexUid string) error
+	SaveData(ctx context.Context, data []byte, indexUid string) (*Receipt, error)
+	WaitForUpdate(ctx context.Context, indexUid string, updateId int64) error
+	Available() error
 	DatabaseHealthCheck() error
+	LogContext(indexUid, id string, req *ContextRequest) (*ContextResponse, error)
+	Search(indexUid string, req *SearchRequest) (*SearchResponse, error)
+	Fields(indexUid string) ([]string, error)
+	Indexes() ([]ml.Index, error)
+	DeleteIndex(indexUid string) error
+	IndexCache() (int, error)
+	HasIndex(indexUid string) bool
+	AllIndexStats() ([]IndexStats, error)
+	IndexStats(indexUid string) (*IndexStats, error)
+	ApplySettings(indexUid string) (int64, error)
+	ClearFields(indexUid string) error
+}
+
+// Observer is notified about every parsed record before it is saved.
+// The value must not be retained after Observe returns.
+type Observer interface {
+	Observe(indexUid string, val *fastjson.Value)
 }
 
 type SimpleAdapter struct {
 	c      ml.ClientInterface
+	cb     *breaker.Breaker
 	ind    map[string]*ml.Index
-	lock   sync.Mutex
+	loaded bool
+	lock   sync.RWMutex
 	pPool  fastjson.ParserPool
 	arPool fastjson.ArenaPool
-	keys   map[string]struct{}
-	kLock  sync.Mutex
+	// keys is the schema registry of every index, the field names seen in its records.
+	keys  map[string]map[string]struct{}
+	kLock sync.Mutex
+	seq   int64
+	obs   Observer
 }
 
 type Config struct {
 	ml.Config
 	Timeout time.Duration
+	Breaker breaker.Config
+}
+
+// Receipt identifies a saved record and the Meilisearch update storing it.
+type Receipt struct {
+	Id       string `json:"@id"`
+	UpdateId int64  `json:"updateId"`
 }
 
 type KeyData struct {
@@ -36,135 +75,388 @@ type KeyData struct {
 	Keys []string
 }
 
-const KeyId = "key"
+const (
+	KeyId        = "key"
+	IdKey        = "@id"
+	TimestampKey = "@timestamp"
+	// NanosKey holds the nanoseconds of the @timestamp second of records
+	// whose input knows when they were logged.
+	NanosKey = "@nanos"
+	SeqKey   = "@seq"
+
+	updatePollInterval = 50 * time.Millisecond
+)
+
+// ErrUpdateFailed is returned when Meilisearch could not apply an update.
+var ErrUpdateFailed = errors.New("update failed")
+
+type timestampKey struct{}
+
+// WithTimestamp returns a context saving records with the time they were
+// logged, which inputs such as Loki or syslog know. The @timestamp of a
+// record body is never trusted, records saved without a time get the time
+// they are saved.
+func WithTimestamp(ctx context.Context, ts time.Time) context.Context {
+	if ts.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, timestampKey{}, ts)
+}
+
+// Timestamp returns the time set by WithTimestamp, the zero time if there is none.
+func Timestamp(ctx context.Context) time.Time {
+	ts, _ := ctx.Value(timestampKey{}).(time.Time)
+	return ts
+}
+
+type documentIdKey struct{}
+
+// WithDocumentId returns a context saving records with the @id, so a record
+// saved again, such as a spooled one, replaces the first write instead of
+// being stored twice. Records saved without an id get a new one.
+func WithDocumentId(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, documentIdKey{}, id)
+}
+
+// DocumentId returns the id set by WithDocumentId, empty if there is none.
+func DocumentId(ctx context.Context) string {
+	id, _ := ctx.Value(documentIdKey{}).(string)
+	return id
+}
 
-func NewAdapter(conf *Config) (*SimpleAdapter, error) {
+func NewAdapter(conf *Config, obs Observer) (*SimpleAdapter, error) {
 	client := &fasthttp.Client{
 		WriteTimeout: conf.Timeout,
 		ReadTimeout:  conf.Timeout,
 	}
 	c := ml.NewFastHTTPCustomClient(conf.Config, client)
 
-	indexes, err := c.Indexes().List()
+	keys := make(map[string]map[string]struct{})
+	adapter := &SimpleAdapter{c: c, cb: breaker.NewBreaker(&conf.Breaker), ind: map[string]*ml.Index{}, keys: keys, obs: obs}
+	if err := adapter.loadIndexes(); err != nil {
+		log.Warn().Err(err).Msg("can not load indexes, adapter is not ready")
+	}
+	return adapter, nil
+}
+
+// loadIndexes fills the index cache with the indexes existing in Meilisearch.
+func (a *SimpleAdapter) loadIndexes() error {
+	var indexes []ml.Index
+	err := a.cb.Do(func() (err error) {
+		indexes, err = a.c.Indexes().List()
+		return dbError(err)
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	indexMap := map[string]*ml.Index{}
+	a.lock.Lock()
+	defer a.lock.Unlock()
 	for i := range indexes {
 		ind := indexes[i]
-		indexMap[ind.UID] = &ind
+		a.ind[ind.UID] = &ind
 	}
-	keys := make(map[string]struct{})
-	adapter := &SimpleAdapter{c: c, ind: indexMap, keys: keys}
-	return adapter, nil
+	a.loaded = true
+	metrics.IndexCacheSize.Set(float64(len(a.ind)))
+	return nil
+}
+
+// IndexCache returns the number of cached indexes. The cache is loaded
+// first if Meilisearch was unavailable when the adapter started.
+func (a *SimpleAdapter) IndexCache() (int, error) {
+	a.lock.RLock()
+	loaded := a.loaded
+	a.lock.RUnlock()
+	if !loaded {
+		if err := a.loadIndexes(); err != nil {
+			return 0, err
+		}
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return len(a.ind), nil
+}
+
+// HasIndex reports whether the index is known without asking Meilisearch.
+func (a *SimpleAdapter) HasIndex(indexUid string) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	_, ok := a.ind[indexUid]
+	return ok
+}
+
+// SaveData stores the record in the index. The span carried by ctx becomes
+// the parent of the spans recorded for every step of the save. Meilisearch
+// applies the returned update asynchronously, WaitForUpdate blocks until the
+// record is searchable.
+func (a *SimpleAdapter) SaveData(ctx context.Context, data []byte, indexUid string) (*Receipt, error) {
+	ctx, span := tracing.Start(ctx, "SaveData")
+	span.SetAttribute("logdb.tag", indexUid)
+	span.SetAttribute("logdb.size", len(data))
+	defer span.End()
+
+	start := time.Now()
+	receipt, err := a.saveData(ctx, data, indexUid)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		span.RecordError(err)
+	}
+	metrics.SaveDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	return receipt, err
 }
 
-func (a *SimpleAdapter) SaveData(data []byte, indexUid string) error {
+func (a *SimpleAdapter) saveData(ctx context.Context, data []byte, indexUid string) (*Receipt, error) {
+	_, span := tracing.Start(ctx, "getOrCreateIndex")
 	index, err := getOrCreateIndex(a, indexUid)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := a.pPool.Get()
 	defer a.pPool.Put(p)
 	log.Debug().Bytes("data", data).Msg("request data")
 
+	_, span = tracing.Start(ctx, "parse")
 	val, err := p.ParseBytes(data)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return err
+		return nil, apperrors.Wrap(apperrors.Client, err)
+	}
+	if a.obs != nil {
+		a.obs.Observe(indexUid, val)
 	}
 
 	ar := a.arPool.Get()
 	defer a.arPool.Put(ar)
 	defer ar.Reset()
 
-	timeF := time.Now().Unix()
-
-	val.Set("@id", ar.NewString(uuid.New().String()))
-	val.Set("@timestamp", ar.NewNumberInt(int(timeF)))
+	receipt := &Receipt{Id: DocumentId(ctx)}
+	if receipt.Id == "" {
+		receipt.Id = uuid.New().String()
+	}
+	val.Set(IdKey, ar.NewString(receipt.Id))
+	if ts := Timestamp(ctx); !ts.IsZero() {
+		val.Set(TimestampKey, ar.NewNumberInt(int(ts.Unix())))
+		val.Set(NanosKey, ar.NewNumberInt(ts.Nanosecond()))
+	} else {
+		val.Set(TimestampKey, ar.NewNumberInt(int(time.Now().Unix())))
+		val.Del(NanosKey)
+	}
+	val.Set(SeqKey, ar.NewNumberString(strconv.FormatInt(a.nextSeq(), 10)))
 
 	arr := ar.NewArray()
 	arr.SetArrayItem(0, val)
-	data = arr.MarshalTo(data[:0])
-	raw := data
+	// the caller keeps its record, which is spooled if the write fails
+	data = arr.MarshalTo(nil)
+	raw := ml.RawType(data)
 
-	_, err = a.c.Documents(index.UID).AddOrReplace(raw)
+	// writes cancelled meanwhile are spooled by the caller
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span = tracing.Start(ctx, "AddOrReplace")
+	var upd *ml.AsyncUpdateID
+	err = a.cb.Do(func() (err error) {
+		upd, err = a.c.Documents(index.UID).AddOrReplace(raw)
+		return dbError(err)
+	})
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if o, err := val.Object(); err != nil {
-		return err
-	} else {
-		var strData string
-		nKeys := false
-		o.Visit(func(key []byte, v *fastjson.Value) {
-			strData = string(key)
-			if _, ok := a.keys[strData]; !ok {
-				a.kLock.Lock()
-				defer a.kLock.Unlock()
-				if _, ok := a.keys[strData]; !ok {
-					nKeys = true
-					a.keys[strData] = struct{}{}
-				}
-			}
+	if upd != nil {
+		receipt.UpdateId = upd.UpdateID
+	}
+	o, err := val.Object()
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.Client, err)
+	}
+	kData, err := a.registerKeys(indexUid, o)
+	if err != nil || kData == nil {
+		return receipt, err
+	}
+	_, span = tracing.Start(ctx, "saveKeys")
+	defer span.End()
+	span.SetAttribute("logdb.keys", len(kData.Keys))
+	err = a.cb.Do(func() error {
+		_, err := a.c.Documents(index.UID).AddOrReplace(kData)
+		return dbError(err)
+	})
+	span.RecordError(err)
+	return receipt, err
+}
+
+// WaitForUpdate polls the update of the index until Meilisearch processed
+// it or the context is done. A failed update returns ErrUpdateFailed.
+func (a *SimpleAdapter) WaitForUpdate(ctx context.Context, indexUid string, updateId int64) error {
+	ctx, span := tracing.Start(ctx, "WaitForUpdate")
+	span.SetAttribute("logdb.update_id", updateId)
+	defer span.End()
+
+	t := time.NewTicker(updatePollInterval)
+	defer t.Stop()
+	for {
+		var upd *ml.Update
+		err := a.cb.Do(func() (err error) {
+			upd, err = a.c.Updates(indexUid).Get(updateId)
+			return dbError(err)
 		})
-		if nKeys {
-			kData := &KeyData{
-				Id:   KeyId,
-				Keys: make([]string, 0, len(a.keys)),
-			}
-			for k := range a.keys {
-				kData.Keys = append(kData.Keys, k)
-			}
-			_, err = a.c.Documents(index.UID).AddOrReplace(kData)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		switch upd.Status {
+		case ml.UpdateStatusProcessed:
+			return nil
+		case ml.UpdateStatusFailed:
+			err = fmt.Errorf("%w: %s", ErrUpdateFailed, upd.Error)
+			span.RecordError(err)
 			return err
 		}
+		select {
+		case <-ctx.Done():
+			span.RecordError(ctx.Err())
+			return ctx.Err()
+		case <-t.C:
+		}
 	}
+}
 
-	return nil
+// registerKeys adds the fields of the record to the schema registry of the index.
+// The registry is read from the index first, so fields saved before a restart
+// are kept. It returns the registry document to save if a field is new.
+func (a *SimpleAdapter) registerKeys(indexUid string, o *fastjson.Object) (*KeyData, error) {
+	a.kLock.Lock()
+	defer a.kLock.Unlock()
+	keys, ok := a.keys[indexUid]
+	if !ok {
+		stored := &KeyData{}
+		err := a.cb.Do(func() error {
+			return notFoundOr(a.c.Documents(indexUid).Get(KeyId, stored))
+		})
+		if err != nil {
+			if err != ErrNotFound {
+				return nil, err
+			}
+		}
+		keys = make(map[string]struct{}, len(stored.Keys))
+		for _, k := range stored.Keys {
+			keys[k] = struct{}{}
+		}
+		a.keys[indexUid] = keys
+	}
+	nKeys := false
+	o.Visit(func(key []byte, _ *fastjson.Value) {
+		if _, ok := keys[string(key)]; !ok {
+			nKeys = true
+			keys[string(key)] = struct{}{}
+		}
+	})
+	if !nKeys {
+		return nil, nil
+	}
+	kData := &KeyData{
+		Id:   KeyId,
+		Keys: make([]string, 0, len(keys)),
+	}
+	for k := range keys {
+		kData.Keys = append(kData.Keys, k)
+	}
+	return kData, nil
+}
+
+// nextSeq returns a strictly increasing sequence number that breaks ties
+// between records saved within the same @timestamp second. It is based on
+// wall clock microseconds, so it keeps growing across restarts and stays
+// exactly representable as a JSON number.
+func (a *SimpleAdapter) nextSeq() int64 {
+	for {
+		last := atomic.LoadInt64(&a.seq)
+		next := time.Now().UnixNano() / int64(time.Microsecond)
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&a.seq, last, next) {
+			return next
+		}
+	}
 }
 
 func getOrCreateIndex(a *SimpleAdapter, indexUid string) (index *ml.Index, err error) {
 	var ok bool
 	log.Debug().Str("index uid", indexUid).Msg("start finding index by uid")
-	if index, ok = a.ind[indexUid]; !ok {
-
+	a.lock.RLock()
+	index, ok = a.ind[indexUid]
+	a.lock.RUnlock()
+	if !ok {
 		a.lock.Lock()
 		defer a.lock.Unlock()
 		if index, ok = a.ind[indexUid]; !ok {
-			apiInd := a.c.Indexes()
-			if index, err = apiInd.Get(indexUid); index == nil {
-				if cliErr, ok := err.(*ml.Error); ok {
-					if cliErr.StatusCode != http.StatusNotFound {
-						return nil, err
-					}
-				} else if err != nil {
-					return nil, err
-				}
-				crInd := ml.CreateIndexRequest{
-					UID: indexUid,
-				}
-				if indResp, err := apiInd.Create(crInd); err == nil {
-					log.Debug().Interface("created", indResp).Msg("index created")
-					index = &ml.Index{
-						Name:       indResp.Name,
-						UID:        indResp.UID,
-						CreatedAt:  indResp.CreatedAt,
-						UpdatedAt:  indResp.UpdatedAt,
-						PrimaryKey: indResp.PrimaryKey,
-					}
-				} else {
-					return nil, err
-				}
-			} else if err != nil {
+			if index, err = a.fetchIndex(indexUid); err != nil {
 				return nil, err
 			}
 		}
 		a.ind[index.UID] = index
+		metrics.IndexCacheSize.Set(float64(len(a.ind)))
 	}
 	log.Debug().Str("index uid", index.UID).Msg("using index")
 	return index, nil
 }
 
+// fetchIndex gets the index from Meilisearch and creates it if it does not exist.
+func (a *SimpleAdapter) fetchIndex(indexUid string) (*ml.Index, error) {
+	apiInd := a.c.Indexes()
+	var index *ml.Index
+	err := a.cb.Do(func() (err error) {
+		index, err = apiInd.Get(indexUid)
+		return notFoundOr(err)
+	})
+	switch {
+	case err == nil && index != nil:
+		return index, nil
+	case err != nil && err != ErrNotFound:
+		return nil, err
+	}
+	var indResp *ml.CreateIndexResponse
+	err = a.cb.Do(func() (err error) {
+		indResp, err = apiInd.Create(ml.CreateIndexRequest{UID: indexUid})
+		return dbError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Interface("created", indResp).Msg("index created")
+	if _, err = a.ApplySettings(indexUid); err != nil {
+		log.Warn().Err(err).Str("index uid", indexUid).Msg("can not apply index settings")
+	}
+	return &ml.Index{
+		Name:       indResp.Name,
+		UID:        indResp.UID,
+		CreatedAt:  indResp.CreatedAt,
+		UpdatedAt:  indResp.UpdatedAt,
+		PrimaryKey: indResp.PrimaryKey,
+	}, nil
+}
+
 func (a *SimpleAdapter) DatabaseHealthCheck() error {
-	return a.c.Health().Get()
+	return a.cb.Do(func() error {
+		return dbError(a.c.Health().Get())
+	})
+}
+
+// Available fails with a breaker.OpenError while calls to Meilisearch are rejected.
+func (a *SimpleAdapter) Available() error {
+	return a.cb.Available()
+}
+
+// dbError counts a failed Meilisearch call and returns the error unchanged.
+func dbError(err error) error {
+	metrics.ObserveDbError(err)
+	return err
 }
@@ -69,7 +69,7 @@ func (s *AdapterIntegrationTestSuite) SetupTest() {
 		Config:  ml.Config{Host: address},
 		Timeout: 1 * time.Second,
 	}
-	adapter, err := NewAdapter(cfg)
+	adapter, err := NewAdapter(cfg, nil)
 	s.NoError(err)
 
 	s.adapter = adapter
@@ -136,6 +136,7 @@ func (s *AdapterIntegrationTestSuite) Test_SaveData_Normal_Mode() {
 		"@id":        {},
 		"test":       {},
 		"@timestamp": {},
+		"@seq":       {},
 	}
 
 	bytesData, err := json.Marshal(testData)
@@ -143,7 +144,7 @@ func (s *AdapterIntegrationTestSuite) Test_SaveData_Normal_Mode() {
 
 	startTime := time.Now()
 
-	err = s.adapter.SaveData(bytesData, testIndexUid)
+	_, err = s.adapter.SaveData(context.Background(), bytesData, testIndexUid)
 
 	s.NoError(err)
 
@@ -172,7 +173,7 @@ func (s *AdapterIntegrationTestSuite) Test_SaveData_Normal_Mode() {
 	err = mapstructure.Decode(data, &actual)
 	s.WithinDuration(startTime, time.Unix(actual.Timestamp, 0), 3*time.Second)
 	s.Equal(testData.Test, actual.Test)
-	s.Equal(expKeys, s.adapter.keys)
+	s.Equal(expKeys, s.adapter.keys[testIndexUid])
 }
 
 func (s *AdapterIntegrationTestSuite) Test_SaveData_Parse_Error() {
@@ -180,7 +181,7 @@ func (s *AdapterIntegrationTestSuite) Test_SaveData_Parse_Error() {
 
 	incorrectData := []byte("{\"test:")
 
-	err := s.adapter.SaveData(incorrectData, testIndexUid)
+	_, err := s.adapter.SaveData(context.Background(), incorrectData, testIndexUid)
 	s.Error(err)
 
 }
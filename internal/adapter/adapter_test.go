@@ -1,12 +1,15 @@
 package adapter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/polyse/logdb/test/mocks"
 	ml "github.com/senyast4745/meilisearch-go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"github.com/valyala/fastjson"
 	"net/http"
 	"testing"
 	"time"
@@ -27,7 +30,7 @@ func (s *AdapterUnitTestSuite) SetupTest() {
 		ind: map[string]*ml.Index{
 			"test": {},
 		},
-		keys: make(map[string]struct{}),
+		keys: make(map[string]map[string]struct{}),
 	}
 	s.adapter = adapter
 	s.mockClient = mockClient
@@ -142,9 +145,13 @@ func (s *AdapterUnitTestSuite) Test_GetOrCreateIndex_If_Not_Present_In_Local_Map
 	}, nil)
 
 	s.mockClient.On("Indexes", mock.Anything).Return(mockIndex)
+	mockSettings := new(mocks.APISettings)
+	mockSettings.On("UpdateAll", IndexSettings).Times(1).Return(&ml.AsyncUpdateID{UpdateID: 1}, nil)
+	s.mockClient.On("Settings", indexNotFound).Return(mockSettings)
 
 	index, err := getOrCreateIndex(s.adapter, indexNotFound)
 	s.NoError(err)
+	mockSettings.AssertExpectations(s.T())
 	expected := &ml.Index{
 		Name:       indexNotFound,
 		UID:        indexNotFound,
@@ -162,10 +169,11 @@ func (s *AdapterUnitTestSuite) Test_GetOrCreateIndex_If_Not_Present_In_Local_Map
 func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Presents_local() {
 	// given
 
-	s.adapter.keys = map[string]struct{}{
+	s.adapter.keys["test"] = map[string]struct{}{
 		"test":       {},
 		"@timestamp": {},
 		"@id":        {},
+		"@seq":       {},
 	}
 	testIndexUid := "test"
 	testData := struct {
@@ -186,6 +194,7 @@ func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Presents_local() {
 		Timestamp int64  `json:"@timestamp"`
 	}
 
+	var savedId string
 	mockDocuments.On("AddOrReplace", mock.Anything).Times(1).Run(func(args mock.Arguments) {
 		actualRaw := args.Get(0).(ml.RawType)
 		actual := make([]testActualData, 0)
@@ -194,18 +203,90 @@ func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Presents_local() {
 		s.NoError(err)
 		s.Equal(testData.Test, actual[0].Test)
 		s.WithinDuration(startTestTime, time.Unix(actual[0].Timestamp, 0), 1*time.Second)
-	}).Return(nil, nil)
+		savedId = actual[0].Id
+	}).Return(&ml.AsyncUpdateID{UpdateID: 7}, nil)
 
 	s.mockClient.On("Documents", mock.Anything).Return(mockDocuments)
 
 	//when
-	err = s.adapter.SaveData(bytesData, testIndexUid)
+	receipt, err := s.adapter.SaveData(context.Background(), bytesData, testIndexUid)
 
 	//then
 	s.NoError(err)
+	s.Equal(&Receipt{Id: savedId, UpdateId: 7}, receipt)
+	mockDocuments.AssertExpectations(s.T())
+}
+
+func (s *AdapterUnitTestSuite) Test_SaveData_Timestamp() {
+	s.adapter.keys["test"] = map[string]struct{}{
+		"test":       {},
+		"@timestamp": {},
+		"@nanos":     {},
+		"@id":        {},
+		"@seq":       {},
+	}
+	mockDocuments := new(mocks.APIDocuments)
+	var saved []map[string]interface{}
+	mockDocuments.On("AddOrReplace", mock.Anything).Times(2).Run(func(args mock.Arguments) {
+		saved = nil
+		s.NoError(json.Unmarshal(args.Get(0).(ml.RawType), &saved))
+	}).Return(&ml.AsyncUpdateID{}, nil)
+	s.mockClient.On("Documents", mock.Anything).Return(mockDocuments)
+
+	ctx := WithTimestamp(context.Background(), time.Unix(1600000000, 5))
+	_, err := s.adapter.SaveData(ctx, []byte(`{"test":"a","@timestamp":1,"@nanos":2}`), "test")
+	s.NoError(err)
+	s.Equal(float64(1600000000), saved[0][TimestampKey], "records keep the time of their input")
+	s.Equal(float64(5), saved[0][NanosKey])
+
+	_, err = s.adapter.SaveData(context.Background(), []byte(`{"test":"a","@timestamp":1,"@nanos":2}`), "test")
+	s.NoError(err)
+	s.InDelta(float64(time.Now().Unix()), saved[0][TimestampKey], 1, "the timestamp of the body is replaced")
+	s.NotContains(saved[0], NanosKey)
 	mockDocuments.AssertExpectations(s.T())
 }
 
+func (s *AdapterUnitTestSuite) Test_SaveData_DocumentId() {
+	s.adapter.keys["test"] = map[string]struct{}{"test": {}, "@timestamp": {}, "@id": {}, "@seq": {}}
+	mockDocuments := new(mocks.APIDocuments)
+	var saved []map[string]interface{}
+	mockDocuments.On("AddOrReplace", mock.Anything).Once().Run(func(args mock.Arguments) {
+		s.NoError(json.Unmarshal(args.Get(0).(ml.RawType), &saved))
+	}).Return(&ml.AsyncUpdateID{}, nil)
+	s.mockClient.On("Documents", mock.Anything).Return(mockDocuments)
+
+	receipt, err := s.adapter.SaveData(WithDocumentId(context.Background(), "spooled"), []byte(`{"test":"a"}`), "test")
+	s.NoError(err)
+	s.Equal("spooled", receipt.Id)
+	s.Equal("spooled", saved[0][IdKey], "a replayed record replaces its first write")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.adapter.SaveData(ctx, []byte(`{"test":"a"}`), "test")
+	s.Equal(context.Canceled, err)
+	mockDocuments.AssertExpectations(s.T())
+}
+
+func (s *AdapterUnitTestSuite) Test_WaitForUpdate() {
+	mockUpdates := new(mocks.APIUpdates)
+	mockUpdates.On("Get", int64(7)).Return(&ml.Update{Status: ml.UpdateStatusEnqueued}, nil).Once()
+	mockUpdates.On("Get", int64(7)).Return(&ml.Update{Status: ml.UpdateStatusProcessed}, nil).Once()
+	mockUpdates.On("Get", int64(8)).Return(&ml.Update{Status: ml.UpdateStatusFailed, Error: "invalid document"}, nil)
+	mockUpdates.On("Get", int64(9)).Return(&ml.Update{Status: ml.UpdateStatusEnqueued}, nil)
+	s.mockClient.On("Updates", "test").Return(mockUpdates)
+
+	s.NoError(s.adapter.WaitForUpdate(context.Background(), "test", 7))
+	mockUpdates.AssertNumberOfCalls(s.T(), "Get", 2)
+
+	err := s.adapter.WaitForUpdate(context.Background(), "test", 8)
+	s.True(errors.Is(err, ErrUpdateFailed))
+	s.Contains(err.Error(), "invalid document")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.Equal(context.DeadlineExceeded, s.adapter.WaitForUpdate(ctx, "test", 9))
+}
+
 func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Not_Presents_local() {
 	// given
 	testIndexUid := "test"
@@ -227,6 +308,7 @@ func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Not_Presents_local() {
 		Id        string `json:"@id"`
 	}
 
+	mockDocuments.On("Get", KeyId, mock.Anything).Return(&ml.Error{StatusCode: http.StatusNotFound})
 	mockDocuments.On("AddOrReplace", mock.Anything).Times(2).Run(func(args mock.Arguments) {
 		if actualRaw, ok := args.Get(0).(ml.RawType); ok {
 			actual := make([]testActualData, 0)
@@ -244,14 +326,15 @@ func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Not_Presents_local() {
 		"test":       {},
 		"@timestamp": {},
 		"@id":        {},
+		"@seq":       {},
 	}
 
 	//when
-	err = s.adapter.SaveData(bytesData, testIndexUid)
+	_, err = s.adapter.SaveData(context.Background(), bytesData, testIndexUid)
 
 	//then
 	s.NoError(err)
-	s.Equal(expKeys, s.adapter.keys)
+	s.Equal(expKeys, s.adapter.keys[testIndexUid])
 	mockDocuments.AssertExpectations(s.T())
 }
 
@@ -271,8 +354,316 @@ func (s *AdapterUnitTestSuite) Test_SaveData_With_Error() {
 
 	s.mockClient.On("Documents", mock.Anything).Return(mockDocuments)
 
-	err = s.adapter.SaveData(bytesData, testIndexUid)
+	_, err = s.adapter.SaveData(context.Background(), bytesData, testIndexUid)
 	s.Error(err)
 	s.Empty(s.adapter.keys)
+	s.JSONEq(`{"Test":"test message"}`, string(bytesData), "the record of the caller is not changed")
 	mockDocuments.AssertExpectations(s.T())
 }
+
+func (s *AdapterUnitTestSuite) Test_SaveData_Keys_Per_Index() {
+	s.adapter.ind["test"] = &ml.Index{UID: "test"}
+	s.adapter.ind["other"] = &ml.Index{UID: "other"}
+	saved := make(map[string][]string)
+	for _, uid := range []string{"test", "other"} {
+		uid := uid
+		mockDocuments := new(mocks.APIDocuments)
+		mockDocuments.On("Get", KeyId, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(*KeyData).Keys = []string{"stored"}
+		}).Return(nil).Once()
+		mockDocuments.On("AddOrReplace", mock.Anything).Run(func(args mock.Arguments) {
+			if kData, ok := args.Get(0).(*KeyData); ok {
+				saved[uid] = kData.Keys
+			}
+		}).Return(nil, nil)
+		s.mockClient.On("Documents", uid).Return(mockDocuments)
+	}
+
+	_, err := s.adapter.SaveData(context.Background(), []byte(`{"a":1}`), "test")
+	s.NoError(err)
+	_, err = s.adapter.SaveData(context.Background(), []byte(`{"b":1}`), "other")
+	s.NoError(err)
+
+	s.ElementsMatch([]string{"stored", "a", "@id", "@timestamp", "@seq"}, saved["test"])
+	s.ElementsMatch([]string{"stored", "b", "@id", "@timestamp", "@seq"}, saved["other"])
+}
+
+func (s *AdapterUnitTestSuite) Test_LogContext_Normal() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", "anchor", mock.Anything).Run(func(args mock.Arguments) {
+		doc := args.Get(1).(*Document)
+		(*doc)[IdKey] = "anchor"
+		(*doc)[TimestampKey] = float64(100)
+		(*doc)[SeqKey] = float64(5)
+		(*doc)["host"] = "a"
+	}).Return(nil)
+
+	hit := func(id string, ts, seq float64) interface{} {
+		return map[string]interface{}{IdKey: id, TimestampKey: ts, SeqKey: seq, "host": "a"}
+	}
+	mockSearch := new(mocks.APISearch)
+	mockSearch.On("Search", mock.MatchedBy(func(r ml.SearchRequest) bool {
+		return r.Filters == `@timestamp > 40 AND @timestamp <= 100 AND host = "a"`
+	})).Return(&ml.SearchResponse{
+		Hits:   []interface{}{hit("b1", 90, 1), hit("b2", 100, 4), hit("anchor", 100, 5), hit("a0", 100, 6)},
+		NbHits: 4,
+	}, nil)
+	mockSearch.On("Search", mock.MatchedBy(func(r ml.SearchRequest) bool {
+		return r.Filters == `@timestamp >= 100 AND @timestamp < 160 AND host = "a"`
+	})).Return(&ml.SearchResponse{
+		Hits:   []interface{}{hit("b2", 100, 4), hit("anchor", 100, 5), hit("a1", 100, 7), hit("a0", 100, 6)},
+		NbHits: 4,
+	}, nil)
+
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+	s.mockClient.On("Search", "test").Return(mockSearch)
+
+	resp, err := s.adapter.LogContext("test", "anchor", &ContextRequest{
+		Before: 1,
+		After:  2,
+		By:     []string{"host"},
+		Span:   time.Hour,
+	})
+	s.NoError(err)
+	s.Equal("anchor", resp.Anchor.Id())
+	s.Len(resp.Before, 1)
+	s.Equal("b2", resp.Before[0].Id())
+	s.Len(resp.After, 2)
+	s.Equal("a0", resp.After[0].Id())
+	s.Equal("a1", resp.After[1].Id())
+}
+
+func (s *AdapterUnitTestSuite) Test_LogContext_Narrows_Dense_Window() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", "anchor", mock.Anything).Run(func(args mock.Arguments) {
+		doc := args.Get(1).(*Document)
+		(*doc)[IdKey] = "anchor"
+		(*doc)[TimestampKey] = float64(100)
+	}).Return(nil)
+
+	mockSearch := new(mocks.APISearch)
+	mockSearch.On("Search", mock.MatchedBy(func(r ml.SearchRequest) bool {
+		return r.Filters == "@timestamp > 40 AND @timestamp <= 100"
+	})).Return(&ml.SearchResponse{NbHits: contextPageLimit + 1}, nil).Once()
+	mockSearch.On("Search", mock.MatchedBy(func(r ml.SearchRequest) bool {
+		return r.Filters == "@timestamp > 70 AND @timestamp <= 100"
+	})).Return(&ml.SearchResponse{
+		Hits:   []interface{}{map[string]interface{}{IdKey: "b1", TimestampKey: float64(99)}},
+		NbHits: 1,
+	}, nil).Once()
+
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+	s.mockClient.On("Search", "test").Return(mockSearch)
+
+	resp, err := s.adapter.LogContext("test", "anchor", &ContextRequest{Before: 1, Span: time.Hour})
+	s.NoError(err)
+	s.Len(resp.Before, 1)
+	s.Empty(resp.After)
+	mockSearch.AssertExpectations(s.T())
+}
+
+func (s *AdapterUnitTestSuite) Test_LogContext_Missing_Field() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", "anchor", mock.Anything).Run(func(args mock.Arguments) {
+		doc := args.Get(1).(*Document)
+		(*doc)[IdKey] = "anchor"
+		(*doc)[TimestampKey] = float64(100)
+	}).Return(nil)
+
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+
+	_, err := s.adapter.LogContext("test", "anchor", &ContextRequest{By: []string{"host"}})
+	s.True(errors.Is(err, ErrMissingField))
+}
+
+func (s *AdapterUnitTestSuite) Test_LogContext_Invalid_Field() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", "anchor", mock.Anything).Run(func(args mock.Arguments) {
+		doc := args.Get(1).(*Document)
+		(*doc)[IdKey] = "anchor"
+		(*doc)[TimestampKey] = float64(100)
+		(*doc)["host = a OR level"] = "b"
+	}).Return(nil)
+	mockSearch := new(mocks.APISearch)
+
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+	s.mockClient.On("Search", "test").Return(mockSearch)
+
+	_, err := s.adapter.LogContext("test", "anchor", &ContextRequest{Before: 1, By: []string{"host = a OR level"}})
+	s.True(errors.Is(err, ErrInvalidField))
+	mockSearch.AssertNotCalled(s.T(), "Search", mock.Anything)
+}
+
+func (s *AdapterUnitTestSuite) Test_LogContext_Not_Found() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", "anchor", mock.Anything).Return(&ml.Error{StatusCode: http.StatusNotFound})
+
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+
+	_, err := s.adapter.LogContext("test", "anchor", &ContextRequest{})
+	s.Equal(ErrNotFound, err)
+}
+
+type observerFunc func(indexUid string, val *fastjson.Value)
+
+func (f observerFunc) Observe(indexUid string, val *fastjson.Value) {
+	f(indexUid, val)
+}
+
+func (s *AdapterUnitTestSuite) Test_SaveData_Notifies_Observer() {
+	var observed string
+	s.adapter.obs = observerFunc(func(indexUid string, val *fastjson.Value) {
+		observed = indexUid + ":" + string(val.GetStringBytes("level"))
+	})
+
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", KeyId, mock.Anything).Return(&ml.Error{StatusCode: http.StatusNotFound})
+	mockDocuments.On("AddOrReplace", mock.Anything).Return(nil, nil)
+	s.mockClient.On("Documents", mock.Anything).Return(mockDocuments)
+
+	_, err := s.adapter.SaveData(context.Background(), []byte(`{"level":"error"}`), "test")
+	s.NoError(err)
+	s.Equal("test:error", observed)
+}
+
+func (s *AdapterUnitTestSuite) Test_Search_Skips_Key_Registry() {
+	mockSearch := new(mocks.APISearch)
+	mockSearch.On("Search", ml.SearchRequest{Limit: 20, PlaceholderSearch: true}).Return(&ml.SearchResponse{
+		Hits: []interface{}{
+			map[string]interface{}{IdKey: KeyId, "Keys": []interface{}{"test"}},
+			map[string]interface{}{IdKey: "1", "test": "message"},
+		},
+		NbHits: 2,
+		Limit:  20,
+	}, nil)
+	s.mockClient.On("Search", "test").Return(mockSearch)
+
+	resp, err := s.adapter.Search("test", &SearchRequest{Limit: 20})
+	s.NoError(err)
+	s.Len(resp.Hits, 1)
+	s.Equal("1", resp.Hits[0].Id())
+	s.Equal(int64(2), resp.Total)
+}
+
+func (s *AdapterUnitTestSuite) Test_Fields() {
+	mockDocuments := new(mocks.APIDocuments)
+	mockDocuments.On("Get", KeyId, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(*KeyData).Keys = []string{"b", "a"}
+	}).Return(nil)
+	s.mockClient.On("Documents", "test").Return(mockDocuments)
+
+	fields, err := s.adapter.Fields("test")
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, fields)
+}
+
+func (s *AdapterUnitTestSuite) Test_DeleteIndex_Evicts_Cache() {
+	mockIndex := new(mocks.APIIndexes)
+	mockIndex.On("Delete", "test").Run(func(mock.Arguments) {
+		s.True(s.adapter.HasIndex("test"), "lookups do not wait for Meilisearch")
+	}).Return(true, nil)
+	s.mockClient.On("Indexes").Return(mockIndex)
+
+	s.NoError(s.adapter.DeleteIndex("test"))
+	s.NotContains(s.adapter.ind, "test")
+
+	mockIndex = new(mocks.APIIndexes)
+	mockIndex.On("Delete", "missing").Return(false, &ml.Error{StatusCode: http.StatusNotFound})
+	s.mockClient.ExpectedCalls = nil
+	s.mockClient.On("Indexes").Return(mockIndex)
+	s.Equal(ErrNotFound, s.adapter.DeleteIndex("missing"))
+}
+
+func (s *AdapterUnitTestSuite) Test_DeleteIndex_While_Saving() {
+	mockIndex := new(mocks.APIIndexes)
+	mockIndex.On("Delete", "test").Return(true, nil)
+	mockIndex.On("Get", "test").Return(&ml.Index{UID: "test"}, nil)
+	s.mockClient.On("Indexes").Return(mockIndex)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = s.adapter.DeleteIndex("test")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, err := getOrCreateIndex(s.adapter, "test")
+		s.NoError(err)
+	}
+	<-done
+}
+
+func (s *AdapterUnitTestSuite) Test_IndexCache_Loads_Lazily() {
+	mockIndex := new(mocks.APIIndexes)
+	mockIndex.On("List").Return(nil, testErr).Once()
+	mockIndex.On("List").Return([]ml.Index{{UID: "other"}}, nil).Once()
+	s.mockClient.On("Indexes").Return(mockIndex)
+
+	_, err := s.adapter.IndexCache()
+	s.Error(err)
+
+	size, err := s.adapter.IndexCache()
+	s.NoError(err)
+	s.Equal(2, size)
+
+	size, err = s.adapter.IndexCache()
+	s.NoError(err)
+	s.Equal(2, size)
+	mockIndex.AssertNumberOfCalls(s.T(), "List", 2)
+}
+
+func (s *AdapterUnitTestSuite) Test_Validate() {
+	s.NoError(Validate([]byte(` {"message":"test"} `), 0))
+	for data, reason := range map[string]string{
+		"\n":                   ReasonEmpty,
+		`{"message":"test"}  `: ReasonTooLarge,
+		`{"message":}`:         ReasonMalformed,
+		`{"a":1}{"b":2}`:       ReasonMalformed,
+		`"message"`:            ReasonNotObject,
+	} {
+		err := Validate([]byte(data), 19)
+		s.True(errors.Is(err, ErrInvalidRecord), data)
+		var vErr *ValidationError
+		s.True(errors.As(err, &vErr), data)
+		s.Equal(reason, vErr.Reason, data)
+	}
+}
+
+func (s *AdapterUnitTestSuite) Test_AllIndexStats() {
+	mockIndex := new(mocks.APIIndexes)
+	mockIndex.On("List").Return([]ml.Index{{UID: "app"}, {UID: "team-a__app"}}, nil)
+	s.mockClient.On("Indexes").Return(mockIndex)
+	mockStats := new(mocks.APIStats)
+	mockStats.On("GetAll").Return(&ml.Stats{Indexes: map[string]ml.StatsIndex{
+		"app": {NumberOfDocuments: 3, IsIndexing: true},
+	}}, nil)
+	s.mockClient.On("Stats").Return(mockStats)
+
+	stats, err := s.adapter.AllIndexStats()
+	s.NoError(err)
+	s.Equal([]IndexStats{
+		{UID: "app", Documents: 3, IsIndexing: true},
+		{UID: "team-a__app"},
+	}, stats)
+}
+
+func (s *AdapterUnitTestSuite) Test_IndexStats_Not_Found() {
+	mockIndex := new(mocks.APIIndexes)
+	mockIndex.On("Get", "app").Return(nil, &ml.Error{StatusCode: http.StatusNotFound})
+	s.mockClient.On("Indexes").Return(mockIndex)
+
+	_, err := s.adapter.IndexStats("app")
+	s.Equal(ErrNotFound, err)
+}
+
+func (s *AdapterUnitTestSuite) Test_ClearFields() {
+	s.adapter.keys["test"] = map[string]struct{}{"message": {}}
+	mockDocs := new(mocks.APIDocuments)
+	mockDocs.On("Delete", KeyId).Times(1).Return(&ml.AsyncUpdateID{UpdateID: 3}, nil)
+	s.mockClient.On("Documents", "test").Return(mockDocs)
+
+	s.NoError(s.adapter.ClearFields("test"))
+	s.NotContains(s.adapter.keys, "test")
+	mockDocs.AssertExpectations(s.T())
+}
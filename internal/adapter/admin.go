@@ -0,0 +1,110 @@
+package adapter
+
+import (
+	ml "github.com/senyast4745/meilisearch-go"
+	"time"
+)
+
+// IndexSettings are applied to the indexes the adapter creates and again by
+// the admin API, equally relevant records rank newest first.
+var IndexSettings = ml.Settings{
+	RankingRules: []string{"typo", "words", "proximity", "attribute", "wordsPosition", "exactness",
+		"desc(" + TimestampKey + ")", "desc(" + SeqKey + ")"},
+}
+
+// IndexStats describes an index with the statistics of Meilisearch.
+type IndexStats struct {
+	UID             string           `json:"uid"`
+	PrimaryKey      string           `json:"primaryKey,omitempty"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	UpdatedAt       time.Time        `json:"updatedAt"`
+	Documents       int64            `json:"documents"`
+	IsIndexing      bool             `json:"isIndexing"`
+	FieldsFrequency map[string]int64 `json:"fieldsFrequency,omitempty"`
+}
+
+// AllIndexStats returns every index with its document count.
+func (a *SimpleAdapter) AllIndexStats() ([]IndexStats, error) {
+	indexes, err := a.Indexes()
+	if err != nil {
+		return nil, err
+	}
+	var stats *ml.Stats
+	err = a.cb.Do(func() (err error) {
+		stats, err = a.c.Stats().GetAll()
+		return dbError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]IndexStats, 0, len(indexes))
+	for _, ind := range indexes {
+		st := stats.Indexes[ind.UID]
+		res = append(res, newIndexStats(&ind, &st))
+	}
+	return res, nil
+}
+
+func newIndexStats(ind *ml.Index, st *ml.StatsIndex) IndexStats {
+	return IndexStats{
+		UID:        ind.UID,
+		PrimaryKey: ind.PrimaryKey,
+		CreatedAt:  ind.CreatedAt,
+		UpdatedAt:  ind.UpdatedAt,
+		Documents:  st.NumberOfDocuments,
+		IsIndexing: st.IsIndexing,
+	}
+}
+
+// IndexStats returns the index with its document count and the frequency of its fields.
+func (a *SimpleAdapter) IndexStats(indexUid string) (*IndexStats, error) {
+	var ind *ml.Index
+	err := a.cb.Do(func() (err error) {
+		ind, err = a.c.Indexes().Get(indexUid)
+		return notFoundOr(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var st *ml.StatsIndex
+	err = a.cb.Do(func() (err error) {
+		st, err = a.c.Stats().Get(indexUid)
+		return notFoundOr(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := newIndexStats(ind, st)
+	res.FieldsFrequency = st.FieldsFrequency
+	return &res, nil
+}
+
+// ApplySettings updates the settings of the index to IndexSettings and
+// returns the id of the update.
+func (a *SimpleAdapter) ApplySettings(indexUid string) (int64, error) {
+	var upd *ml.AsyncUpdateID
+	err := a.cb.Do(func() (err error) {
+		upd, err = a.c.Settings(indexUid).UpdateAll(IndexSettings)
+		return notFoundOr(err)
+	})
+	if err != nil || upd == nil {
+		return 0, err
+	}
+	return upd.UpdateID, nil
+}
+
+// ClearFields deletes the schema registry of the index, it is built again
+// from the records saved afterwards.
+func (a *SimpleAdapter) ClearFields(indexUid string) error {
+	a.kLock.Lock()
+	defer a.kLock.Unlock()
+	err := a.cb.Do(func() error {
+		_, err := a.c.Documents(indexUid).Delete(KeyId)
+		return notFoundOr(err)
+	})
+	if err != nil {
+		return err
+	}
+	delete(a.keys, indexUid)
+	return nil
+}
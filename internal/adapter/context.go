@@ -0,0 +1,234 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	ml "github.com/senyast4745/meilisearch-go"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested document does not exist.
+	ErrNotFound = errors.New("document not found")
+	// ErrMissingField is returned when a context field is absent in the anchor document.
+	ErrMissingField = errors.New("anchor document has no such field")
+	// ErrInvalidField is returned for context field names which can not be used in a filter.
+	ErrInvalidField = errors.New("field must only contain letters, digits, @, - and _")
+)
+
+// fieldPattern matches the attribute names which can be put into a filter as is.
+var fieldPattern = regexp.MustCompile(`^[a-zA-Z0-9@_-]+$`)
+
+const (
+	// contextWindow is the width in seconds of the first time-bounded query on each side of the anchor.
+	contextWindow = 60
+	// contextPageLimit is the maximum number of hits requested by a single window query.
+	contextPageLimit = 1000
+)
+
+// Document is a stored log record.
+type Document map[string]interface{}
+
+// ContextRequest describes which records around an anchor document should be returned.
+type ContextRequest struct {
+	Before int
+	After  int
+	// By lists fields whose values must be equal to the anchor ones.
+	By []string
+	// Span limits how far from the anchor records are looked up.
+	Span time.Duration
+}
+
+// ContextResponse contains the anchor document with the records around it
+// ordered by @timestamp and @seq.
+type ContextResponse struct {
+	Anchor Document   `json:"anchor"`
+	Before []Document `json:"before"`
+	After  []Document `json:"after"`
+}
+
+// Id returns the @id of the document.
+func (d Document) Id() string {
+	id, _ := d[IdKey].(string)
+	return id
+}
+
+// Timestamp returns the @timestamp of the document.
+func (d Document) Timestamp() int64 {
+	return toInt64(d[TimestampKey])
+}
+
+// Nanos returns the @nanos of the document, the nanoseconds of its @timestamp.
+func (d Document) Nanos() int64 {
+	return toInt64(d[NanosKey])
+}
+
+// Seq returns the @seq of the document.
+func (d Document) Seq() int64 {
+	return toInt64(d[SeqKey])
+}
+
+// Less reports whether the document was saved before the other one.
+func (d Document) Less(o Document) bool {
+	if d.Timestamp() != o.Timestamp() {
+		return d.Timestamp() < o.Timestamp()
+	}
+	if d.Nanos() != o.Nanos() {
+		return d.Nanos() < o.Nanos()
+	}
+	if d.Seq() != o.Seq() {
+		return d.Seq() < o.Seq()
+	}
+	return d.Id() < o.Id()
+}
+
+func (a *SimpleAdapter) LogContext(indexUid, id string, req *ContextRequest) (*ContextResponse, error) {
+	anchor := Document{}
+	err := a.cb.Do(func() error {
+		return notFoundOr(a.c.Documents(indexUid).Get(id, &anchor))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := anchor[TimestampKey]; !ok {
+		return nil, ErrNotFound
+	}
+	filter, err := fieldsFilter(anchor, req.By)
+	if err != nil {
+		return nil, err
+	}
+	span := int64(req.Span / time.Second)
+
+	before, err := a.collectContext(indexUid, anchor, filter, req.Before, span, false)
+	if err != nil {
+		return nil, err
+	}
+	after, err := a.collectContext(indexUid, anchor, filter, req.After, span, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ContextResponse{Anchor: anchor, Before: before, After: after}, nil
+}
+
+// collectContext walks time windows away from the anchor, doubling their width
+// while they are sparse and halving it when a window holds more hits than a
+// single query can return, until n records are found or span is exhausted.
+func (a *SimpleAdapter) collectContext(indexUid string, anchor Document, filter string, n int, span int64, forward bool) ([]Document, error) {
+	res := make([]Document, 0, n)
+	if n <= 0 {
+		return res, nil
+	}
+	search := a.c.Search(indexUid)
+	ts := anchor.Timestamp()
+	edge, width := ts, int64(contextWindow)
+	for len(res) < n {
+		if forward && edge-ts > span || !forward && ts-edge > span {
+			break
+		}
+		var bounds string
+		if forward {
+			bounds = fmt.Sprintf("%s >= %d AND %s < %d", TimestampKey, edge, TimestampKey, edge+width)
+		} else {
+			bounds = fmt.Sprintf("%s > %d AND %s <= %d", TimestampKey, edge-width, TimestampKey, edge)
+		}
+		if filter != "" {
+			bounds += " AND " + filter
+		}
+		var resp *ml.SearchResponse
+		err := a.cb.Do(func() (err error) {
+			resp, err = search.Search(ml.SearchRequest{
+				Filters:           bounds,
+				Limit:             contextPageLimit,
+				PlaceholderSearch: true,
+			})
+			return dbError(err)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.NbHits > int64(len(resp.Hits)) {
+			if width > 1 {
+				width /= 2
+				continue
+			}
+			log.Warn().Str("filters", bounds).Int64("hits", resp.NbHits).Msg("context window is truncated")
+		}
+		for _, h := range resp.Hits {
+			m, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			doc := Document(m)
+			if forward && anchor.Less(doc) || !forward && doc.Less(anchor) {
+				res = append(res, doc)
+			}
+		}
+		if forward {
+			edge += width
+		} else {
+			edge -= width
+		}
+		width *= 2
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Less(res[j])
+	})
+	if len(res) > n {
+		if forward {
+			res = res[:n]
+		} else {
+			res = res[len(res)-n:]
+		}
+	}
+	return res, nil
+}
+
+// ValidateField checks that a field name can be used in a context filter.
+func ValidateField(name string) error {
+	if !fieldPattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidField, name)
+	}
+	return nil
+}
+
+// fieldsFilter builds a Meilisearch filter matching the anchor values of the given fields.
+func fieldsFilter(anchor Document, fields []string) (string, error) {
+	conds := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if err := ValidateField(f); err != nil {
+			return "", err
+		}
+		v, ok := anchor[f]
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrMissingField, f)
+		}
+		switch val := v.(type) {
+		case string:
+			conds = append(conds, fmt.Sprintf("%s = %q", f, val))
+		case float64:
+			conds = append(conds, fmt.Sprintf("%s = %s", f, strconv.FormatFloat(val, 'f', -1, 64)))
+		case bool:
+			conds = append(conds, fmt.Sprintf("%s = %t", f, val))
+		default:
+			return "", fmt.Errorf("%w: %s is not a scalar", ErrMissingField, f)
+		}
+	}
+	return strings.Join(conds, " AND "), nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
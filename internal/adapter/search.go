@@ -0,0 +1,108 @@
+package adapter
+
+import (
+	"github.com/polyse/logdb/internal/metrics"
+	ml "github.com/senyast4745/meilisearch-go"
+	"net/http"
+	"sort"
+)
+
+// SearchRequest is a query against a single index.
+type SearchRequest struct {
+	Query   string
+	Filters string
+	Offset  int64
+	Limit   int64
+}
+
+// SearchResponse contains the matched records without the key registry document.
+type SearchResponse struct {
+	Hits   []Document `json:"hits"`
+	Total  int64      `json:"total"`
+	Offset int64      `json:"offset"`
+	Limit  int64      `json:"limit"`
+}
+
+func (a *SimpleAdapter) Search(indexUid string, req *SearchRequest) (*SearchResponse, error) {
+	var resp *ml.SearchResponse
+	err := a.cb.Do(func() (err error) {
+		resp, err = a.c.Search(indexUid).Search(ml.SearchRequest{
+			Query:             req.Query,
+			Filters:           req.Filters,
+			Offset:            req.Offset,
+			Limit:             req.Limit,
+			PlaceholderSearch: req.Query == "",
+		})
+		return notFoundOr(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &SearchResponse{
+		Hits:   make([]Document, 0, len(resp.Hits)),
+		Total:  resp.NbHits,
+		Offset: resp.Offset,
+		Limit:  resp.Limit,
+	}
+	for _, h := range resp.Hits {
+		m, ok := h.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if doc := Document(m); doc.Id() != KeyId {
+			res.Hits = append(res.Hits, doc)
+		}
+	}
+	return res, nil
+}
+
+// Fields returns the sorted field names stored in the key registry of the index.
+func (a *SimpleAdapter) Fields(indexUid string) ([]string, error) {
+	kData := &KeyData{}
+	err := a.cb.Do(func() error {
+		return notFoundOr(a.c.Documents(indexUid).Get(KeyId, kData))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(kData.Keys)
+	return kData.Keys, nil
+}
+
+func (a *SimpleAdapter) Indexes() ([]ml.Index, error) {
+	var indexes []ml.Index
+	err := a.cb.Do(func() (err error) {
+		indexes, err = a.c.Indexes().List()
+		return dbError(err)
+	})
+	return indexes, err
+}
+
+// DeleteIndex deletes the index with all its records and evicts it and its
+// schema registry from the local cache.
+func (a *SimpleAdapter) DeleteIndex(indexUid string) error {
+	err := a.cb.Do(func() error {
+		_, err := a.c.Indexes().Delete(indexUid)
+		return notFoundOr(err)
+	})
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	delete(a.ind, indexUid)
+	metrics.IndexCacheSize.Set(float64(len(a.ind)))
+	a.lock.Unlock()
+	a.kLock.Lock()
+	delete(a.keys, indexUid)
+	a.kLock.Unlock()
+	return nil
+}
+
+// notFoundOr replaces Meilisearch not found errors with ErrNotFound
+// and counts the other ones.
+func notFoundOr(err error) error {
+	if cliErr, ok := err.(*ml.Error); ok && cliErr.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return dbError(err)
+}
@@ -0,0 +1,55 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/valyala/fastjson"
+)
+
+// ErrInvalidRecord is returned for records which can not be stored.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Reasons of a ValidationError.
+const (
+	ReasonEmpty     = "empty"
+	ReasonTooLarge  = "too_large"
+	ReasonMalformed = "malformed"
+	ReasonNotObject = "not_object"
+)
+
+// ValidationError describes why a record is rejected.
+type ValidationError struct {
+	Reason string
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", ErrInvalidRecord, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidRecord, e.Reason, e.Detail)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidRecord
+}
+
+// Validate checks that data is a single JSON object of at most maxSize
+// bytes, so SaveData can store it. A zero maxSize does not limit the size.
+func Validate(data []byte, maxSize int) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return &ValidationError{Reason: ReasonEmpty}
+	case maxSize > 0 && len(data) > maxSize:
+		return &ValidationError{Reason: ReasonTooLarge, Detail: fmt.Sprintf("%d bytes exceed the limit of %d", len(data), maxSize)}
+	}
+	if err := fastjson.ValidateBytes(trimmed); err != nil {
+		return &ValidationError{Reason: ReasonMalformed, Detail: err.Error()}
+	}
+	if trimmed[0] != '{' {
+		return &ValidationError{Reason: ReasonNotObject, Detail: "a record must be a JSON object"}
+	}
+	return nil
+}
@@ -0,0 +1,458 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+
+	// bucketsPerWindow is the resolution of the sliding window counters.
+	bucketsPerWindow = 60
+
+	// DefaultTimeout is the time a webhook has to accept a notification.
+	DefaultTimeout = 5 * time.Second
+	// DefaultWorkers is the number of notifications sent at once.
+	DefaultWorkers = 4
+	// DefaultQueueSize is the number of notifications waiting for a worker,
+	// the ones beyond it are dropped.
+	DefaultQueueSize = 100
+	// DefaultMaxGroups is the number of groups a rule counts at once.
+	DefaultMaxGroups = 1000
+)
+
+type Config struct {
+	RulesFile    string
+	EvalInterval time.Duration
+	Timeout      time.Duration
+	Workers      int
+	QueueSize    int
+	// MaxGroups limits the groups of every rule, records of new groups
+	// are not counted while a rule has that many.
+	MaxGroups int
+}
+
+// Alert is the state of a single group of a rule sent to webhooks.
+type Alert struct {
+	Status   string            `json:"status"`
+	Labels   map[string]string `json:"labels"`
+	Count    int               `json:"count"`
+	StartsAt time.Time         `json:"startsAt"`
+	EndsAt   *time.Time        `json:"endsAt,omitempty"`
+}
+
+// Notification is the webhook payload, it contains every alert of a rule
+// which changed its state during one evaluation.
+type Notification struct {
+	Rule   string  `json:"rule"`
+	Status string  `json:"status"`
+	Alerts []Alert `json:"alerts"`
+}
+
+type Alerter struct {
+	rules     []*ruleState
+	rLock     sync.RWMutex
+	cli       *fasthttp.Client
+	timeout   time.Duration
+	queue     chan delivery
+	maxGroups int
+	now       func() time.Time
+}
+
+// delivery is a rendered notification waiting to be sent to a webhook.
+type delivery struct {
+	rule string
+	url  string
+	body []byte
+}
+
+type ruleState struct {
+	*Rule
+	width     time.Duration
+	lock      sync.Mutex
+	groups    map[string]*group
+	maxGroups int
+	// pruned is the slot stale groups were last dropped at to make room.
+	pruned int64
+	// dropped counts the records not counted since the last evaluation
+	// because the rule had too many groups.
+	dropped int
+}
+
+type group struct {
+	labels   map[string]string
+	buckets  []bucket
+	firing   bool
+	startsAt time.Time
+	lastSent time.Time
+}
+
+type bucket struct {
+	slot  int64
+	count int
+}
+
+// NewAlerter loads alert rules and starts their evaluation and the webhook
+// workers, which stop with the context. Without a rules file the alerter
+// ignores every record until rules are reloaded.
+func NewAlerter(ctx context.Context, conf *Config) (*Alerter, error) {
+	timeout, workers, queueSize, maxGroups := conf.Timeout, conf.Workers, conf.QueueSize, conf.MaxGroups
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	if maxGroups <= 0 {
+		maxGroups = DefaultMaxGroups
+	}
+	a := &Alerter{
+		cli: &fasthttp.Client{
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		},
+		timeout:   timeout,
+		queue:     make(chan delivery, queueSize),
+		maxGroups: maxGroups,
+		now:       time.Now,
+	}
+	if err := a.Reload(conf.RulesFile); err != nil {
+		return nil, err
+	}
+	for i := 0; i < workers; i++ {
+		go a.deliver(ctx)
+	}
+	go a.run(ctx, conf.EvalInterval)
+	return a, nil
+}
+
+// Reload replaces the rules with the ones of the file, an empty file name
+// removes every rule. The rules are left unchanged if the file is invalid.
+func (a *Alerter) Reload(file string) error {
+	var rules []*Rule
+	if file != "" {
+		var err error
+		if rules, err = LoadRules(file); err != nil {
+			return err
+		}
+	}
+	a.SetRules(rules)
+	return nil
+}
+
+// SetRules swaps the rule set. Rules keeping their name and window keep
+// their counters and firing alerts, so a reload does not resend or lose them.
+// The firing alerts of rules dropped by the reload are resolved.
+func (a *Alerter) SetRules(rules []*Rule) {
+	a.rLock.Lock()
+	old := make(map[string]*ruleState, len(a.rules))
+	for _, r := range a.rules {
+		old[r.Name] = r
+	}
+	kept := make(map[*ruleState]bool, len(rules))
+	states := make([]*ruleState, 0, len(rules))
+	for _, r := range rules {
+		st := &ruleState{
+			Rule:      r,
+			width:     time.Duration(r.Window) / bucketsPerWindow,
+			groups:    make(map[string]*group),
+			maxGroups: a.maxGroups,
+		}
+		if o, ok := old[r.Name]; ok && o.Window == r.Window {
+			st.groups = o.copyGroups()
+			kept[o] = true
+		}
+		states = append(states, st)
+	}
+	dropped := make([]*ruleState, 0, len(a.rules))
+	for _, r := range a.rules {
+		if !kept[r] {
+			dropped = append(dropped, r)
+		}
+	}
+	a.rules = states
+	a.rLock.Unlock()
+	log.Debug().Int("rules", len(rules)).Msg("alert rules loaded")
+
+	now := a.now()
+	for _, r := range dropped {
+		if n := r.resolve(now); n != nil {
+			a.notify(r.Rule, n)
+		}
+	}
+}
+
+func (a *Alerter) ruleStates() []*ruleState {
+	a.rLock.RLock()
+	defer a.rLock.RUnlock()
+	return a.rules
+}
+
+// Observe counts the record of the given tag in every rule it matches.
+func (a *Alerter) Observe(tag string, val *fastjson.Value) {
+	rules := a.ruleStates()
+	if len(rules) == 0 {
+		return
+	}
+	now := a.now()
+	for _, r := range rules {
+		if !r.matches(tag, val) {
+			continue
+		}
+		key, values := r.groupKey(tag, val)
+		slot := r.slot(now)
+		r.lock.Lock()
+		g, ok := r.groups[key]
+		if !ok {
+			if !r.admit(slot) {
+				r.lock.Unlock()
+				continue
+			}
+			g = &group{labels: r.labels(tag, values)}
+			r.groups[key] = g
+		}
+		g.add(slot)
+		r.lock.Unlock()
+	}
+}
+
+func (a *Alerter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.evaluate(now)
+		}
+	}
+}
+
+func (a *Alerter) evaluate(now time.Time) {
+	for _, r := range a.ruleStates() {
+		if n := r.evaluate(now); n != nil {
+			a.notify(r.Rule, n)
+		}
+	}
+}
+
+// notify queues the notification for every webhook of the rule, so slow
+// webhooks do not hold up the evaluation. It is dropped for the webhooks
+// the queue has no room for.
+func (a *Alerter) notify(r *Rule, n *Notification) {
+	body, err := render(r, n)
+	if err != nil {
+		log.Err(err).Str("rule", r.Name).Msg("can not render alert")
+		return
+	}
+	for _, url := range r.Webhooks {
+		select {
+		case a.queue <- delivery{rule: r.Name, url: url, body: body}:
+		default:
+			log.Warn().Str("rule", r.Name).Str("webhook", url).Msg("alert queue is full, alert dropped")
+		}
+	}
+}
+
+// deliver sends the queued notifications until the context is done.
+func (a *Alerter) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-a.queue:
+			if err := a.send(d.url, d.body); err != nil {
+				log.Err(err).Str("rule", d.rule).Str("webhook", d.url).Msg("can not send alert")
+			}
+		}
+	}
+}
+
+func (a *Alerter) send(url string, body []byte) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	if err := a.cli.DoTimeout(req, resp, a.timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+func render(r *Rule, n *Notification) ([]byte, error) {
+	if r.tmpl == nil {
+		return json.Marshal(n)
+	}
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, n); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template of rule %s produced invalid json", r.Name)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *ruleState) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(r.width)
+}
+
+// evaluate updates the state of every group and returns the alerts which have to be sent.
+func (r *ruleState) evaluate(now time.Time) *Notification {
+	slot := r.slot(now)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	alerts := make(map[string]Alert)
+	for key, g := range r.groups {
+		c := g.count(slot)
+		switch {
+		case c >= r.Threshold && !g.firing:
+			g.firing, g.startsAt, g.lastSent = true, now, now
+			alerts[key] = g.alert(StatusFiring, c, nil)
+		case c >= r.Threshold && r.RepeatInterval > 0 && now.Sub(g.lastSent) >= time.Duration(r.RepeatInterval):
+			g.lastSent = now
+			alerts[key] = g.alert(StatusFiring, c, nil)
+		case c < r.Threshold && g.firing:
+			g.firing = false
+			end := now
+			alerts[key] = g.alert(StatusResolved, c, &end)
+		}
+		if !g.firing && c == 0 {
+			delete(r.groups, key)
+		}
+	}
+	if r.dropped > 0 {
+		log.Warn().Str("rule", r.Name).Int("groups", len(r.groups)).Int("dropped", r.dropped).
+			Msg("alert rule has too many groups, records dropped")
+		r.dropped = 0
+	}
+	return r.notification(alerts)
+}
+
+// admit reports whether a new group can be added. A rule with too many
+// groups first drops the stale ones, which are not firing and have no
+// records in the window, at most once per slot.
+func (r *ruleState) admit(slot int64) bool {
+	if len(r.groups) < r.maxGroups {
+		return true
+	}
+	if r.pruned != slot {
+		r.pruned = slot
+		for key, g := range r.groups {
+			if !g.firing && g.count(slot) == 0 {
+				delete(r.groups, key)
+			}
+		}
+		if len(r.groups) < r.maxGroups {
+			return true
+		}
+	}
+	r.dropped++
+	return false
+}
+
+// resolve resolves the firing alerts of a rule which was dropped.
+func (r *ruleState) resolve(now time.Time) *Notification {
+	slot := r.slot(now)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	alerts := make(map[string]Alert)
+	for key, g := range r.groups {
+		if g.firing {
+			g.firing = false
+			end := now
+			alerts[key] = g.alert(StatusResolved, g.count(slot), &end)
+		}
+	}
+	return r.notification(alerts)
+}
+
+// notification returns the notification of the alerts ordered by group, nil if there are none.
+func (r *ruleState) notification(alerts map[string]Alert) *Notification {
+	if len(alerts) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(alerts))
+	for k := range alerts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := &Notification{Rule: r.Name, Status: StatusResolved, Alerts: make([]Alert, 0, len(keys))}
+	for _, k := range keys {
+		if alerts[k].Status == StatusFiring {
+			n.Status = StatusFiring
+		}
+		n.Alerts = append(n.Alerts, alerts[k])
+	}
+	return n
+}
+
+// copyGroups returns a copy of the groups, the rule keeps using its own.
+func (r *ruleState) copyGroups() map[string]*group {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	groups := make(map[string]*group, len(r.groups))
+	for key, g := range r.groups {
+		c := *g
+		c.buckets = append([]bucket(nil), g.buckets...)
+		groups[key] = &c
+	}
+	return groups
+}
+
+func (g *group) add(slot int64) {
+	if n := len(g.buckets); n > 0 && g.buckets[n-1].slot >= slot {
+		g.buckets[n-1].count++
+		return
+	}
+	g.buckets = append(g.buckets, bucket{slot: slot, count: 1})
+}
+
+// count drops buckets which left the window ending at slot and sums the rest.
+func (g *group) count(slot int64) int {
+	i := 0
+	for i < len(g.buckets) && g.buckets[i].slot <= slot-bucketsPerWindow {
+		i++
+	}
+	g.buckets = g.buckets[i:]
+	c := 0
+	for _, b := range g.buckets {
+		c += b.count
+	}
+	return c
+}
+
+func (g *group) alert(status string, count int, end *time.Time) Alert {
+	return Alert{
+		Status:   status,
+		Labels:   g.labels,
+		Count:    count,
+		StartsAt: g.startsAt,
+		EndsAt:   end,
+	}
+}
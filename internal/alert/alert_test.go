@@ -0,0 +1,317 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type AlertUnitTestSuite struct {
+	suite.Suite
+	srv      *httptest.Server
+	received chan []byte
+	now      time.Time
+}
+
+func (s *AlertUnitTestSuite) SetupTest() {
+	s.received = make(chan []byte, 10)
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		s.NoError(err)
+		s.received <- body
+	}))
+	s.now = time.Unix(1000, 0)
+}
+
+func (s *AlertUnitTestSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func TestRunAlertUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(AlertUnitTestSuite))
+}
+
+func (s *AlertUnitTestSuite) newAlerter(rules ...*Rule) *Alerter {
+	for _, r := range rules {
+		r.Webhooks = []string{s.srv.URL}
+		s.NoError(r.init())
+	}
+	a := &Alerter{
+		cli:       &fasthttp.Client{},
+		timeout:   time.Second,
+		queue:     make(chan delivery, DefaultQueueSize),
+		maxGroups: DefaultMaxGroups,
+		now: func() time.Time {
+			return s.now
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+	go a.deliver(ctx)
+	a.SetRules(rules)
+	return a
+}
+
+func (s *AlertUnitTestSuite) observe(a *Alerter, tag, data string) {
+	a.Observe(tag, fastjson.MustParse(data))
+}
+
+func (s *AlertUnitTestSuite) notification() *Notification {
+	select {
+	case body := <-s.received:
+		n := &Notification{}
+		s.NoError(json.Unmarshal(body, n))
+		return n
+	case <-time.After(time.Second):
+		s.Fail("no notification received")
+		return nil
+	}
+}
+
+func (s *AlertUnitTestSuite) Test_Threshold_Fires_And_Resolves() {
+	a := s.newAlerter(&Rule{
+		Name:      "errors",
+		Tag:       "app-*",
+		Match:     map[string]string{"level": "error"},
+		Threshold: 2,
+		Window:    Duration(time.Minute),
+		GroupBy:   []string{"host"},
+	})
+
+	s.observe(a, "app-1", `{"level":"error","host":"a"}`)
+	s.observe(a, "app-1", `{"level":"info","host":"a"}`)
+	s.observe(a, "other", `{"level":"error","host":"a"}`)
+	a.evaluate(s.now)
+	s.Empty(s.received)
+
+	s.observe(a, "app-1", `{"level":"error","host":"a"}`)
+	s.observe(a, "app-1", `{"level":"error","host":"b"}`)
+	a.evaluate(s.now)
+	n := s.notification()
+	s.Equal("errors", n.Rule)
+	s.Equal(StatusFiring, n.Status)
+	s.Len(n.Alerts, 1)
+	s.Equal(map[string]string{"tag": "app-1", "host": "a"}, n.Alerts[0].Labels)
+	s.Equal(2, n.Alerts[0].Count)
+
+	s.observe(a, "app-1", `{"level":"error","host":"a"}`)
+	a.evaluate(s.now)
+	s.Empty(s.received, "firing alert is deduplicated")
+
+	s.now = s.now.Add(2 * time.Minute)
+	a.evaluate(s.now)
+	n = s.notification()
+	s.Equal(StatusResolved, n.Status)
+	s.Len(n.Alerts, 1)
+	s.Equal(StatusResolved, n.Alerts[0].Status)
+	s.NotNil(n.Alerts[0].EndsAt)
+	s.Empty(a.rules[0].groups)
+}
+
+func (s *AlertUnitTestSuite) Test_Pattern_With_Repeat() {
+	a := s.newAlerter(&Rule{
+		Name:           "oom",
+		Pattern:        "OutOfMemory",
+		Window:         Duration(time.Minute),
+		RepeatInterval: Duration(10 * time.Second),
+	})
+
+	s.observe(a, "app", `{"message":"java.lang.OutOfMemoryError"}`)
+	s.observe(a, "app", `{"msg":"java.lang.OutOfMemoryError"}`)
+	a.evaluate(s.now)
+	n := s.notification()
+	s.Equal(StatusFiring, n.Status)
+	s.Equal(1, n.Alerts[0].Count)
+
+	s.now = s.now.Add(5 * time.Second)
+	a.evaluate(s.now)
+	s.Empty(s.received)
+
+	s.now = s.now.Add(5 * time.Second)
+	a.evaluate(s.now)
+	n = s.notification()
+	s.Equal(StatusFiring, n.Status)
+}
+
+func (s *AlertUnitTestSuite) Test_Template() {
+	a := s.newAlerter(&Rule{
+		Name:     "templated",
+		Window:   Duration(time.Minute),
+		Template: `{"text":{{ json (printf "%s is %s" .Rule .Status) }},"count":{{ (index .Alerts 0).Count }}}`,
+	})
+
+	s.observe(a, "app", `{"message":"hello"}`)
+	a.evaluate(s.now)
+
+	select {
+	case body := <-s.received:
+		s.JSONEq(`{"text":"templated is firing","count":1}`, string(body))
+	case <-time.After(time.Second):
+		s.Fail("no notification received")
+	}
+}
+
+func (s *AlertUnitTestSuite) Test_LoadRules() {
+	f, err := ioutil.TempFile("", "rules*.json")
+	s.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"name":"errors","match":{"level":"error"},"window":"5m","webhooks":["http://localhost"]}]`)
+	s.NoError(err)
+	s.NoError(f.Close())
+
+	rules, err := LoadRules(f.Name())
+	s.NoError(err)
+	s.Len(rules, 1)
+	s.Equal(Duration(5*time.Minute), rules[0].Window)
+	s.Equal(1, rules[0].Threshold)
+
+	_, err = LoadRules(os.DevNull)
+	s.Error(err)
+}
+
+func (s *AlertUnitTestSuite) Test_Invalid_Rule() {
+	r := &Rule{Name: "bad", Window: Duration(time.Minute), Webhooks: []string{"http://localhost"}, Pattern: "("}
+	s.Error(r.init())
+
+	r = &Rule{Name: "no window", Webhooks: []string{"http://localhost"}}
+	s.Error(r.init())
+}
+
+func (s *AlertUnitTestSuite) Test_Reload_Keeps_State() {
+	a := s.newAlerter(&Rule{
+		Name:   "errors",
+		Match:  map[string]string{"level": "error"},
+		Window: Duration(time.Minute),
+	})
+	s.observe(a, "app", `{"level":"error"}`)
+
+	f, err := ioutil.TempFile("", "rules*.json")
+	s.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"name":"errors","match":{"level":"error"},"window":"1m","threshold":2,"webhooks":["` + s.srv.URL + `"]}]`)
+	s.NoError(err)
+	s.NoError(f.Close())
+
+	s.NoError(a.Reload(f.Name()))
+	s.Equal(2, a.ruleStates()[0].Threshold)
+	a.evaluate(s.now)
+	s.Empty(s.received)
+
+	s.observe(a, "app", `{"level":"error"}`)
+	a.evaluate(s.now)
+	n := s.notification()
+	s.Equal(StatusFiring, n.Status)
+	s.Equal(2, n.Alerts[0].Count)
+
+	s.Error(a.Reload(os.DevNull))
+	s.Len(a.ruleStates(), 1, "invalid rules are not applied")
+
+	s.NoError(a.Reload(""))
+	s.Empty(a.ruleStates())
+	n = s.notification()
+	s.Equal("errors", n.Rule)
+	s.Equal(StatusResolved, n.Status)
+	s.Equal(StatusResolved, n.Alerts[0].Status)
+	s.NotNil(n.Alerts[0].EndsAt)
+}
+
+func (s *AlertUnitTestSuite) Test_Reload_Copies_State() {
+	a := s.newAlerter(&Rule{Name: "errors", Match: map[string]string{"level": "error"}, Window: Duration(time.Minute)})
+	old := a.ruleStates()[0]
+	s.observe(a, "app", `{"level":"error"}`)
+
+	r := &Rule{Name: "errors", Match: map[string]string{"level": "error"}, Window: Duration(time.Minute), Threshold: 2,
+		Webhooks: []string{s.srv.URL}}
+	s.NoError(r.init())
+	a.SetRules([]*Rule{r})
+	// an Observe still holding the old rules does not count in the new ones
+	s.observe(a, "app", `{"level":"error"}`)
+	old.lock.Lock()
+	old.groups["app"].add(old.slot(s.now))
+	old.lock.Unlock()
+	st := a.ruleStates()[0]
+	s.Equal(2, st.groups["app"].count(st.slot(s.now)))
+	s.Equal(2, old.groups["app"].count(old.slot(s.now)))
+	s.Empty(s.received, "rules which are kept are not resolved")
+}
+
+func (s *AlertUnitTestSuite) Test_Slow_Webhook_Does_Not_Block_Evaluation() {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	r := &Rule{Name: "errors", Window: Duration(time.Minute), Webhooks: []string{slow.URL}}
+	s.NoError(r.init())
+	a := &Alerter{
+		cli:       &fasthttp.Client{},
+		timeout:   time.Hour,
+		queue:     make(chan delivery, 1),
+		maxGroups: DefaultMaxGroups,
+		now: func() time.Time {
+			return s.now
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.deliver(ctx)
+	a.SetRules([]*Rule{r})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.observe(a, fmt.Sprintf("app-%d", i), `{}`)
+		a.evaluate(s.now)
+	}
+	s.Less(int64(time.Since(start)), int64(time.Second), "evaluation does not wait for webhooks")
+	s.Len(a.queue, 1, "notifications beyond the queue are dropped")
+}
+
+func (s *AlertUnitTestSuite) Test_Webhook_Timeout() {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	a := &Alerter{cli: &fasthttp.Client{}, timeout: 50 * time.Millisecond}
+	start := time.Now()
+	s.Error(a.send(slow.URL, []byte(`{}`)))
+	s.Less(int64(time.Since(start)), int64(time.Second))
+}
+
+func (s *AlertUnitTestSuite) Test_Max_Groups() {
+	a := s.newAlerter(&Rule{
+		Name:      "errors",
+		Threshold: 2,
+		Window:    Duration(time.Minute),
+		GroupBy:   []string{"host"},
+	})
+	a.rules[0].maxGroups = 2
+
+	s.observe(a, "app", `{"host":"a"}`)
+	s.observe(a, "app", `{"host":"b"}`)
+	s.observe(a, "app", `{"host":"c"}`)
+	s.observe(a, "app", `{"host":"a"}`)
+	s.Len(a.rules[0].groups, 2)
+	s.Equal(1, a.rules[0].dropped, "records of new groups are dropped while the rule is full")
+
+	s.now = s.now.Add(2 * time.Minute)
+	s.observe(a, "app", `{"host":"a"}`)
+	s.observe(a, "app", `{"host":"c"}`)
+	s.Len(a.rules[0].groups, 2, "stale groups make room for new ones")
+	s.Contains(a.rules[0].groups, "app\x00a")
+	s.Contains(a.rules[0].groups, "app\x00c")
+	s.Equal(1, a.rules[0].dropped)
+}
@@ -0,0 +1,181 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/valyala/fastjson"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultPatternField = "message"
+
+// Duration is a time.Duration which is decoded from strings like "5m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule describes when an alert fires and where it is sent.
+type Rule struct {
+	Name string `json:"name"`
+	// Tag is a glob matched against the index uid, empty matches every tag.
+	Tag string `json:"tag"`
+	// Match lists fields which must be equal to the given values.
+	Match map[string]string `json:"match"`
+	// Pattern is a regular expression matched against Field.
+	Pattern string `json:"pattern"`
+	Field   string `json:"field"`
+	// Threshold is the number of matching records in Window needed to fire.
+	Threshold int      `json:"threshold"`
+	Window    Duration `json:"window"`
+	// GroupBy lists fields whose values split matching records into separate alerts.
+	GroupBy []string `json:"group_by"`
+	// RepeatInterval is how often a still firing alert is sent again, zero disables repeats.
+	RepeatInterval Duration `json:"repeat_interval"`
+	Webhooks       []string `json:"webhooks"`
+	// Template is a text/template rendering the webhook JSON body from a Notification.
+	Template string `json:"template"`
+
+	re   *regexp.Regexp
+	tmpl *template.Template
+}
+
+// LoadRules reads and validates rules from a JSON file.
+func LoadRules(file string) ([]*Rule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if err = r.init(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) init() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert rule without name")
+	}
+	if len(r.Webhooks) == 0 {
+		return fmt.Errorf("alert rule %s: no webhooks", r.Name)
+	}
+	if r.Window < Duration(time.Second) {
+		return fmt.Errorf("alert rule %s: window must be at least 1s", r.Name)
+	}
+	if r.Threshold <= 0 {
+		r.Threshold = 1
+	}
+	if _, err := path.Match(r.Tag, ""); err != nil {
+		return fmt.Errorf("alert rule %s: %w", r.Name, err)
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("alert rule %s: %w", r.Name, err)
+		}
+		r.re = re
+		if r.Field == "" {
+			r.Field = defaultPatternField
+		}
+	}
+	if r.Template != "" {
+		tmpl, err := template.New(r.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(r.Template)
+		if err != nil {
+			return fmt.Errorf("alert rule %s: %w", r.Name, err)
+		}
+		r.tmpl = tmpl
+	}
+	return nil
+}
+
+// matches reports whether the record of the given tag is counted by the rule.
+func (r *Rule) matches(tag string, val *fastjson.Value) bool {
+	if r.Tag != "" {
+		if ok, _ := path.Match(r.Tag, tag); !ok {
+			return false
+		}
+	}
+	for k, v := range r.Match {
+		if field, ok := fieldString(val, k); !ok || field != v {
+			return false
+		}
+	}
+	if r.re != nil {
+		field, ok := fieldString(val, r.Field)
+		if !ok || !r.re.MatchString(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupKey returns the canonical key of the alert the record belongs to
+// together with the values of the GroupBy fields.
+func (r *Rule) groupKey(tag string, val *fastjson.Value) (string, []string) {
+	values := make([]string, len(r.GroupBy))
+	var sb strings.Builder
+	sb.WriteString(tag)
+	for i, g := range r.GroupBy {
+		values[i], _ = fieldString(val, g)
+		sb.WriteByte(0)
+		sb.WriteString(values[i])
+	}
+	return sb.String(), values
+}
+
+// labels returns the labels of the alert with the given tag and GroupBy values.
+func (r *Rule) labels(tag string, values []string) map[string]string {
+	labels := make(map[string]string, len(r.GroupBy)+1)
+	labels["tag"] = tag
+	for i, g := range r.GroupBy {
+		labels[g] = values[i]
+	}
+	return labels
+}
+
+func fieldString(val *fastjson.Value, key string) (string, bool) {
+	v := val.Get(key)
+	if v == nil {
+		return "", false
+	}
+	switch v.Type() {
+	case fastjson.TypeString:
+		return string(v.GetStringBytes()), true
+	case fastjson.TypeNumber:
+		return strconv.FormatFloat(v.GetFloat64(), 'f', -1, 64), true
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return strconv.FormatBool(v.GetBool()), true
+	}
+	return v.String(), true
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
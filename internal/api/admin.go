@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+// errAdminDisabled denies the admin API while authentication is disabled,
+// anyone could delete indexes otherwise.
+var errAdminDisabled = errors.New("admin API requires authentication")
+
+// admin returns the middlewares of an admin endpoint. Operators manage the
+// indexes of every tenant, so credentials bound to a tenant are denied.
+func (a *API) admin() []atr.Middleware {
+	return []atr.Middleware{a.limitIP, a.authorize(auth.Admin), requireOperator, a.limit}
+}
+
+// requireOperator denies requests without credentials and credentials bound to a tenant.
+func requireOperator(ctx *atr.RequestCtx) error {
+	id := identity(ctx)
+	if id == nil {
+		log.Debug().Str("remote", ctx.RemoteAddr().String()).Msg("admin access denied without authentication")
+		return ctx.ErrorResponse(errAdminDisabled, http.StatusForbidden)
+	}
+	if id.Tenant != "" {
+		metrics.AuthFailures.WithLabelValues(authFailureReason(auth.ErrForbidden)).Inc()
+		log.Debug().Str("identity", id.Name).Msg("admin access denied to tenant credential")
+		return ctx.ErrorResponse(auth.ErrForbidden, http.StatusForbidden)
+	}
+	return ctx.Next()
+}
+
+// adminIndex is an index of the admin API with the tenant and tag it belongs to.
+type adminIndex struct {
+	adapter.IndexStats
+	Tenant string `json:"tenant"`
+	Tag    string `json:"tag"`
+}
+
+func newAdminIndex(st adapter.IndexStats) *adminIndex {
+	t, tag := tenant.Split(st.UID)
+	return &adminIndex{IndexStats: st, Tenant: t, Tag: tag}
+}
+
+// HandleAdminIndexes lists the indexes of every tenant with their document counts.
+func (a *API) HandleAdminIndexes(ctx *atr.RequestCtx) error {
+	stats, err := a.ad.AllIndexStats()
+	if err != nil {
+		return readError(ctx, err)
+	}
+	res := make([]*adminIndex, 0, len(stats))
+	for _, st := range stats {
+		res = append(res, newAdminIndex(st))
+	}
+	return ctx.JSONResponse(res, http.StatusOK)
+}
+
+// HandleAdminIndex describes the index with the frequency of its fields.
+func (a *API) HandleAdminIndex(ctx *atr.RequestCtx) error {
+	st, err := a.ad.IndexStats(adminUid(ctx))
+	if err != nil {
+		return readError(ctx, err)
+	}
+	return ctx.JSONResponse(newAdminIndex(*st), http.StatusOK)
+}
+
+// HandleAdminDeleteIndex deletes the index with its records.
+func (a *API) HandleAdminDeleteIndex(ctx *atr.RequestCtx) error {
+	uid := adminUid(ctx)
+	if err := a.ad.DeleteIndex(uid); err != nil {
+		return readError(ctx, err)
+	}
+	log.Info().Str("index", uid).Str("identity", identityName(ctx)).Msg("index deleted")
+	ctx.Response.SetStatusCode(http.StatusNoContent)
+	return nil
+}
+
+// settingsResponse is the body of a response to a settings update.
+type settingsResponse struct {
+	UpdateId int64 `json:"updateId"`
+}
+
+// HandleAdminApplySettings applies the index settings of the adapter again.
+func (a *API) HandleAdminApplySettings(ctx *atr.RequestCtx) error {
+	uid := adminUid(ctx)
+	id, err := a.ad.ApplySettings(uid)
+	if err != nil {
+		return readError(ctx, err)
+	}
+	log.Info().Str("index", uid).Int64("update", id).Str("identity", identityName(ctx)).Msg("index settings applied")
+	return ctx.JSONResponse(&settingsResponse{UpdateId: id}, http.StatusAccepted)
+}
+
+// HandleAdminClearFields deletes the schema registry of the index.
+func (a *API) HandleAdminClearFields(ctx *atr.RequestCtx) error {
+	uid := adminUid(ctx)
+	if err := a.ad.ClearFields(uid); err != nil {
+		return readError(ctx, err)
+	}
+	log.Info().Str("index", uid).Str("identity", identityName(ctx)).Msg("index fields cleared")
+	ctx.Response.SetStatusCode(http.StatusNoContent)
+	return nil
+}
+
+func adminUid(ctx *atr.RequestCtx) string {
+	uid, _ := ctx.UserValue("uid").(string)
+	return uid
+}
+
+func identityName(ctx *atr.RequestCtx) string {
+	if id := identity(ctx); id != nil {
+		return id.Name
+	}
+	return ""
+}
@@ -2,22 +2,64 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/breaker"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/multiline"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/spool"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/polyse/logdb/internal/tracing"
 	"github.com/rs/zerolog/log"
 	atr "github.com/savsgio/atreugo/v11"
+	"github.com/valyala/fasthttp"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type API struct {
-	ad    adapter.Adapter
-	srv   *atr.Atreugo
-	ln    net.Listener
-	conCh chan struct{}
-	errCh chan<- error
+	ad      adapter.Adapter
+	srv     *atr.Atreugo
+	ln      net.Listener
+	conCh   chan struct{}
+	errCh   chan<- error
+	errs    *apperrors.Handler
+	checks  []ReadinessCheck
+	auth    auth.Authenticator
+	tenants *tenant.Registry
+	parsers *parser.Registry
+	// lines joins the messages of listeners into multiline events.
+	lines   *multiline.Aggregator
+	limiter *ratelimit.Limiter
+	maxSize int
+	maxWait time.Duration
+	writes  writes
+	spool   *spool.Spool
+	// drainTimeout is the time accepted records are waited for on shutdown.
+	drainTimeout time.Duration
+	// replayInterval is the interval records spooled while running are replayed.
+	replayInterval time.Duration
+	// lokiLabel is the stream label choosing the tag of Loki entries.
+	lokiLabel string
+	// otlpAttr is the resource attribute choosing the tag of OTLP log records.
+	otlpAttr string
+	// gelfField is the field choosing the tag of GELF messages.
+	gelfField string
+	// gelfMaxSize limits the size of decompressed GELF messages.
+	gelfMaxSize int
+	// maxBodySize limits the size of decompressed request bodies.
+	maxBodySize int
 }
 
 type Config struct {
@@ -25,14 +67,53 @@ type Config struct {
 	Network   string
 	MaxDbConn uint16
 	Timeout   time.Duration
+	// TLS enables HTTPS on the listener when set.
+	TLS *tls.Config
+	// MaxRecordSize limits the size of an ingested record in bytes, 0 is unlimited.
+	MaxRecordSize int
+	// MaxBodySize limits the size of compressed request bodies once decompressed, 32 MiB by default.
+	MaxBodySize int
+	// WaitTimeout is the longest and default time a write may wait until its record is searchable.
+	WaitTimeout time.Duration
+	// DrainTimeout is the time accepted records are waited for on shutdown.
+	DrainTimeout time.Duration
+	// SpoolDir keeps the records Meilisearch could not take, they are dropped if it is empty.
+	SpoolDir string
+	// SpoolReplayInterval is the interval spooled records are saved again while Meilisearch is available.
+	SpoolReplayInterval time.Duration
+	// LokiIndexLabel is the stream label choosing the tag of Loki entries, job by default.
+	LokiIndexLabel string
+	// OtlpIndexAttribute is the resource attribute choosing the tag of OTLP
+	// log records, service.name by default.
+	OtlpIndexAttribute string
+	// GelfIndexField is the field choosing the tag of GELF messages, _container_name by default.
+	GelfIndexField string
+	// GelfMaxMessageSize limits the size of decompressed GELF messages, 1 MiB by default.
+	GelfMaxMessageSize int
 }
 
+const (
+	defaultContextSize = 50
+	maxContextSize     = 1000
+	defaultContextSpan = 24 * time.Hour
+	defaultSearchLimit = 20
+	maxSearchLimit     = 1000
+	busyRetryAfter     = time.Second
+
+	preferHeader            = "Prefer"
+	preferenceAppliedHeader = "Preference-Applied"
+)
+
+// errBusy rejects writes while every database connection is in use.
+var errBusy = errors.New("too many concurrent writes")
+
 type Context struct {
 	*atr.RequestCtx
 	Ctx context.Context
 }
 
-func NewAdapterApi(ctx context.Context, conf *Config, adapter adapter.Adapter, errCh chan<- error) (*API, func(), error) {
+func NewAdapterApi(ctx context.Context, conf *Config, adapter adapter.Adapter, errs *apperrors.Handler, checks []ReadinessCheck,
+	authn auth.Authenticator, tenants *tenant.Registry, limiter *ratelimit.Limiter, parsers *parser.Registry) (*API, func(), error) {
 	file, err := os.Open(os.DevNull)
 	if err != nil {
 		return nil, nil, err
@@ -50,20 +131,50 @@ func NewAdapterApi(ctx context.Context, conf *Config, adapter adapter.Adapter, e
 	if err != nil {
 		return nil, nil, err
 	}
+	if conf.TLS != nil {
+		l = tls.NewListener(l, conf.TLS)
+	}
+	sp, err := spool.NewSpool(conf.SpoolDir)
+	if err != nil {
+		_ = l.Close()
+		return nil, nil, err
+	}
 	api := &API{
-		ad:    adapter,
-		srv:   nil,
-		ln:    l,
-		conCh: make(chan struct{}, conf.MaxDbConn),
-		errCh: errCh,
+		ad:      adapter,
+		srv:     nil,
+		ln:      l,
+		conCh:   make(chan struct{}, conf.MaxDbConn),
+		errCh:   errs.Chan(),
+		errs:    errs,
+		checks:  checks,
+		auth:    authn,
+		tenants: tenants,
+		parsers: parsers,
+		limiter: limiter,
+		maxSize: conf.MaxRecordSize,
+		maxWait: conf.WaitTimeout,
+		spool:   sp,
+
+		drainTimeout:   conf.DrainTimeout,
+		replayInterval: conf.SpoolReplayInterval,
+		lokiLabel:      conf.LokiIndexLabel,
+		otlpAttr:       conf.OtlpIndexAttribute,
+		gelfField:      conf.GelfIndexField,
+		gelfMaxSize:    conf.GelfMaxMessageSize,
+		maxBodySize:    conf.MaxBodySize,
 	}
+	api.lines = multiline.NewAggregator(api.flushInput)
+	go api.lines.Run(ctx)
+	metrics.DbConnectionsMax.Set(float64(conf.MaxDbConn))
 
 	return api, api.initRouter(ctx, l, atrCfg), err
 
 }
 
 func (a *API) initRouter(ctx context.Context, l net.Listener, cfg atr.Config) func() {
+	cfg.ErrorView = errorView
 	srv := atr.New(cfg)
+	srv.RequestHandlerPath(http.MethodGet, "/metrics", metrics.Handler())
 
 	apiRouter := srv.NewGroupPath("/api")
 	apiRouter.UseBefore(func(rctx *atr.RequestCtx) error {
@@ -73,14 +184,33 @@ func (a *API) initRouter(ctx context.Context, l net.Listener, cfg atr.Config) fu
 		}
 		return cc.Next()
 	})
-	apiRouter.UseAfter(logRequest())
-	apiRouter.PUT("/logs/{tag:*}", a.HandleNewLog)
-	apiRouter.GET("/health", func(ctx *atr.RequestCtx) error {
-		log.Debug().Msg("health check")
-		ctx.Response.SetStatusCode(http.StatusOK)
-		ctx.Response.AppendBodyString("OK")
-		return nil
-	})
+	apiRouter.UseAfter(logRequest(), countRequest())
+	apiRouter.PUT("/logs/{tag:*}", a.HandleNewLog).UseBefore(a.ingestion()...)
+	apiRouter.GET("/logs/{tag}/search", a.HandleSearch).UseBefore(a.guard(auth.Read)...)
+	apiRouter.GET("/logs/{tag}/fields", a.HandleFields).UseBefore(a.guard(auth.Read)...)
+	apiRouter.GET("/logs/{tag}/{id}/context", a.HandleLogContext).UseBefore(a.guard(auth.Read)...)
+	apiRouter.GET("/indexes", a.HandleListIndexes).UseBefore(a.guard(auth.Read)...)
+	apiRouter.DELETE("/indexes/{tag}", a.HandleDeleteIndex).UseBefore(append(a.admin(), a.tenancy)...)
+	apiRouter.GET("/admin/indexes", a.HandleAdminIndexes).UseBefore(a.admin()...)
+	apiRouter.GET("/admin/indexes/{uid}", a.HandleAdminIndex).UseBefore(a.admin()...)
+	apiRouter.DELETE("/admin/indexes/{uid}", a.HandleAdminDeleteIndex).UseBefore(a.admin()...)
+	apiRouter.POST("/admin/indexes/{uid}/settings", a.HandleAdminApplySettings).UseBefore(a.admin()...)
+	apiRouter.DELETE("/admin/indexes/{uid}/fields", a.HandleAdminClearFields).UseBefore(a.admin()...)
+	apiRouter.GET("/health", a.HandleLiveness)
+	apiRouter.GET("/health/live", a.HandleLiveness)
+	apiRouter.GET("/health/ready", a.HandleReadiness)
+	apiRouter.GET("/health/errors", a.HandleErrorState)
+
+	// Elasticsearch compatible endpoints for shippers speaking the bulk protocol
+	srv.GET("/", a.HandleInfo)
+	srv.POST("/_bulk", a.HandleBulk).UseBefore(a.ingestion()...).UseAfter(logRequest(), countRequest())
+	srv.POST("/{tag}/_bulk", a.HandleBulk).UseBefore(a.ingestion()...).UseAfter(logRequest(), countRequest())
+	// Loki push API for Promtail and the Grafana Agent
+	srv.POST("/loki/api/v1/push", a.HandleLokiPush).UseBefore(a.ingestion()...).UseAfter(logRequest(), countRequest())
+	// OTLP/HTTP logs receiver for OpenTelemetry SDKs and collectors
+	srv.POST("/v1/logs", a.HandleOtlpLogs).UseBefore(a.ingestion()...).UseAfter(logRequest(), countRequest())
+	// GELF HTTP input for Graylog clients
+	srv.POST("/gelf", a.HandleGelf).UseBefore(a.ingestion()...).UseAfter(logRequest(), countRequest())
 
 	log.Debug().
 		Interface("paths", srv.ListPaths()).
@@ -94,37 +224,259 @@ func (a *API) initRouter(ctx context.Context, l net.Listener, cfg atr.Config) fu
 		if err := l.Close(); err != nil {
 			log.Err(err).Msg("can not stop listener")
 		}
+		a.lines.Flush()
+		a.Drain(a.drainTimeout)
 	}
 }
 
 func (a *API) HandleNewLog(ctx *atr.RequestCtx) error {
-	select {
-	case a.conCh <- struct{}{}:
-		log.Debug().Msg("try to write")
-	default:
-		var err error
-		if err = a.ad.DatabaseHealthCheck(); err != nil {
-			ctx.Response.SetStatusCode(http.StatusGatewayTimeout)
-			log.Warn().Msg("database is unavailable")
-		} else {
-			ctx.Response.SetStatusCode(http.StatusServiceUnavailable)
-			err = fmt.Errorf("too many goroutines")
+	uid, t := index(ctx), currentTenant(ctx)
+	sCtx, span := tracing.StartServer(context.Background(), "HandleNewLog",
+		string(ctx.Request.Header.Peek(tracing.TraceparentHeader)))
+	span.SetAttribute("logdb.tag", ctx.UserValue("tag").(string))
+	span.SetAttribute("logdb.tenant", t.ID)
+	defer span.End()
+
+	wait, timeout, err := a.waitTimeout(ctx)
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	if isText(ctx) {
+		if wait {
+			return ctx.ErrorResponse(errors.New("plain text records can not be waited for"), http.StatusBadRequest)
 		}
-		a.errCh <- err
-		return err
+		return a.handleText(ctx, sCtx, t, ctx.UserValue("tag").(string), uid)
 	}
 	data := ctx.Request.Body()
-	tag := ctx.UserValue("tag").(string)
-	go func(data []byte, tag string) {
-		if err := a.ad.SaveData(data, tag); err != nil {
-			a.errCh <- err
-		}
-	}(data, tag)
+	if err := a.admit(t, uid, data); err != nil {
+		span.RecordError(err)
+		return admitError(ctx, t, err)
+	}
+	if wait {
+		metrics.IngestedBytes.WithLabelValues(uid).Add(float64(len(data)))
+		err = a.saveAndWait(ctx, sCtx, t, data, uid, timeout)
+		a.release()
+		return err
+	}
+	a.saveAsync(sCtx, uid, data)
 	ctx.Response.SetStatusCode(http.StatusAccepted)
-	<-a.conCh
+	a.release()
 	return nil
 }
 
+// saveAndWait saves the record and responds with its receipt once Meilisearch
+// processed it, or with an error if it failed or took longer than the timeout.
+// Records which could not be sent are answered like rejected ones, so clients
+// retry when the breaker is open.
+func (a *API) saveAndWait(ctx *atr.RequestCtx, sCtx context.Context, t *tenant.Tenant, data []byte, uid string,
+	timeout time.Duration) error {
+	wCtx, cancel := context.WithTimeout(sCtx, timeout)
+	defer cancel()
+	receipt, err := a.ad.SaveData(wCtx, data, uid)
+	if err != nil {
+		a.errCh <- err
+		return admitError(ctx, t, err)
+	}
+	err = a.ad.WaitForUpdate(wCtx, uid, receipt.UpdateId)
+	switch {
+	case err == nil:
+		return ctx.JSONResponse(receipt, http.StatusCreated)
+	case errors.Is(err, context.DeadlineExceeded):
+		return ctx.JSONResponse(&waitResponse{Receipt: receipt,
+			Error: fmt.Sprintf("record not searchable after %s", timeout)}, http.StatusGatewayTimeout)
+	}
+	log.Err(err).Str("index", uid).Int64("update", receipt.UpdateId).Msg("can not wait for update")
+	return ctx.JSONResponse(&waitResponse{Receipt: receipt, Error: err.Error()}, http.StatusBadGateway)
+}
+
+// waitResponse is the body of a synchronous write which failed after the record was sent.
+type waitResponse struct {
+	*adapter.Receipt
+	Error string `json:"error"`
+}
+
+// waitTimeout returns whether the client asked to wait until its record is
+// searchable, with ?wait=true or Prefer: wait, and for how long. Prefer:
+// wait=N and ?timeout= shorten the wait.
+func (a *API) waitTimeout(ctx *atr.RequestCtx) (bool, time.Duration, error) {
+	wait, timeout := false, a.maxWait
+	args := ctx.QueryArgs()
+	if args.Has("wait") {
+		var err error
+		if wait, err = strconv.ParseBool(string(args.Peek("wait"))); err != nil {
+			return false, 0, fmt.Errorf("wait must be a boolean")
+		}
+	}
+	for _, p := range strings.Split(string(ctx.Request.Header.Peek(preferHeader)), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if !strings.EqualFold(kv[0], "wait") {
+			continue
+		}
+		wait = true
+		if len(kv) == 2 {
+			sec, err := strconv.ParseUint(strings.Trim(kv[1], `"`), 10, 32)
+			if err != nil || sec == 0 {
+				return false, 0, fmt.Errorf("prefer wait must be a positive number of seconds")
+			}
+			timeout = minDuration(timeout, time.Duration(sec)*time.Second)
+		}
+		ctx.Response.Header.Set(preferenceAppliedHeader, "wait")
+	}
+	if args.Has("timeout") {
+		d, err := time.ParseDuration(string(args.Peek("timeout")))
+		if err != nil || d <= 0 {
+			return false, 0, fmt.Errorf("timeout must be a positive duration")
+		}
+		timeout = minDuration(timeout, d)
+	}
+	return wait, timeout, nil
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (a *API) HandleLogContext(ctx *atr.RequestCtx) error {
+	uid := index(ctx)
+	id := ctx.UserValue("id").(string)
+	req, err := parseContextRequest(ctx.QueryArgs())
+	if err != nil {
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	resp, err := a.ad.LogContext(uid, id, req)
+	if err != nil {
+		return readError(ctx, err)
+	}
+	return ctx.JSONResponse(resp, http.StatusOK)
+}
+
+func (a *API) HandleSearch(ctx *atr.RequestCtx) error {
+	uid := index(ctx)
+	args := ctx.QueryArgs()
+	req := &adapter.SearchRequest{
+		Query:   string(args.Peek("q")),
+		Filters: string(args.Peek("filters")),
+		Limit:   defaultSearchLimit,
+	}
+	if args.Has("offset") {
+		offset, err := args.GetUint("offset")
+		if err != nil {
+			return ctx.ErrorResponse(fmt.Errorf("offset must be a non-negative number"), http.StatusBadRequest)
+		}
+		req.Offset = int64(offset)
+	}
+	if args.Has("limit") {
+		limit, err := args.GetUint("limit")
+		if err != nil || limit == 0 || limit > maxSearchLimit {
+			return ctx.ErrorResponse(fmt.Errorf("limit must be a number between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+		}
+		req.Limit = int64(limit)
+	}
+	resp, err := a.ad.Search(uid, req)
+	if err != nil {
+		return readError(ctx, err)
+	}
+	return ctx.JSONResponse(resp, http.StatusOK)
+}
+
+func (a *API) HandleFields(ctx *atr.RequestCtx) error {
+	fields, err := a.ad.Fields(index(ctx))
+	if err != nil {
+		return readError(ctx, err)
+	}
+	return ctx.JSONResponse(fields, http.StatusOK)
+}
+
+// HandleListIndexes lists the indexes of the tenant the client is allowed
+// to read, named after their tags.
+func (a *API) HandleListIndexes(ctx *atr.RequestCtx) error {
+	indexes, err := a.ad.Indexes()
+	if err != nil {
+		return readError(ctx, err)
+	}
+	id, t := identity(ctx), currentTenant(ctx)
+	res := indexes[:0]
+	for _, ind := range indexes {
+		tag, ok := t.Tag(ind.UID)
+		if !ok || !id.Allows(tag, auth.Read) {
+			continue
+		}
+		if ind.Name == ind.UID {
+			ind.Name = tag
+		}
+		ind.UID = tag
+		res = append(res, ind)
+	}
+	return ctx.JSONResponse(res, http.StatusOK)
+}
+
+func (a *API) HandleDeleteIndex(ctx *atr.RequestCtx) error {
+	if err := a.ad.DeleteIndex(index(ctx)); err != nil {
+		return readError(ctx, err)
+	}
+	ctx.Response.SetStatusCode(http.StatusNoContent)
+	return nil
+}
+
+// validationResponse is the body of a response rejecting a record.
+type validationResponse struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readError sets the response status matching an error returned by the adapter.
+func readError(ctx *atr.RequestCtx, err error) error {
+	switch {
+	case errors.Is(err, adapter.ErrNotFound):
+		return ctx.ErrorResponse(err, http.StatusNotFound)
+	case errors.Is(err, adapter.ErrMissingField), errors.Is(err, adapter.ErrInvalidField):
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	case errors.Is(err, breaker.ErrOpen):
+		return ctx.ErrorResponse(err, http.StatusServiceUnavailable)
+	}
+	log.Err(err).Bytes("path", ctx.Path()).Msg("can not read from database")
+	return ctx.ErrorResponse(err, http.StatusBadGateway)
+}
+
+func parseContextRequest(args *fasthttp.Args) (*adapter.ContextRequest, error) {
+	req := &adapter.ContextRequest{
+		Before: defaultContextSize,
+		After:  defaultContextSize,
+		Span:   defaultContextSpan,
+	}
+	var err error
+	if args.Has("before") {
+		if req.Before, err = args.GetUint("before"); err != nil || req.Before > maxContextSize {
+			return nil, fmt.Errorf("before must be a number between 0 and %d", maxContextSize)
+		}
+	}
+	if args.Has("after") {
+		if req.After, err = args.GetUint("after"); err != nil || req.After > maxContextSize {
+			return nil, fmt.Errorf("after must be a number between 0 and %d", maxContextSize)
+		}
+	}
+	if args.Has("span") {
+		if req.Span, err = time.ParseDuration(string(args.Peek("span"))); err != nil || req.Span <= 0 {
+			return nil, fmt.Errorf("span must be a positive duration")
+		}
+	}
+	for _, by := range args.PeekMulti("by") {
+		for _, f := range strings.Split(string(by), ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				if err = adapter.ValidateField(f); err != nil {
+					return nil, err
+				}
+				req.By = append(req.By, f)
+			}
+		}
+	}
+	return req, nil
+}
+
 func logRequest() atr.Middleware {
 	return func(ctx *atr.RequestCtx) error {
 		res := &ctx.Response
@@ -145,6 +497,59 @@ func logRequest() atr.Middleware {
 	}
 }
 
+func countRequest() atr.Middleware {
+	return func(ctx *atr.RequestCtx) error {
+		observeRequest(ctx)
+		return ctx.Next()
+	}
+}
+
+// errorView writes the error like the default atreugo view, it also counts
+// the request because after middlewares are skipped for failed views.
+func errorView(ctx *atr.RequestCtx, err error, statusCode int) {
+	ctx.Error(err.Error(), statusCode)
+	if statusCode == http.StatusUnauthorized {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, authChallenge)
+	}
+	if d := retryAfter(err); d > 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+	observeRequest(ctx)
+}
+
+// retryAfter returns when a request rejected with the error may be retried, 0 if it may not.
+func retryAfter(err error) time.Duration {
+	var qErr *tenant.QuotaError
+	var lErr *ratelimit.LimitError
+	var sErr *apperrors.ShedError
+	var bErr *breaker.OpenError
+	switch {
+	case errors.As(err, &qErr):
+		return qErr.RetryAfter
+	case errors.As(err, &lErr):
+		return lErr.RetryAfter
+	case errors.As(err, &sErr):
+		return sErr.RetryAfter
+	case errors.As(err, &bErr):
+		return bErr.RetryAfter
+	case errors.Is(err, errBusy):
+		return busyRetryAfter
+	}
+	return 0
+}
+
+// observeRequest counts the request by its status and, once it was accepted,
+// by the index of the tag checked by tenancy. Rejected requests have no tag,
+// so clients can not add series for tags of their choice.
+func observeRequest(ctx *atr.RequestCtx) {
+	status := ctx.Response.StatusCode()
+	uid, _ := ctx.UserValue(indexKey).(string)
+	if status >= http.StatusBadRequest {
+		uid = ""
+	}
+	metrics.Requests.WithLabelValues(strconv.Itoa(status), uid).Inc()
+}
+
 func (a *API) Run() error {
 	return a.srv.ServeGracefully(a.ln)
 }
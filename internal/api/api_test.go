@@ -1,15 +1,39 @@
 package api
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
-	mocks "github.com/polyse/logDb/test/mock"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/breaker"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/multiline"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/spool"
+	"github.com/polyse/logdb/internal/tenant"
+	mocks "github.com/polyse/logdb/test/mocks/adapter"
 	atr "github.com/savsgio/atreugo/v11"
+	ml "github.com/senyast4745/meilisearch-go"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"github.com/valyala/fasthttp"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -31,6 +55,7 @@ func (a *APIUnitTestSuite) SetupTest() {
 	a.NoError(err)
 	a.ln = ln
 	a.adapter = &mocks.Adapter{}
+	a.adapter.On("Available").Maybe().Return(nil)
 	a.errs = make(chan error, 1)
 	a.api = &API{
 		ad:    a.adapter,
@@ -82,6 +107,118 @@ func (a *APIUnitTestSuite) Test_HealthCheck() {
 	a.Equal("OK", string(resp.Body()))
 }
 
+func (a *APIUnitTestSuite) Test_Readiness_Ready() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/health/ready")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.api.checks = []ReadinessCheck{{Name: "test", Check: func() (string, error) {
+		return "fine", nil
+	}}}
+	a.adapter.On("DatabaseHealthCheck").Times(1).Return(nil)
+	a.adapter.On("IndexCache").Times(1).Return(2, nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.JSONEq(`{"status":"ready","components":{
+		"meilisearch":{"status":"up"},
+		"index_cache":{"status":"up","detail":"2 indexes"},
+		"writes":{"status":"up","detail":"0 pending, 0 spooled"},
+		"test":{"status":"up","detail":"fine"}}}`, string(resp.Body()))
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Readiness_Not_Ready() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/health/ready")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("DatabaseHealthCheck").Times(1).Return(fmt.Errorf("test error"))
+	a.adapter.On("IndexCache").Times(1).Return(0, fmt.Errorf("test error"))
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	a.JSONEq(`{"status":"not ready","components":{
+		"meilisearch":{"status":"down","error":"test error"},
+		"index_cache":{"status":"down","detail":"not loaded","error":"test error"},
+		"writes":{"status":"up","detail":"0 pending, 0 spooled"}}}`, string(resp.Body()))
+}
+
+func (a *APIUnitTestSuite) Test_Error_Shedding() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, a.api.errs = apperrors.NewHandler(ctx, &apperrors.Config{
+		Policies:  map[apperrors.Class]apperrors.Policy{apperrors.Transient: {MaxCount: 1, Action: apperrors.Shed}},
+		ResetTime: time.Minute,
+	})
+	a.api.errs.Chan() <- context.DeadlineExceeded
+	a.api.errs.Chan() <- context.DeadlineExceeded
+	a.Eventually(func() bool {
+		return a.api.errs.Shed() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"test"}`)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	a.NotEmpty(resp.Header.Peek("Retry-After"))
+	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.Anything, mock.Anything, mock.Anything)
+
+	req.Reset()
+	req.SetRequestURI(a.host + "/health/errors")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	state := &apperrors.State{}
+	a.NoError(json.Unmarshal(resp.Body(), state))
+	a.Equal(apperrors.Shed, state.Action)
+	a.Equal(uint32(2), state.Classes[apperrors.Transient].Count)
+	a.True(state.Classes[apperrors.Transient].Tripped)
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Breaker_Open() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"test"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.ExpectedCalls = nil
+	a.adapter.On("Available").Return(&breaker.OpenError{RetryAfter: 3 * time.Second})
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	a.Equal("3", string(resp.Header.Peek("Retry-After")))
+	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.Anything, mock.Anything, mock.Anything)
+	a.Empty(a.errs)
+	a.Empty(a.api.conCh)
+}
+
 func (a *APIUnitTestSuite) Test_SaveData_Normal() {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
@@ -89,11 +226,12 @@ func (a *APIUnitTestSuite) Test_SaveData_Normal() {
 	req.SetRequestURI(a.host + "/logs/test")
 	req.Header.SetMethod(http.MethodPut)
 	req.Header.Set("Content-Type", "application/json")
+	req.SetBodyString(`{"message":"test"}`)
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
 
-	a.adapter.On("SaveData", mock.AnythingOfType("[]uint8"), "test").Times(1).Return(nil)
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test").Times(1).Return(nil, nil)
 	err := a.httpCli.Do(req, resp)
 
 	time.Sleep(10 * time.Millisecond)
@@ -104,6 +242,27 @@ func (a *APIUnitTestSuite) Test_SaveData_Normal() {
 
 }
 
+func (a *APIUnitTestSuite) Test_SaveData_Timestamp_Not_Trusted() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"backdated","@timestamp":1}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	// the adapter replaces the @timestamp of records saved without a time
+	a.adapter.On("SaveData", loggedAt(time.Time{}), []byte(`{"message":"backdated","@timestamp":1}`), "test").
+		Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+}
+
 func (a *APIUnitTestSuite) Test_SaveData_Server_Err() {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
@@ -111,11 +270,12 @@ func (a *APIUnitTestSuite) Test_SaveData_Server_Err() {
 	req.SetRequestURI(a.host + "/logs/test")
 	req.Header.SetMethod(http.MethodPut)
 	req.Header.Set("Content-Type", "application/json")
+	req.SetBodyString(`{"message":"test"}`)
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
 
-	a.adapter.On("SaveData", mock.AnythingOfType("[]uint8"), "test").Times(1).Return(fmt.Errorf("test error"))
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test").Times(1).Return(nil, fmt.Errorf("test error"))
 
 	err := a.httpCli.Do(req, resp)
 
@@ -138,6 +298,7 @@ func (a *APIUnitTestSuite) Test_SaveData_Server_Busy() {
 	req.SetRequestURI(a.host + "/logs/test")
 	req.Header.SetMethod(http.MethodPut)
 	req.Header.Set("Content-Type", "application/json")
+	req.SetBodyString(`{"message":"test"}`)
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
@@ -147,12 +308,11 @@ func (a *APIUnitTestSuite) Test_SaveData_Server_Busy() {
 	err := a.httpCli.Do(req, resp)
 	a.NoError(err)
 	a.Equal(http.StatusServiceUnavailable, resp.StatusCode())
-
-	err = <-a.errs
-	a.Error(err)
+	a.Equal("1", string(resp.Header.Peek("Retry-After")))
+	a.Empty(a.errs, "a busy database is not reported to the error handler")
 
 	a.adapter.AssertExpectations(a.T())
-	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.AnythingOfType("[]uint8"), "test")
+	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test")
 }
 
 func (a *APIUnitTestSuite) Test_SaveData_Server_Dead() {
@@ -164,6 +324,7 @@ func (a *APIUnitTestSuite) Test_SaveData_Server_Dead() {
 	req.SetRequestURI(a.host + "/logs/test")
 	req.Header.SetMethod(http.MethodPut)
 	req.Header.Set("Content-Type", "application/json")
+	req.SetBodyString(`{"message":"test"}`)
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
@@ -177,20 +338,1317 @@ func (a *APIUnitTestSuite) Test_SaveData_Server_Dead() {
 	a.Error(err)
 
 	a.adapter.AssertExpectations(a.T())
-	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.AnythingOfType("[]uint8"), "test")
+	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test")
 
 }
 
-func getFreeLocalAddr() (*net.TCPAddr, error) {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
-	if err != nil {
-		return nil, err
+func (a *APIUnitTestSuite) Test_Drain() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	dir, err := ioutil.TempDir("", "spool")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	a.api.spool, err = spool.NewSpool(dir)
+	a.NoError(err)
+	a.api.conCh = make(chan struct{}, 3)
+
+	release := make(chan struct{})
+	cancelled := make(chan error, 1)
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"n":1}`), "test").Once().Return(nil, nil)
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"n":2}`), "test").Once().
+		Return(nil, context.DeadlineExceeded)
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"n":3}`), "test").Once().
+		Run(func(args mock.Arguments) {
+			<-release
+			cancelled <- args.Get(0).(context.Context).Err()
+		}).Return(nil, nil)
+
+	for i := 1; i <= 3; i++ {
+		req.SetBodyString(fmt.Sprintf(`{"n":%d}`, i))
+		a.NoError(a.httpCli.Do(req, resp))
+		a.Equal(http.StatusAccepted, resp.StatusCode())
 	}
+	a.Equal(context.DeadlineExceeded, <-a.errs)
+	a.api.Drain(50 * time.Millisecond)
+	a.Zero(a.api.pendingWrites())
+	close(release)
+	a.Equal(context.Canceled, <-cancelled, "pending writes are cancelled")
 
-	l, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		return nil, err
+	req.SetBodyString(`{"n":4}`)
+	a.NoError(a.httpCli.Do(req, resp))
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode(), "writes are rejected while draining")
+	// accepted before draining started, but tracked after the records were spooled
+	a.api.saveAsync(context.Background(), "test", []byte(`{"n":5}`))
+	a.NoError(a.api.spool.Close())
+
+	var spooled, ids []string
+	next, err := spool.NewSpool(dir)
+	a.NoError(err)
+	replayed, _, err := next.Replay(func(rec *spool.Record) error {
+		spooled = append(spooled, rec.Index+" "+string(rec.Data))
+		ids = append(ids, rec.Id)
+		return nil
+	})
+	a.NoError(err)
+	a.Equal(3, replayed)
+	a.ElementsMatch([]string{`test {"n":2}`, `test {"n":3}`, `test {"n":5}`}, spooled,
+		"failed and pending records are spooled")
+	for _, id := range ids {
+		a.NotEmpty(id, "spooled records keep their id")
 	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr), nil
+}
+
+func (a *APIUnitTestSuite) Test_Spool_Keeps_Record() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"n":1}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	dir, err := ioutil.TempDir("", "spool")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	a.api.spool, err = spool.NewSpool(dir)
+	a.NoError(err)
+
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"n":1}`), "test").Once().
+		Run(func(args mock.Arguments) {
+			data := args.Get(1).([]byte)
+			copy(data, `[{"n"`)
+		}).Return(nil, context.DeadlineExceeded)
+
+	a.NoError(a.httpCli.Do(req, resp))
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	a.Equal(context.DeadlineExceeded, <-a.errs)
+	a.api.Drain(time.Second)
+
+	var spooled []string
+	next, err := spool.NewSpool(dir)
+	a.NoError(err)
+	_, _, err = next.Replay(func(rec *spool.Record) error {
+		spooled = append(spooled, string(rec.Data))
+		return nil
+	})
+	a.NoError(err)
+	a.Equal([]string{`{"n":1}`}, spooled, "the record changed by SaveData is not spooled")
+}
+
+func (a *APIUnitTestSuite) Test_ReplaySpool_While_Running() {
+	dir, err := ioutil.TempDir("", "spool")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	a.api.spool, err = spool.NewSpool(dir)
+	a.NoError(err)
+	a.api.replayInterval = 10 * time.Millisecond
+
+	saved := make(chan struct{})
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"n":1}`), "test").Once().Return(nil, nil).
+		Run(func(mock.Arguments) {
+			close(saved)
+		})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.api.ReplaySpool(ctx)
+
+	// written by this run after the replay at startup
+	a.NoError(a.api.spool.Write(&spool.Record{Index: "test", Data: []byte(`{"n":1}`)}))
+	select {
+	case <-saved:
+	case <-time.After(time.Second):
+		a.Fail("spooled record is not replayed")
+	}
+	a.Eventually(func() bool {
+		return a.api.spool.Backlog() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Invalid() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.api.maxSize = 32
+	for body, reason := range map[string]string{
+		``:                      adapter.ReasonEmpty,
+		strings.Repeat(" ", 33): adapter.ReasonEmpty,
+		`{"message":`:           adapter.ReasonMalformed,
+		`["message"]`:           adapter.ReasonNotObject,
+		`{"message":"` + strings.Repeat("a", 30) + `"}`: adapter.ReasonTooLarge,
+	} {
+		req.SetBodyString(body)
+		err := a.httpCli.Do(req, resp)
+		a.NoError(err)
+		a.Equal(http.StatusBadRequest, resp.StatusCode(), body)
+		res := &validationResponse{}
+		a.NoError(json.Unmarshal(resp.Body(), res))
+		a.Equal("invalid record", res.Error)
+		a.Equal(reason, res.Reason, body)
+	}
+	a.adapter.AssertNotCalled(a.T(), "SaveData", mock.Anything, mock.Anything, mock.Anything)
+	a.Empty(a.errs)
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Wait() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test?wait=true")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"test"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.api.maxWait = time.Second
+	receipt := &adapter.Receipt{Id: "id", UpdateId: 7}
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test").Times(3).Return(receipt, nil)
+	a.adapter.On("WaitForUpdate", mock.Anything, "test", int64(7)).Once().Return(nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusCreated, resp.StatusCode())
+	a.JSONEq(`{"@id":"id","updateId":7}`, string(resp.Body()))
+
+	a.adapter.On("WaitForUpdate", mock.Anything, "test", int64(7)).Once().
+		Return(fmt.Errorf("%w: test error", adapter.ErrUpdateFailed))
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadGateway, resp.StatusCode())
+	a.JSONEq(`{"@id":"id","updateId":7,"error":"update failed: test error"}`, string(resp.Body()))
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.Set("Prefer", "wait=1")
+	a.adapter.On("WaitForUpdate", mock.Anything, "test", int64(7)).Once().Return(context.DeadlineExceeded)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusGatewayTimeout, resp.StatusCode())
+	a.Equal("wait", string(resp.Header.Peek("Preference-Applied")))
+	a.JSONEq(`{"@id":"id","updateId":7,"error":"record not searchable after 1s"}`, string(resp.Body()))
+
+	req.Header.Set("Prefer", "wait=soon")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	a.adapter.AssertExpectations(a.T())
+	a.Empty(a.errs)
+	a.Empty(a.api.conCh)
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Wait_Breaker_Open() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test?wait=true")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"test"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.api.maxWait = time.Second
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test").Once().
+		Return(nil, apperrors.Wrap(apperrors.Transient, &breaker.OpenError{RetryAfter: 3 * time.Second}))
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	a.Equal("3", string(resp.Header.Peek("Retry-After")))
+	a.True(errors.Is(<-a.errs, breaker.ErrOpen))
+
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "test").Once().
+		Return(nil, errors.New("test error"))
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadGateway, resp.StatusCode())
+	<-a.errs
+	a.adapter.AssertExpectations(a.T())
+	a.Empty(a.api.conCh)
+}
+
+func (a *APIUnitTestSuite) Test_LogContext_Normal() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/anchor/context?before=10&after=20&by=host,pod&span=1h")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	expReq := &adapter.ContextRequest{Before: 10, After: 20, By: []string{"host", "pod"}, Span: time.Hour}
+	a.adapter.On("LogContext", "test", "anchor", expReq).Times(1).Return(&adapter.ContextResponse{
+		Anchor: adapter.Document{"@id": "anchor"},
+		Before: []adapter.Document{},
+		After:  []adapter.Document{},
+	}, nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.JSONEq(`{"anchor":{"@id":"anchor"},"before":[],"after":[]}`, string(resp.Body()))
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_LogContext_Bad_Request() {
+	for _, query := range []string{"before=-1", "by=host,pod%20OR%20level", "by=host%3D1"} {
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(a.host + "/logs/test/anchor/context?" + query)
+		req.Header.SetMethod(http.MethodGet)
+
+		resp := fasthttp.AcquireResponse()
+		err := a.httpCli.Do(req, resp)
+		a.NoError(err)
+		a.Equal(http.StatusBadRequest, resp.StatusCode(), query)
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+	a.adapter.AssertNotCalled(a.T(), "LogContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (a *APIUnitTestSuite) Test_LogContext_Not_Found() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/anchor/context")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("LogContext", "test", "anchor", mock.Anything).Times(1).Return(nil, adapter.ErrNotFound)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode())
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Search_Normal() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/search?q=boom&filters=level%3Derror&offset=5&limit=10")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	expReq := &adapter.SearchRequest{Query: "boom", Filters: "level=error", Offset: 5, Limit: 10}
+	a.adapter.On("Search", "test", expReq).Times(1).Return(&adapter.SearchResponse{
+		Hits:  []adapter.Document{{"@id": "1"}},
+		Total: 1,
+	}, nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.JSONEq(`{"hits":[{"@id":"1"}],"total":1,"offset":0,"limit":0}`, string(resp.Body()))
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Search_Bad_Limit() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/search?limit=0")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (a *APIUnitTestSuite) Test_Fields_Not_Found() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/fields")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Fields", "test").Times(1).Return(nil, adapter.ErrNotFound)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode())
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Indexes() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/indexes")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Indexes").Times(1).Return([]ml.Index{{UID: "test"}}, nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Contains(string(resp.Body()), `"uid":"test"`)
+
+	req.SetRequestURI(a.host + "/indexes/test")
+	req.Header.SetMethod(http.MethodDelete)
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode(), "the admin API requires authentication")
+	a.adapter.AssertNotCalled(a.T(), "DeleteIndex", "test")
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Metrics() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/fields")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Fields", "test").Times(1).Return([]string{"message"}, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.adapter.On("Fields", "missing").Times(1).Return(nil, adapter.ErrNotFound)
+	req.SetRequestURI(a.host + "/logs/missing/fields")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode())
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/metrics")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Contains(string(resp.Body()), `logdb_http_requests_total{status="200",tag="test"}`)
+	a.Contains(string(resp.Body()), `logdb_http_requests_total{status="404",tag=""}`)
+	a.NotContains(string(resp.Body()), `tag="missing"`, "rejected requests are not counted by tag")
+	a.Contains(string(resp.Body()), "logdb_db_connections_in_use")
+}
+
+func (a *APIUnitTestSuite) withAuth() {
+	store, err := auth.NewStore("")
+	a.NoError(err)
+	store.Set([]*auth.Credential{
+		{Name: "writer", Key: "write-key", Tags: []string{"app-*"}, Scopes: []auth.Scope{auth.Write}},
+		{Name: "reader", Key: "read-key", Tags: []string{"app-*"}, Scopes: []auth.Scope{auth.Read}},
+	}, true)
+	a.api.auth = store
+}
+
+func (a *APIUnitTestSuite) Test_Auth_Write() {
+	a.withAuth()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/app-1")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString(`{"message":"test"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode())
+	a.Equal(`Bearer realm="logdb"`, string(resp.Header.Peek("WWW-Authenticate")))
+
+	req.Header.Set(auth.KeyHeader, "read-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode())
+
+	req.Header.Set(auth.KeyHeader, "write-key")
+	req.SetRequestURI(a.host + "/logs/other")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode())
+
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "app-1").Times(1).Return(nil, nil)
+	req.SetRequestURI(a.host + "/logs/app-1")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/metrics")
+	req.Header.SetMethod(http.MethodGet)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Contains(string(resp.Body()), `logdb_auth_failures_total{reason="missing"}`)
+	a.Contains(string(resp.Body()), `logdb_auth_failures_total{reason="forbidden"}`)
+}
+
+func (a *APIUnitTestSuite) Test_Auth_Indexes_Filtered() {
+	a.withAuth()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/indexes")
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.Set("Authorization", "Bearer read-key")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Indexes").Times(1).Return([]ml.Index{{UID: "app-1"}, {UID: "other"}}, nil)
+
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Contains(string(resp.Body()), `"uid":"app-1"`)
+	a.NotContains(string(resp.Body()), `"uid":"other"`)
+}
+
+func (a *APIUnitTestSuite) Test_Tenant_Isolation() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/app")
+	req.Header.SetMethod(http.MethodPut)
+	req.Header.Set(tenant.Header, "team-a")
+	req.SetBodyString(`{"message":"test"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "team-a__app").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetRequestURI(a.host + "/logs/team-b__app")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode(), "tags can not address indexes of other tenants")
+
+	req.SetRequestURI(a.host + "/logs/a.b")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode(), "tags must be valid index uids")
+
+	req.SetRequestURI(a.host + "/indexes")
+	req.Header.SetMethod(http.MethodGet)
+	a.adapter.On("Indexes").Return([]ml.Index{{UID: "team-a__app", Name: "team-a__app"}, {UID: "team-b__app"}, {UID: "app"}}, nil)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.JSONEq(`[{"name":"app","uid":"app","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]`,
+		string(resp.Body()))
+
+	req.Header.Del(tenant.Header)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Contains(string(resp.Body()), `"uid":"app"`)
+	a.NotContains(string(resp.Body()), `__app`, "the default tenant sees only its own indexes")
+}
+
+func (a *APIUnitTestSuite) Test_Tenant_Of_Credential() {
+	store, err := auth.NewStore("")
+	a.NoError(err)
+	store.Set([]*auth.Credential{
+		{Name: "team-a", Key: "a-key", Tenant: "team-a", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Read}},
+	}, true)
+	a.api.auth = store
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/app/fields")
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.Set(auth.KeyHeader, "a-key")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Fields", "team-a__app").Times(1).Return([]string{"message"}, nil)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.adapter.AssertExpectations(a.T())
+
+	req.Header.Set(tenant.Header, "team-b")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode())
+}
+
+func (a *APIUnitTestSuite) Test_Tenant_Header_Requires_Admin() {
+	store, err := auth.NewStore("")
+	a.NoError(err)
+	store.Set([]*auth.Credential{
+		{Name: "reader", Key: "r-key", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Read}},
+		{Name: "admin", Key: "admin-key", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Read, auth.Admin}},
+	}, true)
+	a.api.auth = store
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/app/fields")
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.Set(auth.KeyHeader, "r-key")
+	req.Header.Set(tenant.Header, "team-b")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode(), "credentials without tenant can not choose one")
+
+	a.adapter.On("Fields", "team-b__app").Times(1).Return([]string{"message"}, nil)
+	req.Header.Set(auth.KeyHeader, "admin-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+
+	a.adapter.On("Fields", "app").Times(1).Return([]string{"message"}, nil)
+	req.Header.Set(auth.KeyHeader, "r-key")
+	req.Header.Del(tenant.Header)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Tenant_Quotas() {
+	reg, err := tenant.NewRegistry("")
+	a.NoError(err)
+	reg.Set([]*tenant.Tenant{{ID: "team-a", MaxIndexes: 1, MaxDailyBytes: 10}}, true)
+	a.api.tenants = reg
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/new")
+	req.Header.SetMethod(http.MethodPut)
+	req.Header.Set(tenant.Header, "team-a")
+	req.SetBodyString(`{"a":1}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("HasIndex", "team-a__new").Return(false)
+	a.adapter.On("Indexes").Return([]ml.Index{{UID: "team-a__app"}, {UID: "team-b__new"}}, nil)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode())
+
+	a.adapter.On("HasIndex", "team-a__app").Return(true)
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "team-a__app").Times(1).Return(nil, nil)
+	req.SetRequestURI(a.host + "/logs/app")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusTooManyRequests, resp.StatusCode())
+	a.NotEmpty(resp.Header.Peek("Retry-After"))
+
+	req.Header.Set(tenant.Header, "team-b")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode(), "unknown tenant")
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Rate_Limit() {
+	a.api.limiter = ratelimit.NewLimiter(context.Background(), &ratelimit.Config{
+		Tag: ratelimit.Limit{Rate: 0.1, Burst: 1},
+	})
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/fields")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("Fields", "test").Times(1).Return([]string{"message"}, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusTooManyRequests, resp.StatusCode())
+	a.Equal("10", string(resp.Header.Peek("Retry-After")))
+	a.adapter.AssertExpectations(a.T())
+
+	a.adapter.On("Fields", "other").Times(1).Return([]string{"message"}, nil)
+	req.SetRequestURI(a.host + "/logs/other/fields")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode(), "other tags have their own bucket")
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/metrics")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Contains(string(resp.Body()), `logdb_rate_limited_total{limit="tag"}`)
+}
+
+func (a *APIUnitTestSuite) Test_Rate_Limit_Before_Auth() {
+	store, err := auth.NewStore("")
+	a.NoError(err)
+	store.Set([]*auth.Credential{{Name: "reader", Key: "r-key", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Read}}}, true)
+	a.api.auth = store
+	a.api.limiter = ratelimit.NewLimiter(context.Background(), &ratelimit.Config{
+		IP: ratelimit.Limit{Rate: 0.1, Burst: 1},
+	})
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test/fields")
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.Set(auth.KeyHeader, "wrong-key")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode())
+
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusTooManyRequests, resp.StatusCode(), "failed authentications count in the IP limit")
+
+	req.Header.Del(auth.KeyHeader)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusTooManyRequests, resp.StatusCode())
+}
+
+func getFreeLocalAddr() (*net.TCPAddr, error) {
+	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr), nil
+}
+
+func (a *APIUnitTestSuite) Test_Admin_Indexes() {
+	store, err := auth.NewStore("")
+	a.NoError(err)
+	store.Set([]*auth.Credential{
+		{Name: "admin", Key: "admin-key", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Admin}},
+		{Name: "writer", Key: "write-key", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Write}},
+		{Name: "team-a", Key: "a-key", Tenant: "team-a", Tags: []string{"*"}, Scopes: []auth.Scope{auth.Admin}},
+	}, true)
+	a.api.auth = store
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/admin/indexes")
+	req.Header.SetMethod(http.MethodGet)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.Set(auth.KeyHeader, "write-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode())
+
+	req.Header.Set(auth.KeyHeader, "a-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode(), "credentials bound to a tenant are not admins")
+
+	a.adapter.On("AllIndexStats").Times(1).Return([]adapter.IndexStats{
+		{UID: "team-a__app", Documents: 3},
+		{UID: "app", Documents: 1, IsIndexing: true},
+	}, nil)
+	req.Header.Set(auth.KeyHeader, "admin-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.JSONEq(`[
+		{"uid":"team-a__app","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z",
+			"documents":3,"isIndexing":false,"tenant":"team-a","tag":"app"},
+		{"uid":"app","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z",
+			"documents":1,"isIndexing":true,"tenant":"","tag":"app"}
+	]`, string(resp.Body()))
+
+	a.adapter.On("IndexStats", "missing").Times(1).Return(nil, adapter.ErrNotFound)
+	req.SetRequestURI(a.host + "/admin/indexes/missing")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode())
+
+	a.adapter.On("ApplySettings", "team-a__app").Times(1).Return(int64(7), nil)
+	req.SetRequestURI(a.host + "/admin/indexes/team-a__app/settings")
+	req.Header.SetMethod(http.MethodPost)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	a.JSONEq(`{"updateId":7}`, string(resp.Body()))
+
+	a.adapter.On("ClearFields", "team-a__app").Times(1).Return(nil)
+	req.SetRequestURI(a.host + "/admin/indexes/team-a__app/fields")
+	req.Header.SetMethod(http.MethodDelete)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode())
+
+	a.adapter.On("DeleteIndex", "team-a__app").Times(1).Return(nil)
+	req.SetRequestURI(a.host + "/admin/indexes/team-a__app")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode())
+
+	req.SetRequestURI(a.host + "/indexes/app")
+	for _, key := range []string{"write-key", "a-key"} {
+		req.Header.Set(auth.KeyHeader, key)
+		err = a.httpCli.Do(req, resp)
+		a.NoError(err)
+		a.Equal(http.StatusForbidden, resp.StatusCode(), "only admins delete indexes by tag")
+	}
+	a.adapter.On("DeleteIndex", "app").Times(1).Return(fmt.Errorf("test error"))
+	req.Header.Set(auth.KeyHeader, "admin-key")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadGateway, resp.StatusCode())
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Bulk() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/app/_bulk")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/x-ndjson")
+	req.SetBodyString(`{"index":{"_index":"web","_id":"1"}}
+{"message":"first"}
+{"create":{}}
+{"message":"second"}
+{"delete":{"_index":"web","_id":"1"}}
+{"index":{"_index":"web"}}
+not json
+`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"message":"first"}`), "web").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"message":"second"}`), "app").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+
+	res := &bulkResponse{}
+	a.NoError(json.Unmarshal(resp.Body(), res))
+	a.True(res.Errors)
+	a.Len(res.Items, 4)
+	a.Equal(&bulkItem{Index: "web", Id: "1", Status: http.StatusCreated, Result: "created"}, res.Items[0][bulkIndex])
+	a.Equal(&bulkItem{Index: "app", Status: http.StatusCreated, Result: "created"}, res.Items[1][bulkCreate])
+	a.Equal(http.StatusBadRequest, res.Items[2][bulkDelete].Status)
+	a.Equal("mapper_parsing_exception", res.Items[3][bulkIndex].Error.Type)
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/_bulk")
+	req.SetBodyString(`{"index":{"_index":"web"}`)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	req.SetBodyString(`{"index":{}}` + "\n" + `{"message":"test"}`)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Contains(string(resp.Body()), "_index is missing")
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/")
+	req.Header.SetMethod(http.MethodGet)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Contains(string(resp.Body()), `"number":"`+esVersion+`"`)
+}
+
+func (a *APIUnitTestSuite) Test_Bulk_Tags() {
+	a.api.limiter = ratelimit.NewLimiter(context.Background(), &ratelimit.Config{
+		Tag: ratelimit.Limit{Rate: 0.1, Burst: 1},
+	})
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/_bulk")
+	req.Header.SetMethod(http.MethodPost)
+	req.SetBodyString(`{"index":{"_index":"filebeat-7.10.2-2026.10.19"}}
+{"message":"first"}
+{"index":{"_index":"ns/app"}}
+{"message":"second"}
+{"index":{"_index":"ns/app"}}
+{"message":"third"}
+{"index":{"_index":"../"}}
+{"message":"fourth"}
+`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"message":"first"}`), "filebeat-7-10-2-2026-10-19").
+		Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"message":"second"}`), "ns-app").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+
+	res := &bulkResponse{}
+	a.NoError(json.Unmarshal(resp.Body(), res))
+	a.Len(res.Items, 4)
+	a.Equal(http.StatusCreated, res.Items[0][bulkIndex].Status)
+	a.Equal(http.StatusCreated, res.Items[1][bulkIndex].Status)
+	a.Equal(http.StatusTooManyRequests, res.Items[2][bulkIndex].Status, "every record takes a token of its tag")
+	a.Equal("es_rejected_execution_exception", res.Items[2][bulkIndex].Error.Type)
+	a.Equal(http.StatusBadRequest, res.Items[3][bulkIndex].Status)
+	a.Contains(res.Items[3][bulkIndex].Error.Reason, errEmptyTag.Error())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Bulk_Auth() {
+	a.withAuth()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/_bulk")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.Set(auth.KeyHeader, "write-key")
+	req.SetBodyString(`{"index":{"_index":"app-1"}}
+{"message":"allowed"}
+{"index":{"_index":"other"}}
+{"message":"denied"}
+`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", mock.Anything, mock.AnythingOfType("[]uint8"), "app-1").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+
+	res := &bulkResponse{}
+	a.NoError(json.Unmarshal(resp.Body(), res))
+	a.Equal(http.StatusCreated, res.Items[0][bulkIndex].Status)
+	a.Equal(http.StatusForbidden, res.Items[1][bulkIndex].Status)
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Loki_Push() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/loki/api/v1/push")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.Header.Set(tenant.Header, "team-a")
+	req.SetBodyString(`{"streams":[
+		{"stream":{"job":"app","level":"info"},"values":[["1600000000000000005","started",{"trace_id":"abc"}]]},
+		{"stream":{"level":"warn"},"values":[["1600000001000000000","no job"]]}]}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 5)), mock.MatchedBy(func(data []byte) bool {
+		var rec map[string]interface{}
+		return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(map[string]interface{}{
+			"job": "app", "level": "info", "trace_id": "abc", "message": "started",
+		}, rec)
+	}), "team-a__app").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000001, 0)), mock.AnythingOfType("[]uint8"),
+		"team-a__"+defaultLokiTag).Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetBodyString(`{"streams":[{"stream":{"job":"team-b__app"},"values":[["1600000000000000000","other tenant"]]}]}`)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+	a.Contains(string(resp.Body()), "1 records rejected")
+
+	req.Header.SetContentType("application/x-protobuf")
+	req.SetBodyString("not snappy")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	// the block header claims 1 GiB, which is not allocated
+	header := make([]byte, binary.MaxVarintLen64)
+	req.SetBody(append(header[:binary.PutUvarint(header, 1<<30)], 0x00))
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+
+	req.SetBody(snappy.Encode(nil, nil))
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode(), "an empty push request is valid")
+}
+
+func (a *APIUnitTestSuite) Test_Otlp_Logs() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/v1/logs")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBodyString(`{"resourceLogs":[
+		{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		 "scopeLogs":[{"logRecords":[
+			{"timeUnixNano":"1600000000000000000","severityText":"INFO","body":{"stringValue":"paid"}},
+			{"body":{"stringValue":"other tenant"},
+			 "attributes":[{"key":"x","value":{"intValue":1}}]}]}]},
+		{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"team-b__app"}}]},
+		 "scopeLogs":[{"logRecords":[{"body":{"stringValue":"denied"}}]}]}]}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 0)), mock.MatchedBy(func(data []byte) bool {
+		var rec map[string]interface{}
+		return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(map[string]interface{}{
+			"resource.service.name": "checkout", "severity_text": "INFO", "message": "paid",
+		}, rec)
+	}), "checkout").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", loggedAt(time.Time{}), mock.AnythingOfType("[]uint8"), "checkout").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Equal("application/json", string(resp.Header.ContentType()))
+	a.Contains(string(resp.Body()), `"rejectedLogRecords":"1"`)
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.Header.SetContentType("application/x-protobuf")
+	req.SetBodyString("")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode())
+	a.Empty(resp.Body())
+
+	req.SetBody([]byte{0x0a, 0x05})
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (a *APIUnitTestSuite) Test_Ingest_Input() {
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 0)), []byte(`{"message":"line"}`), "app").
+		Times(1).Return(nil, nil)
+	err := a.api.Ingest(context.Background(), "syslog", "app", "host",
+		map[string]interface{}{"message": "line"}, time.Unix(1600000000, 0))
+	a.NoError(err)
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	err = a.api.Ingest(context.Background(), "syslog", "team-b__app", "host", map[string]interface{}{"message": "line"}, time.Time{})
+	a.True(errors.Is(err, tenant.ErrInvalidTag))
+
+	// listeners wait for a free database connection
+	a.api.conCh <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = a.api.Ingest(ctx, "syslog", "app", "host", map[string]interface{}{"message": "line"}, time.Time{})
+	a.True(errors.Is(err, context.DeadlineExceeded))
+
+	a.adapter.On("SaveData", mock.Anything, []byte(`{"message":"waited"}`), "app").
+		Times(1).Return(nil, nil)
+	time.AfterFunc(20*time.Millisecond, func() { <-a.api.conCh })
+	err = a.api.Ingest(context.Background(), "syslog", "app", "host", map[string]interface{}{"message": "waited"}, time.Time{})
+	a.NoError(err)
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+	a.adapter.AssertNotCalled(a.T(), "DatabaseHealthCheck")
+	a.Empty(a.api.conCh)
+}
+
+func (a *APIUnitTestSuite) Test_Ingest_Input_Multiline() {
+	rule, err := multiline.NewRule("", `^\s`, 0, 0)
+	a.NoError(err)
+	a.api.parsers = &parser.Registry{}
+	a.api.parsers.Set(&parser.Config{Multiline: map[string]*multiline.Rule{"app": rule}})
+	a.api.lines = multiline.NewAggregator(a.api.flushInput)
+
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 0)), mock.MatchedBy(func(data []byte) bool {
+		var rec map[string]interface{}
+		return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(map[string]interface{}{
+			"message": "Traceback:\n  File \"a.py\"", "hostname": "h1",
+		}, rec)
+	}), "app").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 0)), mock.MatchedBy(func(data []byte) bool {
+		var rec map[string]interface{}
+		return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(map[string]interface{}{
+			"message": "other host", "hostname": "h2",
+		}, rec)
+	}), "app").Times(1).Return(nil, nil)
+	for _, m := range []struct{ stream, msg string }{{"h1", "Traceback:"}, {"h2", "other host"}, {"h1", `  File "a.py"`}} {
+		err = a.api.Ingest(context.Background(), "syslog", "app", m.stream,
+			map[string]interface{}{"message": m.msg, "hostname": m.stream}, time.Unix(1600000000, 0))
+		a.NoError(err)
+	}
+	a.api.lines.Flush()
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+}
+
+func (a *APIUnitTestSuite) Test_Gelf() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(a.host, "/api") + "/gelf")
+	req.Header.SetMethod(http.MethodPost)
+	req.SetBodyString(`{"version":"1.1","host":"h","short_message":"hello","timestamp":1600000000.5,` +
+		`"_container_name":"web","_user":"u"}`)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", loggedAt(time.Unix(1600000000, 5e8)), mock.MatchedBy(func(data []byte) bool {
+		var rec map[string]interface{}
+		return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(map[string]interface{}{
+			"host": "h", "message": "hello", "container_name": "web", "user": "u",
+		}, rec)
+	}), "web").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetBodyString(`{"host":"h"}`)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+// loggedAt matches the context of a record saved with the time it was logged.
+func loggedAt(ts time.Time) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		return adapter.Timestamp(ctx).Equal(ts)
+	})
+}
+
+func compress(coding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	case "snappy":
+		w = snappy.NewBufferedWriter(&buf)
+	case "snappy-block":
+		return snappy.Encode(nil, data)
+	}
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func (a *APIUnitTestSuite) Test_DecodeBody() {
+	data := []byte(strings.Repeat(`{"message":"test"}`, 10))
+	for coding, encoding := range map[string]string{"gzip": "gzip", "deflate": "deflate", "raw-deflate": "deflate",
+		"zstd": "zstd", "snappy": "snappy", "snappy-block": "snappy"} {
+		body, err := decodeBody(encoding, compress(coding, data), 1024)
+		a.NoError(err, coding)
+		a.Equal(data, body, coding)
+
+		_, err = decodeBody(encoding, compress(coding, data), 100)
+		a.True(errors.Is(err, errBodyTooLarge), coding)
+	}
+
+	body, err := decodeBody("gzip, zstd", compress("zstd", compress("gzip", data)), 1024)
+	a.NoError(err)
+	a.Equal(data, body)
+
+	// zstd frames of raw data asking for a window of 1 KiB and 512 MiB
+	frame := func(windowLog byte) []byte {
+		return append([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, (windowLog - 10) << 3, 4<<3 | 1, 0, 0}, "test"...)
+	}
+	body, err = decodeBody("zstd", frame(10), 1024)
+	a.NoError(err)
+	a.Equal([]byte("test"), body)
+	_, err = decodeBody("zstd", frame(29), 32<<20)
+	a.True(errors.Is(err, errBodyTooLarge))
+
+	_, err = decodeBody("br", data, 1024)
+	a.True(errors.Is(err, errUnsupportedEncoding))
+	_, err = decodeBody("gzip", data, 1024)
+	a.Error(err)
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Compressed() {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.SetBody(compress("gzip", []byte(`{"message":"test"}`)))
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	a.adapter.On("SaveData", mock.Anything, mock.MatchedBy(func(data []byte) bool {
+		return strings.Contains(string(data), `"message":"test"`)
+	}), "test").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.Header.Set("Content-Encoding", "br")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusUnsupportedMediaType, resp.StatusCode())
+
+	a.api.maxBodySize = 8
+	req.Header.Set("Content-Encoding", "gzip")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Text() {
+	a.api.parsers = &parser.Registry{}
+	a.api.parsers.Set(&parser.Config{Parsers: map[string]parser.Parser{"test": &parser.LogfmtParser{}}})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/test")
+	req.Header.SetMethod(http.MethodPut)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.SetBodyString("level=info msg=started\r\n\nnot logfmt\n")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	record := func(expected map[string]interface{}) interface{} {
+		return mock.MatchedBy(func(data []byte) bool {
+			var rec map[string]interface{}
+			return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(expected, rec)
+		})
+	}
+	a.adapter.On("SaveData", mock.Anything, record(map[string]interface{}{
+		"level": "info", "msg": "started", "message": "level=info msg=started",
+	}), "test").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", mock.Anything, record(map[string]interface{}{
+		"message": "not logfmt", "@parse_error": true,
+	}), "test").Times(1).Return(nil, nil)
+	err := a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
+
+	req.SetBodyString("\n \n")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	req.SetRequestURI(a.host + "/logs/test?wait=true")
+	req.SetBodyString("line")
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (a *APIUnitTestSuite) Test_SaveData_Text_Multiline() {
+	rule, err := multiline.NewRule(`^\d{4}-`, "", 0, 0)
+	a.NoError(err)
+	p, err := parser.NewRegex(`^\S+ (?P<level>[A-Z]+) (?P<message>.*)$`)
+	a.NoError(err)
+	a.api.parsers = &parser.Registry{}
+	a.api.parsers.Set(&parser.Config{
+		Parsers:   map[string]parser.Parser{"java": p},
+		Multiline: map[string]*multiline.Rule{"java": rule},
+	})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(a.host + "/logs/java")
+	req.Header.SetMethod(http.MethodPut)
+	req.Header.Set("Content-Type", "text/plain")
+	req.SetBodyString("2020-10-10 ERROR request failed\njava.lang.IllegalStateException: closed\n" +
+		"\tat com.example.Db.query(Db.java:42)\n2020-10-10 INFO retrying\n")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	record := func(expected map[string]interface{}) interface{} {
+		return mock.MatchedBy(func(data []byte) bool {
+			var rec map[string]interface{}
+			return json.Unmarshal(data, &rec) == nil && assert.ObjectsAreEqual(expected, rec)
+		})
+	}
+	a.adapter.On("SaveData", mock.Anything, record(map[string]interface{}{
+		"level": "ERROR",
+		"message": "request failed\njava.lang.IllegalStateException: closed\n" +
+			"\tat com.example.Db.query(Db.java:42)",
+	}), "java").Times(1).Return(nil, nil)
+	a.adapter.On("SaveData", mock.Anything, record(map[string]interface{}{
+		"level": "INFO", "message": "retrying",
+	}), "java").Times(1).Return(nil, nil)
+	err = a.httpCli.Do(req, resp)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, resp.StatusCode())
+	time.Sleep(10 * time.Millisecond)
+	a.adapter.AssertExpectations(a.T())
 }
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+const (
+	identityKey   = "identity"
+	authChallenge = `Bearer realm="logdb"`
+)
+
+// authorize authenticates the client and checks that it may perform
+// operations of the scope, on the tag of the path if it has one.
+func (a *API) authorize(scope auth.Scope) atr.Middleware {
+	return func(ctx *atr.RequestCtx) error {
+		if a.auth == nil {
+			return ctx.Next()
+		}
+		id, err := a.auth.Authenticate(ctx.RequestCtx)
+		if err != nil {
+			metrics.AuthFailures.WithLabelValues(authFailureReason(err)).Inc()
+			log.Debug().Err(err).Str("remote", ctx.RemoteAddr().String()).Msg("authentication failed")
+			return ctx.ErrorResponse(err, http.StatusUnauthorized)
+		}
+		tag, hasTag := ctx.UserValue("tag").(string)
+		if !id.HasScope(scope) || hasTag && !id.Allows(tag, scope) {
+			metrics.AuthFailures.WithLabelValues(authFailureReason(auth.ErrForbidden)).Inc()
+			log.Debug().Str("identity", id.Name).Str("tag", tag).Str("scope", string(scope)).Msg("access denied")
+			return ctx.ErrorResponse(auth.ErrForbidden, http.StatusForbidden)
+		}
+		ctx.SetUserValue(identityKey, id)
+		return ctx.Next()
+	}
+}
+
+// guard returns the middlewares of an endpoint requiring the scope: the
+// client address rate limit, authorization, tenant resolution and the
+// other rate limits.
+func (a *API) guard(scope auth.Scope) []atr.Middleware {
+	return []atr.Middleware{a.limitIP, a.authorize(scope), a.tenancy, a.limit}
+}
+
+// ingestion returns the middlewares of the routes writing records, which
+// also decompress their bodies.
+func (a *API) ingestion() []atr.Middleware {
+	return append(a.guard(auth.Write), a.decompress)
+}
+
+// identity returns the authenticated client, nil when authentication is disabled.
+func identity(ctx *atr.RequestCtx) *auth.Identity {
+	id, _ := ctx.UserValue(identityKey).(*auth.Identity)
+	return id
+}
+
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrNoCredentials):
+		return "missing"
+	case errors.Is(err, auth.ErrExpired):
+		return "expired"
+	case errors.Is(err, auth.ErrForbidden):
+		return "forbidden"
+	}
+	return "invalid"
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/polyse/logdb/internal/tracing"
+	atr "github.com/savsgio/atreugo/v11"
+	"github.com/valyala/fastjson"
+	"net/http"
+	"time"
+)
+
+// esVersion is the Elasticsearch version reported to shippers checking it.
+const esVersion = "7.10.2"
+
+// Actions of the Elasticsearch bulk API.
+const (
+	bulkIndex  = "index"
+	bulkCreate = "create"
+	bulkUpdate = "update"
+	bulkDelete = "delete"
+)
+
+// bulkAction is an action of a bulk request with its source line.
+type bulkAction struct {
+	name   string
+	tag    string
+	id     string
+	source []byte
+}
+
+// bulkItem is the result of a bulk action.
+type bulkItem struct {
+	Index  string     `json:"_index"`
+	Id     string     `json:"_id,omitempty"`
+	Status int        `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  *bulkError `json:"error,omitempty"`
+}
+
+type bulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// bulkResponse is the body of a response to a bulk request, items keep the
+// order of the actions.
+type bulkResponse struct {
+	Took   int64                  `json:"took"`
+	Errors bool                   `json:"errors"`
+	Items  []map[string]*bulkItem `json:"items"`
+}
+
+// HandleBulk ingests the documents of an Elasticsearch bulk request. The
+// _index of an action is its tag, the tag of the path is the default one.
+// Only index and create actions are supported, every document is written
+// like a record of HandleNewLog.
+func (a *API) HandleBulk(ctx *atr.RequestCtx) error {
+	start := time.Now()
+	t := currentTenant(ctx)
+	sCtx, span := tracing.StartServer(context.Background(), "HandleBulk",
+		string(ctx.Request.Header.Peek(tracing.TraceparentHeader)))
+	span.SetAttribute("logdb.tenant", t.ID)
+	defer span.End()
+
+	defTag, _ := ctx.UserValue("tag").(string)
+	actions, err := parseBulk(ctx.Request.Body(), defTag)
+	if err != nil {
+		span.RecordError(err)
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	span.SetAttribute("logdb.actions", len(actions))
+	res := &bulkResponse{Items: make([]map[string]*bulkItem, 0, len(actions))}
+	for _, act := range actions {
+		item := a.bulkWrite(sCtx, ctx, t, act)
+		res.Errors = res.Errors || item.Error != nil
+		res.Items = append(res.Items, map[string]*bulkItem{act.name: item})
+	}
+	res.Took = time.Since(start).Milliseconds()
+	return ctx.JSONResponse(res, http.StatusOK)
+}
+
+func (a *API) bulkWrite(sCtx context.Context, ctx *atr.RequestCtx, t *tenant.Tenant, act *bulkAction) *bulkItem {
+	item := &bulkItem{Index: act.tag, Id: act.id}
+	fail := func(status int, err error) *bulkItem {
+		item.Status, item.Error = status, &bulkError{Type: bulkErrorType(status), Reason: err.Error()}
+		return item
+	}
+	switch {
+	case act.name != bulkIndex && act.name != bulkCreate:
+		return fail(http.StatusBadRequest, fmt.Errorf("action %s is not supported", act.name))
+	case act.tag == "":
+		return fail(http.StatusBadRequest, errors.New("_index is missing"))
+	}
+	uid, err := tagIndex(ctx, t, act.tag)
+	if err == nil {
+		err = a.ingest(ctx, sCtx, t, uid, act.source)
+	}
+	if err != nil {
+		return fail(admitStatus(t, err), err)
+	}
+	item.Status, item.Result = http.StatusCreated, "created"
+	return item
+}
+
+func bulkErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "mapper_parsing_exception"
+	case http.StatusForbidden:
+		return "security_exception"
+	case http.StatusNotFound:
+		return "index_not_found_exception"
+	case http.StatusTooManyRequests:
+		return "es_rejected_execution_exception"
+	}
+	return "unavailable_shards_exception"
+}
+
+// parseBulk reads the NDJSON action and source lines of a bulk request.
+// Delete actions have no source line.
+func parseBulk(body []byte, defTag string) ([]*bulkAction, error) {
+	var p fastjson.Parser
+	var actions []*bulkAction
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+		v, err := p.ParseBytes(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed action on line %d: %w", i+1, err)
+		}
+		o, err := v.Object()
+		if err != nil || o.Len() != 1 {
+			return nil, fmt.Errorf("malformed action on line %d: expected an object with one action", i+1)
+		}
+		act := &bulkAction{tag: defTag}
+		o.Visit(func(key []byte, meta *fastjson.Value) {
+			act.name = string(key)
+			if s := meta.GetStringBytes("_index"); s != nil {
+				act.tag = string(s)
+			}
+			act.id = string(meta.GetStringBytes("_id"))
+		})
+		if act.name != bulkDelete {
+			if i++; i >= len(lines) || len(bytes.TrimSpace(lines[i])) == 0 {
+				return nil, fmt.Errorf("source of the action on line %d is missing", i)
+			}
+			act.source = bytes.TrimSpace(lines[i])
+		}
+		actions = append(actions, act)
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("request body is empty")
+	}
+	return actions, nil
+}
+
+// esInfo is the body of the Elasticsearch root endpoint.
+type esInfo struct {
+	Name        string `json:"name"`
+	ClusterName string `json:"cluster_name"`
+	Version     struct {
+		Number string `json:"number"`
+	} `json:"version"`
+	Tagline string `json:"tagline"`
+}
+
+// HandleInfo answers like the root endpoint of Elasticsearch for shippers
+// checking the version before they send bulk requests.
+func (a *API) HandleInfo(ctx *atr.RequestCtx) error {
+	info := &esInfo{Name: "logdb", ClusterName: "logdb", Tagline: "You Know, for Search"}
+	info.Version.Number = esVersion
+	return ctx.JSONResponse(info, http.StatusOK)
+}
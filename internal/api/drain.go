@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/polyse/logdb/internal/adapter"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/spool"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// errDraining rejects writes once the adapter is shutting down.
+var errDraining = errors.New("adapter is shutting down")
+
+// writes tracks the records answered with 202 until they are written.
+type writes struct {
+	lock     sync.Mutex
+	next     uint64
+	pending  map[uint64]*pendingWrite
+	draining bool
+	// drained is set once Drain spooled the pending records.
+	drained bool
+	// flushed, spooled and dropped count the records pending while draining.
+	flushed, spooled, dropped int
+}
+
+// pendingWrite is a tracked record with the cancellation of its write.
+type pendingWrite struct {
+	rec    *spool.Record
+	cancel context.CancelFunc
+}
+
+// track registers an accepted record until done is called with the returned
+// id. It fails once the pending records are drained, the caller spools the
+// record then.
+func (a *API) track(rec *spool.Record, cancel context.CancelFunc) (uint64, bool) {
+	w := &a.writes
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.drained {
+		return 0, false
+	}
+	if w.pending == nil {
+		w.pending = make(map[uint64]*pendingWrite)
+	}
+	w.next++
+	w.pending[w.next] = &pendingWrite{rec: rec, cancel: cancel}
+	return w.next, true
+}
+
+// draining reports whether Drain was called, new writes are rejected then.
+func (a *API) draining() bool {
+	a.writes.lock.Lock()
+	defer a.writes.lock.Unlock()
+	return a.writes.draining
+}
+
+// done removes the record, it is spooled if Meilisearch was unavailable.
+func (a *API) done(id uint64, err error) {
+	w := &a.writes
+	w.lock.Lock()
+	p, ok := w.pending[id]
+	delete(w.pending, id)
+	if ok && err == nil && w.draining {
+		w.flushed++
+	}
+	w.lock.Unlock()
+	if !ok || err == nil {
+		return
+	}
+	if apperrors.Classify(err) == apperrors.Transient {
+		a.spoolRecord(p.rec)
+	} else {
+		a.countDrained(&w.dropped)
+	}
+}
+
+func (a *API) spoolRecord(rec *spool.Record) {
+	if err := a.spool.Write(rec); err != nil {
+		log.Err(err).Str("index", rec.Index).Msg("can not spool record, it is dropped")
+		a.countDrained(&a.writes.dropped)
+		return
+	}
+	a.countDrained(&a.writes.spooled)
+}
+
+func (a *API) countDrained(n *int) {
+	a.writes.lock.Lock()
+	defer a.writes.lock.Unlock()
+	if a.writes.draining {
+		*n++
+	}
+}
+
+func (a *API) pendingWrites() int {
+	a.writes.lock.Lock()
+	defer a.writes.lock.Unlock()
+	return len(a.writes.pending)
+}
+
+// Drain rejects new writes and waits until the accepted records are written
+// or the timeout passes. It cancels the writes still pending, spools their
+// records and logs what happened to them. Spooled records keep their @id, so
+// a write completing anyway is replaced by the replay instead of duplicated.
+func (a *API) Drain(timeout time.Duration) {
+	w := &a.writes
+	w.lock.Lock()
+	w.draining = true
+	pending := len(w.pending)
+	w.lock.Unlock()
+	log.Info().Int("pending", pending).Dur("timeout", timeout).Msg("draining writes")
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(drainPollInterval)
+	defer tick.Stop()
+wait:
+	for a.pendingWrites() > 0 {
+		select {
+		case <-deadline.C:
+			break wait
+		case <-tick.C:
+		}
+	}
+
+	w.lock.Lock()
+	left := w.pending
+	w.pending = nil
+	w.drained = true
+	w.lock.Unlock()
+	for _, p := range left {
+		p.cancel()
+		a.spoolRecord(p.rec)
+	}
+	if err := a.spool.Close(); err != nil {
+		log.Err(err).Msg("can not close spool")
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	log.Info().Int("pending", pending).Int("flushed", w.flushed).Int("spooled", w.spooled).
+		Int("dropped", w.dropped).Msg("writes drained")
+}
+
+// ReplaySpool saves the records spooled by earlier runs, then the records
+// spooled while running every replay interval until ctx is done. Replays
+// wait while the circuit breaker is open, and records which Meilisearch is
+// still unavailable for stay in the spool.
+func (a *API) ReplaySpool(ctx context.Context) {
+	a.replaySpool(ctx)
+	if a.replayInterval <= 0 {
+		return
+	}
+	t := time.NewTicker(a.replayInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if a.ad.Available() != nil {
+			continue
+		}
+		if a.spool.Backlog() == 0 {
+			continue
+		}
+		// the records spooled while running are in the current spool file,
+		// which is replayed once it is closed
+		if err := a.spool.Close(); err != nil {
+			log.Err(err).Msg("can not close spool")
+			continue
+		}
+		a.replaySpool(ctx)
+	}
+}
+
+func (a *API) replaySpool(ctx context.Context) {
+	replayed, failed, err := a.spool.Replay(func(rec *spool.Record) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sCtx := adapter.WithDocumentId(adapter.WithTimestamp(ctx, rec.Time()), rec.Id)
+		_, err := a.ad.SaveData(sCtx, rec.Data, rec.Index)
+		if err != nil && apperrors.Classify(err) != apperrors.Transient {
+			log.Err(err).Str("index", rec.Index).Msg("dropping spooled record")
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("can not replay spool")
+	}
+	if replayed > 0 || failed > 0 {
+		log.Info().Int("replayed", replayed).Int("failed", failed).Msg("spool replayed")
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"github.com/valyala/fasthttp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// defaultMaxBodySize limits decompressed request bodies if no limit is configured.
+	defaultMaxBodySize = 32 << 20
+	// minZstdWindow is the window every zstd decoder has to support, the
+	// limit of the zstd content coding of HTTP.
+	minZstdWindow = 8 << 20
+)
+
+var (
+	// errBodyTooLarge rejects compressed bodies larger than the limit once decompressed.
+	errBodyTooLarge = errors.New("decompressed request body is too large")
+	// errUnsupportedEncoding rejects bodies of unknown content codings.
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// snappyStreamMagic starts bodies in the snappy framing format, others are snappy blocks.
+var snappyStreamMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+// decompress replaces a body compressed with the codings of its
+// Content-Encoding header by the decompressed one, for handlers reading
+// raw bodies.
+func (a *API) decompress(ctx *atr.RequestCtx) error {
+	encoding := strings.ToLower(strings.TrimSpace(string(ctx.Request.Header.Peek(fasthttp.HeaderContentEncoding))))
+	if encoding == "" || encoding == "identity" {
+		return ctx.Next()
+	}
+	max := a.bodyLimit()
+	body := ctx.Request.Body()
+	in := len(body)
+	body, err := decodeBody(encoding, body, max)
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		return ctx.ErrorResponse(err, http.StatusUnsupportedMediaType)
+	case errors.Is(err, errBodyTooLarge):
+		log.Debug().Str("remote", ctx.RemoteAddr().String()).Str("encoding", encoding).Msg("request body too large")
+		return ctx.ErrorResponse(err, http.StatusRequestEntityTooLarge)
+	case err != nil:
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	metrics.CompressedBytes.WithLabelValues(encoding).Add(float64(in))
+	metrics.DecompressedBytes.WithLabelValues(encoding).Add(float64(len(body)))
+	ctx.Request.SetBody(body)
+	ctx.Request.Header.Del(fasthttp.HeaderContentEncoding)
+	return ctx.Next()
+}
+
+// bodyLimit returns the max size of decompressed request bodies.
+func (a *API) bodyLimit() int {
+	if a.maxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return a.maxBodySize
+}
+
+// decodeBody decodes the body with the comma separated content codings, in
+// the reverse order they were applied, up to max bytes.
+func decodeBody(encoding string, body []byte, max int) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if body, err = decode(strings.TrimSpace(codings[i]), body, max); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func decode(coding string, body []byte, max int) ([]byte, error) {
+	var r io.Reader
+	switch coding {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("malformed gzip body: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		// deflate is zlib wrapped, but some clients send raw deflate data
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			zr = flate.NewReader(bytes.NewReader(body))
+		}
+		defer zr.Close()
+		r = zr
+	case "zstd":
+		// the window is allocated up front, so frames may not ask for more than the limit
+		memory := uint64(max)
+		if memory < minZstdWindow {
+			memory = minZstdWindow
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(memory))
+		if err != nil {
+			return nil, fmt.Errorf("malformed zstd body: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case "snappy":
+		if !bytes.HasPrefix(body, snappyStreamMagic) {
+			return decodeSnappyBlock(body, max)
+		}
+		r = snappy.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, coding)
+	}
+	res, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, errBodyTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s body: %w", coding, err)
+	}
+	if len(res) > max {
+		return nil, errBodyTooLarge
+	}
+	return res, nil
+}
+
+// decodeSnappyBlock decodes a snappy block, whose header tells its decoded length.
+func decodeSnappyBlock(body []byte, max int) ([]byte, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("malformed snappy body: %w", err)
+	}
+	if n > max {
+		return nil, errBodyTooLarge
+	}
+	res, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("malformed snappy body: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/gelf"
+	"github.com/polyse/logdb/internal/tracing"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+// HandleGelf ingests a GELF message, which may be gzip or zlib compressed,
+// like the GELF HTTP input of Graylog. The value of the index field is the
+// tag of the message, its timestamp its @timestamp.
+func (a *API) HandleGelf(ctx *atr.RequestCtx) error {
+	t := currentTenant(ctx)
+	sCtx, span := tracing.StartServer(context.Background(), "HandleGelf",
+		string(ctx.Request.Header.Peek(tracing.TraceparentHeader)))
+	span.SetAttribute("logdb.tenant", t.ID)
+	defer span.End()
+
+	m, err := gelf.Decode(ctx.Request.Body(), a.gelfMaxSize)
+	if err != nil {
+		span.RecordError(err)
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	field := a.gelfField
+	if field == "" {
+		field = gelf.DefaultIndexField
+	}
+	uid, err := tagIndex(ctx, t, m.Tag(field))
+	if err != nil {
+		span.RecordError(err)
+		return admitError(ctx, t, err)
+	}
+	data, err := gelfRecord(m)
+	if err == nil {
+		err = a.ingest(ctx, adapter.WithTimestamp(sCtx, m.Timestamp), t, uid, data)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return admitError(ctx, t, err)
+	}
+	ctx.Response.SetStatusCode(http.StatusAccepted)
+	return nil
+}
+
+// gelfRecord returns the record of the message.
+func gelfRecord(m *gelf.Message) ([]byte, error) {
+	return json.Marshal(m.Fields)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+const (
+	statusUp       = "up"
+	statusDown     = "down"
+	statusReady    = "ready"
+	statusNotReady = "not ready"
+)
+
+// ReadinessCheck reports the state of a component the adapter depends on.
+// Check returns a short human readable detail and an error if the component is unusable.
+type ReadinessCheck struct {
+	Name  string
+	Check func() (string, error)
+}
+
+type componentState struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status     string                    `json:"status"`
+	Components map[string]componentState `json:"components"`
+}
+
+// HandleLiveness reports that the process is able to serve requests.
+func (a *API) HandleLiveness(ctx *atr.RequestCtx) error {
+	log.Debug().Msg("health check")
+	ctx.Response.SetStatusCode(http.StatusOK)
+	ctx.Response.AppendBodyString("OK")
+	return nil
+}
+
+// HandleReadiness runs every readiness check and responds with
+// 503 Service Unavailable if any of them fails.
+func (a *API) HandleReadiness(ctx *atr.RequestCtx) error {
+	res := &readiness{
+		Status:     statusReady,
+		Components: make(map[string]componentState),
+	}
+	for _, c := range a.readinessChecks() {
+		detail, err := c.Check()
+		state := componentState{Status: statusUp, Detail: detail}
+		if err != nil {
+			state.Status = statusDown
+			state.Error = err.Error()
+			res.Status = statusNotReady
+			log.Warn().Err(err).Str("component", c.Name).Msg("component is not ready")
+		}
+		res.Components[c.Name] = state
+	}
+	if res.Status != statusReady {
+		return ctx.JSONResponse(res, http.StatusServiceUnavailable)
+	}
+	return ctx.JSONResponse(res, http.StatusOK)
+}
+
+// HandleErrorState responds with the counters and the action of the error handler.
+func (a *API) HandleErrorState(ctx *atr.RequestCtx) error {
+	return ctx.JSONResponse(a.errs.State(), http.StatusOK)
+}
+
+func (a *API) readinessChecks() []ReadinessCheck {
+	checks := []ReadinessCheck{
+		{
+			Name: "meilisearch",
+			Check: func() (string, error) {
+				return "", a.ad.DatabaseHealthCheck()
+			},
+		},
+		{
+			Name: "index_cache",
+			Check: func() (string, error) {
+				size, err := a.ad.IndexCache()
+				if err != nil {
+					return "not loaded", err
+				}
+				return fmt.Sprintf("%d indexes", size), nil
+			},
+		},
+		{
+			Name: "writes",
+			Check: func() (string, error) {
+				return fmt.Sprintf("%d pending, %d spooled", a.pendingWrites(), a.spool.Backlog()), nil
+			},
+		},
+	}
+	return append(checks, a.checks...)
+}
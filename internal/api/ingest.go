@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/breaker"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/input"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/polyse/logdb/internal/spool"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// errUnavailable rejects writes when no database connection is free and the database does not answer.
+	errUnavailable = errors.New("database is unavailable")
+	// errEmptyTag rejects records whose tag named in the body has no letters or digits.
+	errEmptyTag = errors.New("tag has no letters or digits")
+)
+
+// admit checks that the record may be written to the index of the tenant
+// now and takes a database connection, which release returns.
+func (a *API) admit(t *tenant.Tenant, uid string, data []byte) error {
+	if err := a.accept(t, uid, data); err != nil {
+		return err
+	}
+	return a.acquire()
+}
+
+// accept checks that the record may be written to the index of the tenant
+// and takes its size from the quota of the tenant.
+func (a *API) accept(t *tenant.Tenant, uid string, data []byte) error {
+	if a.draining() {
+		return errDraining
+	}
+	if err := adapter.Validate(data, a.maxSize); err != nil {
+		return err
+	}
+	if err := a.errs.Shed(); err != nil {
+		return err
+	}
+	if err := a.ad.Available(); err != nil {
+		return err
+	}
+	if err := a.checkIndexQuota(t, uid); err != nil {
+		return err
+	}
+	return a.tenants.Consume(t, int64(len(data)))
+}
+
+// acquire takes a free database connection, which release returns.
+func (a *API) acquire() error {
+	select {
+	case a.conCh <- struct{}{}:
+		metrics.DbConnections.Inc()
+		log.Debug().Msg("try to write")
+		return nil
+	default:
+	}
+	if err := a.ad.DatabaseHealthCheck(); err != nil {
+		log.Warn().Msg("database is unavailable")
+		a.errCh <- apperrors.Wrap(apperrors.Transient, err)
+		return fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	// a busy database is not an error of the adapter, clients retry later
+	metrics.RateLimited.WithLabelValues("db_connections").Inc()
+	return errBusy
+}
+
+// release returns the database connection taken by admit.
+func (a *API) release() {
+	<-a.conCh
+	metrics.DbConnections.Dec()
+}
+
+// saveAsync writes an admitted record in the background, it is spooled if
+// Meilisearch is unavailable. The timestamp of sCtx, or the time the record
+// is accepted, is kept with the spooled record.
+func (a *API) saveAsync(sCtx context.Context, uid string, data []byte) {
+	metrics.IngestedBytes.WithLabelValues(uid).Add(float64(len(data)))
+	// request bodies are reused by fasthttp once the handler returns, and the
+	// tracked copy is spooled while SaveData may still change its own
+	data = append([]byte(nil), data...)
+	ts := adapter.Timestamp(sCtx)
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	rec := &spool.Record{Index: uid, Data: append([]byte(nil), data...), Id: uuid.New().String(), Timestamp: ts.UnixNano()}
+	sCtx, cancel := context.WithCancel(adapter.WithDocumentId(sCtx, rec.Id))
+	id, ok := a.track(rec, cancel)
+	if !ok {
+		// accepted while the pending records were drained
+		cancel()
+		a.spoolRecord(rec)
+		return
+	}
+	go func(data []byte, uid string) {
+		defer cancel()
+		_, err := a.ad.SaveData(sCtx, data, uid)
+		a.done(id, err)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			a.errCh <- err
+		}
+	}(data, uid)
+}
+
+// tagIndex returns the index of a tag named in the body of a request, which
+// unlike the tag of the path is not checked by the middlewares. Characters
+// not allowed in index names are replaced like the tags of listeners, so
+// shippers naming indexes filebeat-7.10.2 or ns/app are accepted.
+func tagIndex(ctx *atr.RequestCtx, t *tenant.Tenant, tag string) (string, error) {
+	// checked first, tags naming the index of another tenant are rejected
+	if strings.Contains(tag, tenant.Separator) {
+		return "", tenant.ErrInvalidTag
+	}
+	if tag = input.Tag(tag, ""); tag == "" {
+		return "", errEmptyTag
+	}
+	if id := identity(ctx); id != nil && !id.Allows(tag, auth.Write) {
+		return "", fmt.Errorf("%w: tag %s", auth.ErrForbidden, tag)
+	}
+	return t.Index(tag), nil
+}
+
+// ingest writes the record to the index of the tenant in the background
+// once admit accepts it. Records of other indexes than the one of the path,
+// which limit took a token of, take a token of the rate limit of their tag.
+func (a *API) ingest(ctx *atr.RequestCtx, sCtx context.Context, t *tenant.Tenant, uid string, data []byte) error {
+	if uid != index(ctx) {
+		if err := a.allow(ctx, ratelimit.Key{Kind: ratelimit.Tag, Name: uid}); err != nil {
+			return err
+		}
+	}
+	if err := a.admit(t, uid, data); err != nil {
+		return err
+	}
+	a.saveAsync(sCtx, uid, data)
+	a.release()
+	return nil
+}
+
+// rejections counts the records of a request which were rejected.
+type rejections struct {
+	count int
+	first error
+}
+
+func (r *rejections) add(n int, err error) {
+	if r.count == 0 {
+		r.first = err
+	}
+	r.count += n
+}
+
+// err describes the rejections with the first error, nil if there were none.
+func (r *rejections) err() error {
+	if r.count == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d records rejected: %w", r.count, r.first)
+}
+
+// retryable reports whether clients should retry a request rejected with the status.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// admitStatus returns the response status of a record admit rejected and
+// counts the rejection.
+func admitStatus(t *tenant.Tenant, err error) int {
+	var vErr *adapter.ValidationError
+	var qErr *tenant.QuotaError
+	var lErr *ratelimit.LimitError
+	switch {
+	case errors.As(err, &vErr):
+		metrics.RejectedRecords.WithLabelValues(vErr.Reason).Inc()
+		return http.StatusBadRequest
+	case errors.As(err, &qErr):
+		metrics.QuotaRejections.WithLabelValues(t.ID, qErr.Quota).Inc()
+		log.Debug().Str("tenant", t.ID).Str("quota", qErr.Quota).Msg("quota exceeded")
+		if qErr.RetryAfter > 0 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusForbidden
+	case errors.As(err, &lErr):
+		return http.StatusTooManyRequests
+	case errors.Is(err, tenant.ErrInvalidTag), errors.Is(err, errEmptyTag):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errUnavailable):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errBusy), errors.Is(err, errDraining), errors.Is(err, apperrors.ErrShedding), errors.Is(err, breaker.ErrOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, adapter.ErrNotFound):
+		return http.StatusNotFound
+	}
+	log.Err(err).Msg("can not admit record")
+	return http.StatusBadGateway
+}
+
+// admitError responds to a record admit rejected.
+func admitError(ctx *atr.RequestCtx, t *tenant.Tenant, err error) error {
+	status := admitStatus(t, err)
+	var vErr *adapter.ValidationError
+	if errors.As(err, &vErr) {
+		log.Debug().Err(err).Str("remote", ctx.RemoteAddr().String()).Msg("record rejected")
+		return ctx.JSONResponse(&validationResponse{
+			Error:  adapter.ErrInvalidRecord.Error(),
+			Reason: vErr.Reason,
+			Detail: vErr.Detail,
+		}, status)
+	}
+	return ctx.ErrorResponse(err, status)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/input"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/multiline"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// inputFlushTimeout is the time joined events wait for a free database connection.
+const inputFlushTimeout = 10 * time.Second
+
+// Ingest writes a record received by a listener of another protocol than
+// HTTP to the index of the tag of the default tenant, ts is the time it was
+// logged if known. Listeners have no clients to retry, so it waits for a
+// free database connection until ctx is done. With a multiline rule for the
+// tag, the messages of a stream are joined into one record which is written
+// once the event is complete.
+func (a *API) Ingest(ctx context.Context, in, tag, stream string, doc map[string]interface{}, ts time.Time) error {
+	if msg, ok := doc[parser.MessageKey].(string); ok && a.lines != nil {
+		if rule := a.parsers.Multiline(tag); rule != nil {
+			a.lines.Add(in+"\x00"+tag+"\x00"+stream, rule, msg, &inputEvent{in: in, tag: tag, doc: doc, ts: ts})
+			return nil
+		}
+	}
+	return a.ingestInputRecord(ctx, in, tag, doc, ts)
+}
+
+// inputEvent is the first record of a multiline event of a listener.
+type inputEvent struct {
+	in, tag string
+	doc     map[string]interface{}
+	ts      time.Time
+}
+
+// flushInput writes the record of a multiline event of a listener, the
+// fields of its first record with the joined messages.
+func (a *API) flushInput(e *multiline.Event) {
+	ev := e.Data.(*inputEvent)
+	ev.doc[parser.MessageKey] = e.Message()
+	ctx, cancel := context.WithTimeout(context.Background(), inputFlushTimeout)
+	defer cancel()
+	if err := a.ingestInputRecord(ctx, ev.in, ev.tag, ev.doc, ev.ts); err != nil {
+		log.Warn().Err(err).Str("input", ev.in).Str("tag", ev.tag).Msg("multiline event rejected")
+	}
+}
+
+func (a *API) ingestInputRecord(ctx context.Context, in, tag string, doc map[string]interface{}, ts time.Time) error {
+	err := a.ingestInput(ctx, tag, doc, ts)
+	result := input.Accepted
+	if err != nil {
+		result = input.Rejected
+	}
+	metrics.InputRecords.WithLabelValues(in, result).Inc()
+	return err
+}
+
+func (a *API) ingestInput(ctx context.Context, tag string, doc map[string]interface{}, ts time.Time) error {
+	t, err := a.tenants.Lookup("")
+	if err != nil {
+		return err
+	}
+	if err = tenant.ValidateTag(tag); err != nil {
+		return err
+	}
+	uid := t.Index(tag)
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err = a.accept(t, uid, data); err != nil {
+		// counts the rejection like the one of a request
+		admitStatus(t, err)
+		return err
+	}
+	select {
+	case a.conCh <- struct{}{}:
+		metrics.DbConnections.Inc()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// the record is written even if the listener stops meanwhile
+	a.saveAsync(adapter.WithTimestamp(context.Background(), ts), uid, data)
+	a.release()
+	return nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/loki"
+	"github.com/polyse/logdb/internal/tracing"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+const (
+	// defaultLokiLabel is the stream label choosing the tag of the entries.
+	defaultLokiLabel = "job"
+	// defaultLokiTag is the tag of streams without the label.
+	defaultLokiTag = "loki"
+	// lokiMessageKey is the field holding the line of an entry.
+	lokiMessageKey = "message"
+)
+
+// HandleLokiPush ingests the entries of a Loki push request. The stream
+// labels and the structured metadata of an entry become fields of its
+// record, the line its message and the timestamp its @timestamp, saved
+// with nanoseconds. The value
+// of the index label is the tag of a stream. Rejected entries are skipped
+// and reported with 400 once the others are written, a rejection clients
+// should retry stops the request.
+func (a *API) HandleLokiPush(ctx *atr.RequestCtx) error {
+	t := currentTenant(ctx)
+	sCtx, span := tracing.StartServer(context.Background(), "HandleLokiPush",
+		string(ctx.Request.Header.Peek(tracing.TraceparentHeader)))
+	span.SetAttribute("logdb.tenant", t.ID)
+	defer span.End()
+
+	contentType := string(ctx.Request.Header.ContentType())
+	body := ctx.Request.Body()
+	if !loki.IsJSON(contentType) {
+		// protobuf requests are snappy blocks, limited like compressed bodies
+		var err error
+		if body, err = decodeSnappyBlock(body, a.bodyLimit()); err != nil {
+			span.RecordError(err)
+			status := http.StatusBadRequest
+			if errors.Is(err, errBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			return ctx.ErrorResponse(err, status)
+		}
+	}
+	streams, err := loki.Decode(body, contentType)
+	if err != nil {
+		span.RecordError(err)
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	var rejected rejections
+	for _, st := range streams {
+		uid, err := tagIndex(ctx, t, a.lokiTag(st.Labels))
+		if err != nil {
+			rejected.add(len(st.Entries), err)
+			continue
+		}
+		for _, e := range st.Entries {
+			data, err := lokiRecord(st.Labels, &e)
+			if err == nil {
+				err = a.ingest(ctx, adapter.WithTimestamp(sCtx, e.Timestamp), t, uid, data)
+			}
+			if err == nil {
+				continue
+			}
+			span.RecordError(err)
+			if status := admitStatus(t, err); retryable(status) {
+				return ctx.ErrorResponse(err, status)
+			}
+			rejected.add(1, err)
+		}
+	}
+	if err := rejected.err(); err != nil {
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	ctx.Response.SetStatusCode(http.StatusNoContent)
+	return nil
+}
+
+// lokiTag returns the tag of the stream with the labels.
+func (a *API) lokiTag(labels map[string]string) string {
+	label := a.lokiLabel
+	if label == "" {
+		label = defaultLokiLabel
+	}
+	if tag := labels[label]; tag != "" {
+		return tag
+	}
+	return defaultLokiTag
+}
+
+// lokiRecord returns the record of an entry of a stream with the labels.
+func lokiRecord(labels map[string]string, e *loki.Entry) ([]byte, error) {
+	rec := make(map[string]interface{}, len(labels)+len(e.Metadata)+2)
+	for k, v := range labels {
+		rec[k] = v
+	}
+	for k, v := range e.Metadata {
+		rec[k] = v
+	}
+	rec[lokiMessageKey] = e.Line
+	return json.Marshal(rec)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/polyse/logdb/internal/adapter"
+	"github.com/polyse/logdb/internal/otlp"
+	"github.com/polyse/logdb/internal/tracing"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+const (
+	// defaultOtlpAttribute is the resource attribute choosing the tag of log records.
+	defaultOtlpAttribute = "service.name"
+	// defaultOtlpTag is the tag of resources without the attribute.
+	defaultOtlpTag = "otlp"
+)
+
+// HandleOtlpLogs ingests the log records of an OTLP/HTTP export request in
+// the protobuf or the JSON encoding. Every log record is flattened with its
+// resource and scope into a record, the value of the index attribute of
+// the resource is its tag. Rejected records are reported as a partial
+// success, a rejection clients should retry stops the request.
+func (a *API) HandleOtlpLogs(ctx *atr.RequestCtx) error {
+	t := currentTenant(ctx)
+	sCtx, span := tracing.StartServer(context.Background(), "HandleOtlpLogs",
+		string(ctx.Request.Header.Peek(tracing.TraceparentHeader)))
+	span.SetAttribute("logdb.tenant", t.ID)
+	defer span.End()
+
+	contentType := string(ctx.Request.Header.ContentType())
+	logs, err := otlp.Decode(ctx.Request.Body(), contentType)
+	if err != nil {
+		span.RecordError(err)
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	var rejected rejections
+	for _, l := range logs {
+		uid, err := tagIndex(ctx, t, a.otlpTag(l))
+		if err != nil {
+			rejected.add(1, err)
+			continue
+		}
+		data, err := otlpRecord(l)
+		if err == nil {
+			err = a.ingest(ctx, adapter.WithTimestamp(sCtx, l.Timestamp()), t, uid, data)
+		}
+		if err == nil {
+			continue
+		}
+		span.RecordError(err)
+		if status := admitStatus(t, err); retryable(status) {
+			return ctx.ErrorResponse(err, status)
+		}
+		rejected.add(1, err)
+	}
+	msg := ""
+	if err := rejected.err(); err != nil {
+		msg = err.Error()
+	}
+	if otlp.IsJSON(contentType) {
+		ctx.Response.Header.SetContentType(otlp.ContentTypeJSON)
+	} else {
+		ctx.Response.Header.SetContentType(otlp.ContentTypeProtobuf)
+	}
+	ctx.Response.SetBody(otlp.Response(contentType, int64(rejected.count), msg))
+	ctx.Response.SetStatusCode(http.StatusOK)
+	return nil
+}
+
+// otlpTag returns the tag of the log record.
+func (a *API) otlpTag(l *otlp.Log) string {
+	attr := a.otlpAttr
+	if attr == "" {
+		attr = defaultOtlpAttribute
+	}
+	if v, ok := l.Resource[attr]; ok && v != nil {
+		if tag := fmt.Sprint(v); tag != "" {
+			return tag
+		}
+	}
+	return defaultOtlpTag
+}
+
+// otlpRecord returns the record of a log record.
+func otlpRecord(l *otlp.Log) ([]byte, error) {
+	return json.Marshal(l.Document())
+}
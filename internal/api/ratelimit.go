@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/ratelimit"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+// limitIP applies the rate limit of the client address. It runs before
+// authorize, so requests failing authentication are limited too.
+func (a *API) limitIP(ctx *atr.RequestCtx) error {
+	if err := a.allow(ctx, ratelimit.Key{Kind: ratelimit.IP, Name: ctx.RemoteIP().String()}); err != nil {
+		return ctx.ErrorResponse(err, http.StatusTooManyRequests)
+	}
+	return ctx.Next()
+}
+
+// limit applies the rate limits of the credential and the index of the
+// request. It runs after tenancy.
+func (a *API) limit(ctx *atr.RequestCtx) error {
+	var keys []ratelimit.Key
+	if id := identity(ctx); id != nil {
+		keys = append(keys, ratelimit.Key{Kind: ratelimit.Credential, Name: id.Name})
+	}
+	if _, ok := ctx.UserValue("tag").(string); ok {
+		keys = append(keys, ratelimit.Key{Kind: ratelimit.Tag, Name: index(ctx)})
+	}
+	if err := a.allow(ctx, keys...); err != nil {
+		return ctx.ErrorResponse(err, http.StatusTooManyRequests)
+	}
+	return ctx.Next()
+}
+
+// allow takes a token for every key, it returns the LimitError of an
+// exhausted limit.
+func (a *API) allow(ctx *atr.RequestCtx, keys ...ratelimit.Key) error {
+	err := a.limiter.Allow(keys...)
+	var lErr *ratelimit.LimitError
+	if !errors.As(err, &lErr) {
+		return nil
+	}
+	metrics.RateLimited.WithLabelValues(string(lErr.Kind)).Inc()
+	log.Debug().Str("remote", ctx.RemoteAddr().String()).Str("limit", string(lErr.Kind)).Msg("rate limited")
+	return err
+}
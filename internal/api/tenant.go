@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"github.com/polyse/logdb/internal/auth"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/tenant"
+	"github.com/rs/zerolog/log"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+const (
+	tenantKey = "tenant"
+	indexKey  = "index"
+)
+
+// tenancy resolves the tenant of the request, from the credential if it is
+// bound to one or from the tenant header, and maps the tag of the path to
+// the index of the tenant. With authentication enabled, only admin
+// credentials may choose a tenant with the header. It runs after authorize.
+func (a *API) tenancy(ctx *atr.RequestCtx) error {
+	name := string(ctx.Request.Header.Peek(tenant.Header))
+	if id := identity(ctx); id != nil && name != "" {
+		if id.Tenant != "" && name != id.Tenant || id.Tenant == "" && !id.HasScope(auth.Admin) {
+			metrics.AuthFailures.WithLabelValues(authFailureReason(auth.ErrForbidden)).Inc()
+			log.Debug().Str("identity", id.Name).Str("tenant", name).Msg("tenant denied")
+			return ctx.ErrorResponse(auth.ErrForbidden, http.StatusForbidden)
+		}
+	}
+	if id := identity(ctx); id != nil && id.Tenant != "" {
+		name = id.Tenant
+	}
+	t, err := a.tenants.Lookup(name)
+	if errors.Is(err, tenant.ErrUnknownTenant) {
+		return ctx.ErrorResponse(err, http.StatusForbidden)
+	} else if err != nil {
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	if tag, ok := ctx.UserValue("tag").(string); ok {
+		if err = tenant.ValidateTag(tag); err != nil {
+			return ctx.ErrorResponse(err, http.StatusBadRequest)
+		}
+		ctx.SetUserValue(indexKey, t.Index(tag))
+	}
+	ctx.SetUserValue(tenantKey, t)
+	return ctx.Next()
+}
+
+// currentTenant returns the tenant resolved by tenancy.
+func currentTenant(ctx *atr.RequestCtx) *tenant.Tenant {
+	if t, ok := ctx.UserValue(tenantKey).(*tenant.Tenant); ok {
+		return t
+	}
+	return &tenant.Tenant{}
+}
+
+// index returns the uid of the index of the tag in the path.
+func index(ctx *atr.RequestCtx) string {
+	if uid, ok := ctx.UserValue(indexKey).(string); ok {
+		return uid
+	}
+	uid, _ := ctx.UserValue("tag").(string)
+	return uid
+}
+
+// checkIndexQuota fails if the index does not exist yet and the tenant
+// already has as many indexes as it may have.
+func (a *API) checkIndexQuota(t *tenant.Tenant, uid string) error {
+	if t.MaxIndexes == 0 || a.ad.HasIndex(uid) {
+		return nil
+	}
+	indexes, err := a.ad.Indexes()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, ind := range indexes {
+		if ind.UID == uid {
+			return nil
+		}
+		if _, ok := t.Tag(ind.UID); ok {
+			n++
+		}
+	}
+	if n >= t.MaxIndexes {
+		return &tenant.QuotaError{Quota: "indexes"}
+	}
+	return nil
+}
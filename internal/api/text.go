@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/polyse/logdb/internal/multiline"
+	"github.com/polyse/logdb/internal/parser"
+	"github.com/polyse/logdb/internal/tenant"
+	atr "github.com/savsgio/atreugo/v11"
+	"net/http"
+)
+
+// textContentType selects the plain text mode of HandleNewLog.
+const textContentType = "text/plain"
+
+// isText reports whether the body of the request is plain text.
+func isText(ctx *atr.RequestCtx) bool {
+	return bytes.HasPrefix(bytes.ToLower(ctx.Request.Header.ContentType()), []byte(textContentType))
+}
+
+// handleText writes every line of a plain text body as a record, parsed by
+// the parser of the tag. With a multiline rule for the tag, the lines of an
+// event are joined into one record first, events end with the body. Lines
+// the parser can not read are kept in message with the @parse_error flag.
+// Rejected records are skipped and reported with 400 once the others are
+// written, a rejection clients should retry stops the request.
+func (a *API) handleText(ctx *atr.RequestCtx, sCtx context.Context, t *tenant.Tenant, tag, uid string) error {
+	lines := textLines(ctx.Request.Body())
+	if len(lines) == 0 {
+		return ctx.ErrorResponse(errors.New("request body is empty"), http.StatusBadRequest)
+	}
+	var events [][]string
+	if rule := a.parsers.Multiline(tag); rule != nil {
+		events = multiline.Join(rule, lines)
+	} else {
+		for _, line := range lines {
+			events = append(events, []string{line})
+		}
+	}
+	p := a.parsers.Lookup(tag)
+	var rejected rejections
+	for _, lines := range events {
+		rec := parser.Event(p, lines)
+		if _, ok := rec[parser.ErrorKey]; ok {
+			metrics.ParseErrors.WithLabelValues(uid).Inc()
+		}
+		data, err := json.Marshal(rec)
+		if err == nil {
+			err = a.ingest(ctx, sCtx, t, uid, data)
+		}
+		if err == nil {
+			continue
+		}
+		if status := admitStatus(t, err); retryable(status) {
+			return ctx.ErrorResponse(err, status)
+		}
+		rejected.add(1, err)
+	}
+	if err := rejected.err(); err != nil {
+		return ctx.ErrorResponse(err, http.StatusBadRequest)
+	}
+	ctx.Response.SetStatusCode(http.StatusAccepted)
+	return nil
+}
+
+// textLines returns the lines of the body which are not blank.
+func textLines(body []byte) []string {
+	var lines []string
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, string(line))
+		}
+	}
+	return lines
+}
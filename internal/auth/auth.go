@@ -0,0 +1,273 @@
+// Package auth authenticates API clients with static API keys, HMAC signed
+// bearer tokens or TLS client certificates and authorizes them per tag and scope.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is an operation class a credential is allowed to perform.
+type Scope string
+
+const (
+	Read  Scope = "read"
+	Write Scope = "write"
+	// Admin manages the indexes of every tenant.
+	Admin Scope = "admin"
+)
+
+const (
+	// KeyHeader carries a static API key, Authorization: Bearer is accepted as well.
+	KeyHeader    = "X-API-Key"
+	bearerPrefix = "Bearer "
+)
+
+var (
+	ErrNoCredentials      = errors.New("credentials required")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrExpired            = errors.New("token expired")
+	ErrForbidden          = errors.New("access denied")
+)
+
+// Authenticator identifies the client of a request. It returns a nil
+// identity without error when authentication is disabled.
+type Authenticator interface {
+	Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error)
+}
+
+// Identity is an authenticated client.
+type Identity struct {
+	Name   string
+	Tags   []string
+	Scopes []Scope
+	// Tenant is the tenant the identity is bound to, empty if it may choose one.
+	Tenant string
+	// Parent further restricts the identity, a token can not be
+	// granted more than the key which signed it.
+	Parent *Identity
+}
+
+// HasScope reports whether the identity may perform operations of the scope.
+func (i *Identity) HasScope(scope Scope) bool {
+	if i == nil {
+		return true
+	}
+	found := false
+	for _, s := range i.Scopes {
+		if s == scope {
+			found = true
+			break
+		}
+	}
+	return found && i.Parent.HasScope(scope)
+}
+
+// Allows reports whether the identity may perform operations of the scope on the tag.
+// Tags are matched with path.Match patterns.
+func (i *Identity) Allows(tag string, scope Scope) bool {
+	if i == nil {
+		return true
+	}
+	if !i.HasScope(scope) {
+		return false
+	}
+	for _, p := range i.Tags {
+		if ok, _ := path.Match(p, tag); ok {
+			return i.Parent.Allows(tag, scope)
+		}
+	}
+	return false
+}
+
+// Credential is an entry of the credentials file. It has either a static
+// Key, a Secret verifying bearer tokens whose kid is the credential name or
+// a Subject pattern matched against verified TLS client certificates.
+// Tags of a credential with a Tenant are the tags of that tenant.
+type Credential struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key"`
+	Secret  string   `json:"secret"`
+	Subject string   `json:"subject"`
+	Tenant  string   `json:"tenant"`
+	Tags    []string `json:"tags"`
+	Scopes  []Scope  `json:"scopes"`
+}
+
+// LoadCredentials reads and validates credentials from a JSON file.
+func LoadCredentials(file string) ([]*Credential, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var creds []*Credential
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{}, len(creds))
+	for _, c := range creds {
+		if err = c.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, fmt.Errorf("credential %s: duplicate name", c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return creds, nil
+}
+
+func (c *Credential) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("credential without name")
+	}
+	kinds := 0
+	for _, v := range []string{c.Key, c.Secret, c.Subject} {
+		if v != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("credential %s: exactly one of key, secret and subject expected", c.Name)
+	}
+	if _, err := path.Match(c.Subject, ""); err != nil {
+		return fmt.Errorf("credential %s: %w", c.Name, err)
+	}
+	if len(c.Tags) == 0 {
+		return fmt.Errorf("credential %s: no tags", c.Name)
+	}
+	for _, t := range c.Tags {
+		if _, err := path.Match(t, ""); err != nil {
+			return fmt.Errorf("credential %s: %w", c.Name, err)
+		}
+	}
+	if len(c.Scopes) == 0 {
+		return fmt.Errorf("credential %s: no scopes", c.Name)
+	}
+	for _, s := range c.Scopes {
+		if s != Read && s != Write && s != Admin {
+			return fmt.Errorf("credential %s: unknown scope %s", c.Name, s)
+		}
+	}
+	return nil
+}
+
+func (c *Credential) identity() *Identity {
+	return &Identity{Name: c.Name, Tags: c.Tags, Scopes: c.Scopes, Tenant: c.Tenant}
+}
+
+// Store authenticates requests with the credentials of a file.
+// Without a file every request is allowed.
+type Store struct {
+	lock     sync.RWMutex
+	enabled  bool
+	keys     map[[sha256.Size]byte]*Identity
+	secrets  map[string]*secret
+	subjects []*subject
+	now      func() time.Time
+}
+
+type subject struct {
+	pattern string
+	id      *Identity
+}
+
+type secret struct {
+	key []byte
+	id  *Identity
+}
+
+// Set replaces the credentials, authentication is disabled unless enabled is set.
+func (s *Store) Set(creds []*Credential, enabled bool) {
+	keys := make(map[[sha256.Size]byte]*Identity)
+	secrets := make(map[string]*secret)
+	var subjects []*subject
+	for _, c := range creds {
+		switch {
+		case c.Key != "":
+			keys[sha256.Sum256([]byte(c.Key))] = c.identity()
+		case c.Secret != "":
+			secrets[c.Name] = &secret{key: []byte(c.Secret), id: c.identity()}
+		default:
+			subjects = append(subjects, &subject{pattern: c.Subject, id: c.identity()})
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.enabled = enabled
+	s.keys = keys
+	s.secrets = secrets
+	s.subjects = subjects
+	log.Debug().Bool("enabled", enabled).Int("keys", len(keys)).Int("secrets", len(secrets)).
+		Int("subjects", len(subjects)).Msg("credentials loaded")
+}
+
+// NewStore loads the credentials file, an empty file name disables authentication.
+func NewStore(file string) (*Store, error) {
+	s := &Store{now: time.Now}
+	if err := s.Reload(file); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the credentials with the ones of the file. They are
+// left unchanged if the file is invalid, so keys can be rotated in place.
+func (s *Store) Reload(file string) error {
+	var creds []*Credential
+	if file != "" {
+		var err error
+		if creds, err = LoadCredentials(file); err != nil {
+			return err
+		}
+	}
+	s.Set(creds, file != "")
+	return nil
+}
+
+func (s *Store) Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if !s.enabled {
+		return nil, nil
+	}
+	cred := string(ctx.Request.Header.Peek(KeyHeader))
+	if h := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)); cred == "" && strings.HasPrefix(h, bearerPrefix) {
+		cred = strings.TrimSpace(h[len(bearerPrefix):])
+	}
+	if cred == "" {
+		if tlsState := ctx.TLSConnectionState(); tlsState != nil && len(tlsState.VerifiedChains) > 0 {
+			return s.verifyCert(tlsState.VerifiedChains[0][0])
+		}
+		return nil, ErrNoCredentials
+	}
+	if strings.Count(cred, ".") == 2 {
+		return s.verifyToken(cred)
+	}
+	if id, ok := s.keys[sha256.Sum256([]byte(cred))]; ok {
+		return id, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// verifyCert returns the identity of the first credential whose subject
+// pattern matches the subject of a certificate verified by the TLS handshake.
+func (s *Store) verifyCert(cert *x509.Certificate) (*Identity, error) {
+	dn := cert.Subject.String()
+	for _, sub := range s.subjects {
+		if ok, _ := path.Match(sub.pattern, dn); ok {
+			return sub.id, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
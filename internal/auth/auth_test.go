@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/suite"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type AuthUnitTestSuite struct {
+	suite.Suite
+	store *Store
+	now   time.Time
+}
+
+func (s *AuthUnitTestSuite) SetupTest() {
+	s.now = time.Unix(1000, 0)
+	s.store = &Store{now: func() time.Time {
+		return s.now
+	}}
+	s.store.Set([]*Credential{
+		{Name: "fluentd", Key: "static-key", Tags: []string{"app-*"}, Scopes: []Scope{Write}},
+		{Name: "ci", Secret: "hmac-secret", Tenant: "team-a", Tags: []string{"app-*", "ci"}, Scopes: []Scope{Read, Write}},
+	}, true)
+}
+
+func TestRunAuthUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthUnitTestSuite))
+}
+
+func (s *AuthUnitTestSuite) authenticate(header, value string) (*Identity, error) {
+	ctx := &fasthttp.RequestCtx{}
+	if header != "" {
+		ctx.Request.Header.Set(header, value)
+	}
+	return s.store.Authenticate(ctx)
+}
+
+func (s *AuthUnitTestSuite) token(kid, secret string, claims *Claims) string {
+	token, err := NewToken(kid, []byte(secret), claims)
+	s.NoError(err)
+	return "Bearer " + token
+}
+
+func (s *AuthUnitTestSuite) Test_Static_Key() {
+	for _, h := range [][2]string{{KeyHeader, "static-key"}, {"Authorization", "Bearer static-key"}} {
+		id, err := s.authenticate(h[0], h[1])
+		s.NoError(err)
+		s.Equal("fluentd", id.Name)
+		s.True(id.Allows("app-1", Write))
+		s.False(id.Allows("app-1", Read))
+		s.False(id.Allows("other", Write))
+	}
+
+	_, err := s.authenticate(KeyHeader, "wrong-key")
+	s.Equal(ErrInvalidCredentials, err)
+	_, err = s.authenticate("", "")
+	s.Equal(ErrNoCredentials, err)
+}
+
+func (s *AuthUnitTestSuite) Test_Token() {
+	id, err := s.authenticate("Authorization", s.token("ci", "hmac-secret", &Claims{
+		Subject:   "build",
+		ExpiresAt: s.now.Add(time.Hour).Unix(),
+	}))
+	s.NoError(err)
+	s.Equal("ci/build", id.Name)
+	s.Equal("team-a", id.Tenant, "tokens are bound to the tenant of the credential")
+	s.True(id.Allows("ci", Read))
+	s.True(id.Allows("app-1", Write))
+	s.False(id.Allows("other", Read))
+}
+
+func (s *AuthUnitTestSuite) Test_Token_Narrows_Credential() {
+	id, err := s.authenticate("Authorization", s.token("ci", "hmac-secret", &Claims{
+		Tags:   []string{"app-1", "other"},
+		Scopes: []Scope{Read},
+	}))
+	s.NoError(err)
+	s.True(id.Allows("app-1", Read))
+	s.False(id.Allows("app-1", Write), "scope not granted by the token")
+	s.False(id.Allows("app-2", Read), "tag not granted by the token")
+	s.False(id.Allows("other", Read), "tag not granted by the credential")
+}
+
+func (s *AuthUnitTestSuite) Test_Invalid_Tokens() {
+	_, err := s.authenticate("Authorization", s.token("ci", "hmac-secret", &Claims{
+		ExpiresAt: s.now.Unix(),
+	}))
+	s.Equal(ErrExpired, err)
+
+	_, err = s.authenticate("Authorization", s.token("ci", "hmac-secret", &Claims{
+		NotBefore: s.now.Add(time.Minute).Unix(),
+	}))
+	s.Equal(ErrInvalidCredentials, err)
+
+	_, err = s.authenticate("Authorization", s.token("ci", "other-secret", &Claims{}))
+	s.Equal(ErrInvalidCredentials, err)
+
+	_, err = s.authenticate("Authorization", s.token("fluentd", "static-key", &Claims{}))
+	s.Equal(ErrInvalidCredentials, err, "static keys do not sign tokens")
+
+	_, err = s.authenticate("Authorization", "Bearer a.b.c")
+	s.Equal(ErrInvalidCredentials, err)
+}
+
+func (s *AuthUnitTestSuite) Test_Client_Certificate() {
+	s.store.Set([]*Credential{
+		{Name: "cluster-a", Subject: "CN=fluentd-*,O=cluster-a", Tags: []string{"a-*"}, Scopes: []Scope{Write}},
+	}, true)
+
+	id, err := s.store.verifyCert(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "fluentd-1", Organization: []string{"cluster-a"}},
+	})
+	s.NoError(err)
+	s.Equal("cluster-a", id.Name)
+	s.True(id.Allows("a-app", Write))
+	s.False(id.Allows("b-app", Write))
+
+	_, err = s.store.verifyCert(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "fluentd-1", Organization: []string{"cluster-b"}},
+	})
+	s.Equal(ErrInvalidCredentials, err)
+}
+
+func (s *AuthUnitTestSuite) Test_Disabled() {
+	s.store.Set(nil, false)
+	id, err := s.authenticate("", "")
+	s.NoError(err)
+	s.Nil(id)
+	s.True(id.Allows("any", Write))
+}
+
+func (s *AuthUnitTestSuite) Test_Reload() {
+	f, err := ioutil.TempFile("", "credentials*.json")
+	s.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"name":"new","key":"new-key","tags":["*"],"scopes":["read"]}]`)
+	s.NoError(err)
+	s.NoError(f.Close())
+
+	s.NoError(s.store.Reload(f.Name()))
+	id, err := s.authenticate(KeyHeader, "new-key")
+	s.NoError(err)
+	s.Equal("new", id.Name)
+	_, err = s.authenticate(KeyHeader, "static-key")
+	s.Equal(ErrInvalidCredentials, err, "rotated key is rejected")
+
+	s.Error(s.store.Reload(os.DevNull))
+	_, err = s.authenticate(KeyHeader, "new-key")
+	s.NoError(err, "invalid file keeps the current credentials")
+}
+
+func (s *AuthUnitTestSuite) Test_Invalid_Credentials() {
+	for _, c := range []*Credential{
+		{Key: "k", Tags: []string{"*"}, Scopes: []Scope{Read}},
+		{Name: "both", Key: "k", Secret: "s", Tags: []string{"*"}, Scopes: []Scope{Read}},
+		{Name: "none", Tags: []string{"*"}, Scopes: []Scope{Read}},
+		{Name: "key and subject", Key: "k", Subject: "CN=a", Tags: []string{"*"}, Scopes: []Scope{Read}},
+		{Name: "bad subject", Subject: "CN=[", Tags: []string{"*"}, Scopes: []Scope{Read}},
+		{Name: "no tags", Key: "k", Scopes: []Scope{Read}},
+		{Name: "bad tag", Key: "k", Tags: []string{"["}, Scopes: []Scope{Read}},
+		{Name: "no scopes", Key: "k", Tags: []string{"*"}},
+		{Name: "bad scope", Key: "k", Tags: []string{"*"}, Scopes: []Scope{"owner"}},
+	} {
+		s.Error(c.validate(), c.Name)
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+const algHS256 = "HS256"
+
+// Claims of a bearer token. Tags and Scopes, when set, narrow the
+// ones of the credential which signed the token.
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Scopes    []Scope  `json:"scopes,omitempty"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid"`
+}
+
+var enc = base64.RawURLEncoding
+
+// NewToken signs the claims with the secret of the credential named kid.
+// The token is a JWT using HS256.
+func NewToken(kid string, secret []byte, claims *Claims) (string, error) {
+	header, err := json.Marshal(&tokenHeader{Alg: algHS256, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	return signed + "." + enc.EncodeToString(sign(secret, signed)), nil
+}
+
+func sign(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func (s *Store) verifyToken(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	header := &tokenHeader{}
+	if err := decodePart(parts[0], header); err != nil || header.Alg != algHS256 {
+		return nil, ErrInvalidCredentials
+	}
+	sec, ok := s.secrets[header.Kid]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, sign(sec.key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidCredentials
+	}
+	claims := &Claims{}
+	if err = decodePart(parts[1], claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	now := s.now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return nil, ErrInvalidCredentials
+	}
+	id := &Identity{
+		Name:   sec.id.Name,
+		Tags:   claims.Tags,
+		Scopes: claims.Scopes,
+		Tenant: sec.id.Tenant,
+		Parent: sec.id,
+	}
+	if claims.Subject != "" {
+		id.Name += "/" + claims.Subject
+	}
+	if len(id.Tags) == 0 {
+		id.Tags = sec.id.Tags
+	}
+	if len(id.Scopes) == 0 {
+		id.Scopes = sec.id.Scopes
+	}
+	return id, nil
+}
+
+func decodePart(part string, v interface{}) error {
+	data, err := enc.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
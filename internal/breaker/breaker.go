@@ -0,0 +1,193 @@
+// Package breaker stops calling a backend which keeps failing and probes
+// it until it recovers.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// State of a breaker.
+type State string
+
+const (
+	// Closed lets every call through and counts the failures.
+	Closed State = "closed"
+	// Open rejects every call until the probe interval has passed.
+	Open State = "open"
+	// HalfOpen lets a single probe through, its result closes or opens the breaker.
+	HalfOpen State = "half_open"
+)
+
+var states = []State{Closed, HalfOpen, Open}
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned for calls rejected by the breaker.
+type OpenError struct {
+	// RetryAfter is the time until the breaker probes the backend.
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return ErrOpen.Error()
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+type Config struct {
+	// FailureRatio of the calls within Window opens the breaker, 0 never opens it.
+	FailureRatio float64
+	// MinRequests within Window are needed before the ratio is evaluated.
+	MinRequests uint32
+	Window      time.Duration
+	// ProbeInterval is the time the breaker stays open before it probes the backend.
+	ProbeInterval time.Duration
+}
+
+// Validate checks that the breaker can open and close again.
+func (c *Config) Validate() error {
+	if c.FailureRatio < 0 || c.FailureRatio > 1 {
+		return fmt.Errorf("breaker failure ratio must be between 0 and 1")
+	}
+	if c.FailureRatio > 0 && (c.Window <= 0 || c.ProbeInterval <= 0) {
+		return fmt.Errorf("breaker window and probe interval must be positive")
+	}
+	return nil
+}
+
+// Breaker counts the transient failures of calls. A nil Breaker lets every call through.
+type Breaker struct {
+	lock     sync.Mutex
+	conf     Config
+	state    State
+	requests uint32
+	failures uint32
+	// since is the start of the counting window or the time the breaker opened.
+	since time.Time
+	// gen changes with the state so late results of calls are ignored.
+	gen     uint64
+	probing bool
+	now     func() time.Time
+}
+
+func NewBreaker(conf *Config) *Breaker {
+	b := &Breaker{conf: *conf, now: time.Now}
+	b.setState(Closed)
+	return b
+}
+
+// Do calls fn unless the breaker is open, transient errors of fn count as failures.
+func (b *Breaker) Do(fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	gen, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	b.done(gen, err != nil && apperrors.Classify(err) == apperrors.Transient)
+	return err
+}
+
+// Available returns an OpenError if a call would be rejected now.
+func (b *Breaker) Available() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rejection(b.now())
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func (b *Breaker) allow() (uint64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	if err := b.rejection(now); err != nil {
+		metrics.BreakerRejections.Inc()
+		return 0, err
+	}
+	switch b.state {
+	case Open:
+		b.setState(HalfOpen)
+		b.probing = true
+	case Closed:
+		if now.Sub(b.since) >= b.conf.Window {
+			b.requests, b.failures, b.since = 0, 0, now
+		}
+		b.requests++
+	}
+	return b.gen, nil
+}
+
+// rejection returns an OpenError while the breaker is open or a probe is
+// running, the backend is unavailable so it is a transient error.
+func (b *Breaker) rejection(now time.Time) error {
+	switch {
+	case b.state == Open && now.Sub(b.since) < b.conf.ProbeInterval:
+		return apperrors.Wrap(apperrors.Transient, &OpenError{RetryAfter: b.since.Add(b.conf.ProbeInterval).Sub(now)})
+	case b.state == HalfOpen && b.probing:
+		return apperrors.Wrap(apperrors.Transient, &OpenError{RetryAfter: b.conf.ProbeInterval})
+	}
+	return nil
+}
+
+func (b *Breaker) done(gen uint64, failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if gen != b.gen {
+		return
+	}
+	switch b.state {
+	case HalfOpen:
+		b.probing = false
+		if failed {
+			b.setState(Open)
+		} else {
+			b.setState(Closed)
+		}
+	case Closed:
+		if !failed {
+			return
+		}
+		b.failures++
+		if b.conf.FailureRatio > 0 && b.requests >= b.conf.MinRequests &&
+			float64(b.failures) >= b.conf.FailureRatio*float64(b.requests) {
+			b.setState(Open)
+		}
+	}
+}
+
+func (b *Breaker) setState(s State) {
+	if b.state != s && b.state != "" {
+		log.Warn().Str("from", string(b.state)).Str("to", string(s)).
+			Uint32("requests", b.requests).Uint32("failures", b.failures).Msg("circuit breaker state changed")
+	}
+	b.state = s
+	b.gen++
+	b.requests, b.failures, b.since = 0, 0, b.now()
+	for i, st := range states {
+		if st == s {
+			metrics.BreakerState.Set(float64(i))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apperrors "github.com/polyse/logdb/internal/errors"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type BreakerUnitTestSuite struct {
+	suite.Suite
+	b   *Breaker
+	now time.Time
+}
+
+func (s *BreakerUnitTestSuite) SetupTest() {
+	s.now = time.Unix(1000, 0)
+	s.b = &Breaker{
+		conf: Config{FailureRatio: 0.5, MinRequests: 4, Window: 10 * time.Second, ProbeInterval: 5 * time.Second},
+		now: func() time.Time {
+			return s.now
+		},
+	}
+	s.b.setState(Closed)
+}
+
+func TestRunBreakerUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(BreakerUnitTestSuite))
+}
+
+func ok() error {
+	return nil
+}
+
+func timeout() error {
+	return context.DeadlineExceeded
+}
+
+func (s *BreakerUnitTestSuite) Test_Opens_On_Failure_Ratio() {
+	s.NoError(s.b.Do(ok))
+	s.Error(s.b.Do(timeout))
+	s.Error(s.b.Do(timeout))
+	s.Equal(Closed, s.b.State(), "too few requests to evaluate the ratio")
+	s.Error(s.b.Do(timeout))
+	s.Equal(Open, s.b.State())
+
+	called := false
+	err := s.b.Do(func() error {
+		called = true
+		return nil
+	})
+	s.False(called)
+	s.True(errors.Is(err, ErrOpen))
+	s.Equal(apperrors.Transient, apperrors.Classify(err))
+	var oErr *OpenError
+	s.True(errors.As(s.b.Available(), &oErr))
+	s.Equal(5*time.Second, oErr.RetryAfter)
+}
+
+func (s *BreakerUnitTestSuite) Test_Other_Errors_Are_No_Failures() {
+	for i := 0; i < 10; i++ {
+		s.Error(s.b.Do(func() error {
+			return fmt.Errorf("document not found")
+		}))
+	}
+	s.Equal(Closed, s.b.State())
+}
+
+func (s *BreakerUnitTestSuite) Test_Window_Resets_Counters() {
+	s.Error(s.b.Do(timeout))
+	s.Error(s.b.Do(timeout))
+	s.Error(s.b.Do(timeout))
+	s.now = s.now.Add(10 * time.Second)
+	s.Error(s.b.Do(timeout))
+	s.Equal(Closed, s.b.State())
+}
+
+func (s *BreakerUnitTestSuite) Test_Half_Open_Probe() {
+	s.b.setState(Open)
+	s.now = s.now.Add(5 * time.Second)
+	s.NoError(s.b.Available())
+
+	err := s.b.Do(func() error {
+		s.Equal(HalfOpen, s.b.State())
+		s.True(errors.Is(s.b.Do(ok), ErrOpen), "a single probe runs at a time")
+		return context.DeadlineExceeded
+	})
+	s.Error(err)
+	s.Equal(Open, s.b.State(), "a failed probe opens the breaker again")
+
+	s.now = s.now.Add(5 * time.Second)
+	s.NoError(s.b.Do(ok))
+	s.Equal(Closed, s.b.State())
+}
+
+func (s *BreakerUnitTestSuite) Test_Late_Results_Are_Ignored() {
+	gen, err := s.b.allow()
+	s.NoError(err)
+	s.b.setState(Open)
+	s.b.done(gen, false)
+	s.Equal(Open, s.b.State())
+}
+
+func (s *BreakerUnitTestSuite) Test_Disabled() {
+	s.b.conf.FailureRatio = 0
+	for i := 0; i < 10; i++ {
+		s.Error(s.b.Do(timeout))
+	}
+	s.Equal(Closed, s.b.State())
+
+	var b *Breaker
+	s.NoError(b.Do(ok))
+	s.NoError(b.Available())
+}
+
+func (s *BreakerUnitTestSuite) Test_Validate() {
+	s.NoError((&Config{}).Validate())
+	s.NoError(s.b.conf.Validate())
+	s.Error((&Config{FailureRatio: 2}).Validate())
+	s.Error((&Config{FailureRatio: 0.5}).Validate())
+}
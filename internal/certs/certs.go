@@ -0,0 +1,97 @@
+// Package certs serves TLS with a certificate and a client CA which can be
+// replaced at runtime without closing the listener.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"sync"
+)
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS, client certificates are verified against it.
+	ClientCAFile string
+	// ClientAuth is the client certificate policy when ClientCAFile is set.
+	ClientAuth tls.ClientAuthType
+}
+
+// Loader holds the current certificate and client CA pool.
+type Loader struct {
+	conf Config
+	lock sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewLoader loads the files of the configuration. It returns nil
+// without error when no certificate is configured.
+func NewLoader(conf *Config) (*Loader, error) {
+	if conf.CertFile == "" && conf.KeyFile == "" {
+		return nil, nil
+	}
+	l := &Loader{conf: *conf}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the files again. The current certificate and pool are kept if any file is invalid.
+func (l *Loader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(l.conf.CertFile, l.conf.KeyFile)
+	if err != nil {
+		return fmt.Errorf("can not load certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if l.conf.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(l.conf.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("can not load client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA file %s", l.conf.ClientCAFile)
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.cert = &cert
+	l.pool = pool
+	log.Debug().Str("cert", l.conf.CertFile).Bool("mtls", pool != nil).Msg("certificates loaded")
+	return nil
+}
+
+// Files returns the files which are read by Reload.
+func (l *Loader) Files() []string {
+	files := []string{l.conf.CertFile, l.conf.KeyFile}
+	if l.conf.ClientCAFile != "" {
+		files = append(files, l.conf.ClientCAFile)
+	}
+	return files
+}
+
+// TLSConfig returns a server configuration which uses the certificates
+// loaded when each handshake starts.
+func (l *Loader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			l.lock.RLock()
+			defer l.lock.RUnlock()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*l.cert},
+			}
+			if l.pool != nil {
+				c.ClientCAs = l.pool
+				c.ClientAuth = l.conf.ClientAuth
+			}
+			return c, nil
+		},
+	}
+}
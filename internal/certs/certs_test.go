@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type CertsUnitTestSuite struct {
+	suite.Suite
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func (s *CertsUnitTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "certs")
+	s.Require().NoError(err)
+	s.dir = dir
+	s.ca, s.caKey = s.issue("test ca", nil, nil)
+	s.writePEM("ca.pem", "CERTIFICATE", s.ca.Raw)
+}
+
+func (s *CertsUnitTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func TestRunCertsUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(CertsUnitTestSuite))
+}
+
+// issue creates a certificate signed by the parent or a self signed CA without parent.
+func (s *CertsUnitTestSuite) issue(cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	s.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(s.serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"logdb"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	s.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return cert, key
+}
+
+func (s *CertsUnitTestSuite) writePEM(name, typ string, der []byte) string {
+	file := filepath.Join(s.dir, name)
+	s.Require().NoError(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return file
+}
+
+func (s *CertsUnitTestSuite) writePair(name, cn string) (string, string, tls.Certificate) {
+	cert, key := s.issue(cn, s.ca, s.caKey)
+	der, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+	certFile := s.writePEM(name+".pem", "CERTIFICATE", cert.Raw)
+	keyFile := s.writePEM(name+"-key.pem", "EC PRIVATE KEY", der)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	s.Require().NoError(err)
+	return certFile, keyFile, pair
+}
+
+// serve accepts TLS connections and sends the subject of the verified client certificate.
+func (s *CertsUnitTestSuite) serve(l *Loader) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", l.TLSConfig())
+	s.Require().NoError(err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn := c.(*tls.Conn)
+			if err = conn.Handshake(); err == nil {
+				subject := "anonymous"
+				if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 {
+					subject = chains[0][0].Subject.String()
+				}
+				_, _ = conn.Write([]byte(subject))
+			}
+			_ = conn.Close()
+		}
+	}()
+	return ln
+}
+
+func (s *CertsUnitTestSuite) dial(ln net.Listener, certs ...tls.Certificate) (*x509.Certificate, string, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.ca)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		RootCAs:      pool,
+		Certificates: certs,
+		ServerName:   "localhost",
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	data, err := ioutil.ReadAll(conn)
+	return conn.ConnectionState().PeerCertificates[0], string(data), err
+}
+
+func (s *CertsUnitTestSuite) Test_Reload_Certificate() {
+	certFile, keyFile, _ := s.writePair("server", "server-1")
+	l, err := NewLoader(&Config{CertFile: certFile, KeyFile: keyFile})
+	s.Require().NoError(err)
+	ln := s.serve(l)
+	defer ln.Close()
+
+	cert, subject, err := s.dial(ln)
+	s.NoError(err)
+	s.Equal("server-1", cert.Subject.CommonName)
+	s.Equal("anonymous", subject)
+
+	s.writePair("server", "server-2")
+	s.NoError(l.Reload())
+	cert, _, err = s.dial(ln)
+	s.NoError(err)
+	s.Equal("server-2", cert.Subject.CommonName)
+
+	s.NoError(ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	s.Error(l.Reload())
+	cert, _, err = s.dial(ln)
+	s.NoError(err)
+	s.Equal("server-2", cert.Subject.CommonName, "invalid files keep the current certificate")
+}
+
+func (s *CertsUnitTestSuite) Test_Mutual_TLS() {
+	certFile, keyFile, _ := s.writePair("server", "server")
+	_, _, client := s.writePair("client", "fluentd")
+	l, err := NewLoader(&Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: filepath.Join(s.dir, "ca.pem"),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	s.Require().NoError(err)
+	ln := s.serve(l)
+	defer ln.Close()
+
+	_, subject, err := s.dial(ln, client)
+	s.NoError(err)
+	s.Equal("CN=fluentd,O=logdb", subject)
+
+	// TLS 1.3 reports a rejected client certificate on the first read
+	_, subject, err = s.dial(ln)
+	s.True(err != nil || subject == "", "client without certificate is rejected")
+}
+
+func (s *CertsUnitTestSuite) Test_No_Certificate() {
+	l, err := NewLoader(&Config{})
+	s.NoError(err)
+	s.Nil(l)
+
+	_, err = NewLoader(&Config{CertFile: filepath.Join(s.dir, "missing.pem"), KeyFile: filepath.Join(s.dir, "missing.pem")})
+	s.Error(err)
+}
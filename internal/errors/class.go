@@ -0,0 +1,115 @@
+package errors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	ml "github.com/senyast4745/meilisearch-go"
+	"net"
+	"net/http"
+)
+
+// Class groups errors by their origin, each class has its own threshold and action.
+type Class string
+
+const (
+	// Client errors are caused by a request, such as a record which can not be parsed.
+	Client Class = "client"
+	// Transient errors of the backend are expected to go away, such as timeouts.
+	Transient Class = "backend_transient"
+	// Permanent errors of the backend need an operator, such as a rejected API key.
+	Permanent Class = "backend_permanent"
+	// Internal errors are bugs of the adapter.
+	Internal Class = "internal"
+)
+
+// Classes lists every class.
+var Classes = []Class{Client, Transient, Permanent, Internal}
+
+// clientDbErrors are the error codes of Meilisearch rejecting the index uid
+// or the documents of a request, which are caused by the request.
+var clientDbErrors = map[string]struct{}{
+	"invalid_index_uid":           {},
+	"index_already_exists":        {},
+	"index_not_found":             {},
+	"invalid_document_id":         {},
+	"missing_document_id":         {},
+	"missing_primary_key":         {},
+	"primary_key_already_present": {},
+	"document_not_found":          {},
+	"malformed_payload":           {},
+	"payload_too_large":           {},
+	"unsupported_media_type":      {},
+	"bad_request":                 {},
+}
+
+// Error attaches a class to an error.
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap attaches the class to err, a nil err stays nil.
+func Wrap(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Classify returns the class attached by Wrap, or guesses it from errors of
+// the Meilisearch client and the network. Unknown errors are internal.
+func Classify(err error) Class {
+	var cErr *Error
+	if errors.As(err, &cErr) {
+		return cErr.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return Transient
+	}
+	var mlErr *ml.Error
+	if errors.As(err, &mlErr) {
+		return classifyDbError(mlErr)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Transient
+	}
+	return Internal
+}
+
+func classifyDbError(err *ml.Error) Class {
+	switch err.ErrCode {
+	case ml.ErrCodeRequestExecution, ml.ErrCodeResponseReadBody:
+		return Transient
+	case ml.ErrCodeResponseStatusCode:
+		if err.StatusCode >= http.StatusInternalServerError ||
+			err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusRequestTimeout {
+			return Transient
+		}
+		if _, ok := clientDbErrors[dbErrorCode(err)]; ok {
+			return Client
+		}
+		return Permanent
+	case ml.ErrCodeResponseUnmarshalBody:
+		return Permanent
+	}
+	return Internal
+}
+
+// dbErrorCode returns the errorCode of the response of Meilisearch.
+func dbErrorCode(err *ml.Error) string {
+	var body struct {
+		ErrorCode string `json:"errorCode"`
+	}
+	_ = json.Unmarshal([]byte(err.ResponseToString), &body)
+	return body.ErrorCode
+}
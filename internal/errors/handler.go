@@ -2,53 +2,236 @@ package errors
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/metrics"
 	"github.com/rs/zerolog/log"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
-type Handler struct {
-	errChan  chan error
-	errCount uint32
-	conf     *Config
-	ticker   *time.Ticker
+// Action is what the handler does once a class reaches its threshold.
+type Action string
+
+const (
+	// None only logs and counts the errors.
+	None Action = "none"
+	// Degrade fails the readiness check while the adapter keeps serving.
+	Degrade Action = "degrade"
+	// Shed rejects writes until the counters are reset, and degrades.
+	Shed Action = "shed"
+	// Exit stops the application.
+	Exit Action = "exit"
+)
+
+// actions are ordered by severity.
+var actions = []Action{None, Degrade, Shed, Exit}
+
+// ParseAction returns the action of the name.
+func ParseAction(name string) (Action, error) {
+	for _, a := range actions {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown error action %q", name)
+}
+
+func (a Action) severity() int {
+	for i, b := range actions {
+		if a == b {
+			return i
+		}
+	}
+	return 0
+}
+
+// ErrShedding rejects writes after too many errors.
+var ErrShedding = errors.New("shedding load after too many errors")
+
+// ShedError is returned by Shed while writes are rejected.
+type ShedError struct {
+	// RetryAfter is the time until the counters are reset.
+	RetryAfter time.Duration
+}
+
+func (e *ShedError) Error() string {
+	return ErrShedding.Error()
+}
+
+func (e *ShedError) Is(target error) bool {
+	return target == ErrShedding
+}
+
+// errChanSize buffers bursts of errors while the handler logs them.
+const errChanSize = 128
+
+// Policy is the action taken once more than MaxCount errors of a class are
+// reported within the reset time. A zero MaxCount never triggers the action.
+type Policy struct {
+	MaxCount uint32
+	Action   Action
 }
 
 type Config struct {
-	MaxErrorCount uint32
-	ResetTime     time.Duration
+	Policies  map[Class]Policy
+	ResetTime time.Duration
 }
 
-func NewHandler(pCtx context.Context, conf *Config) (context.Context, chan<- error) {
-	handler := &Handler{
-		errChan:  make(chan error, conf.MaxErrorCount),
-		errCount: 0,
-		conf:     conf,
-		ticker:   time.NewTicker(conf.ResetTime),
+// Validate checks the action of every policy.
+func (c *Config) Validate() error {
+	for class, p := range c.Policies {
+		if _, err := ParseAction(string(p.Action)); err != nil {
+			return fmt.Errorf("%s: %w", class, err)
+		}
 	}
-	ctx := asyncHandleError(pCtx, handler)
-	return ctx, handler.errChan
+	return nil
+}
+
+// Handler counts the reported errors per class and applies the action of
+// every class reaching its threshold until the counters are reset.
+type Handler struct {
+	errChan chan error
+	conf    *Config
+	lock    sync.RWMutex
+	counts  map[Class]uint32
+	action  Action
+	resetAt time.Time
+	now     func() time.Time
+}
+
+// State is a snapshot of the handler.
+type State struct {
+	Action  Action                `json:"action"`
+	ResetAt time.Time             `json:"reset_at"`
+	Classes map[Class]*ClassState `json:"classes"`
+}
+
+type ClassState struct {
+	Count    uint32 `json:"count"`
+	MaxCount uint32 `json:"max_count"`
+	Action   Action `json:"action"`
+	Tripped  bool   `json:"tripped"`
 }
 
-func asyncHandleError(pCtx context.Context, handler *Handler) context.Context {
+// NewHandler starts handling errors, the returned context is cancelled when
+// a class triggers Exit.
+func NewHandler(pCtx context.Context, conf *Config) (context.Context, *Handler) {
+	handler := &Handler{
+		errChan: make(chan error, errChanSize),
+		conf:    conf,
+		now:     time.Now,
+	}
+	handler.reset()
 	ctx, cancelFunc := context.WithCancel(pCtx)
-	go func() {
-		for {
-			select {
-			case err := <-handler.errChan:
-				log.Warn().Err(err).Msg("handle error")
-				if atomic.AddUint32(&handler.errCount, 1) > handler.conf.MaxErrorCount {
-					cancelFunc()
-				}
-			case <-pCtx.Done():
-				return
-			case <-handler.ticker.C:
-				atomic.StoreUint32(&handler.errCount, 0)
-			default:
-				time.Sleep(1 * time.Millisecond)
+	go handler.run(pCtx, cancelFunc)
+	return ctx, handler
+}
+
+// Chan returns the channel errors are reported to.
+func (h *Handler) Chan() chan<- error {
+	return h.errChan
+}
+
+func (h *Handler) run(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(h.conf.ResetTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-h.errChan:
+			if h.handle(err) == Exit {
+				cancel()
 			}
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reset()
 		}
+	}
+}
+
+// handle counts the error and returns the action the handler takes.
+func (h *Handler) handle(err error) Action {
+	class := Classify(err)
+	metrics.HandledErrors.WithLabelValues(string(class)).Inc()
+	log.Warn().Err(err).Str("class", string(class)).Msg("handle error")
 
-	}()
-	return ctx
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts[class]++
+	p := h.conf.Policies[class]
+	if p.MaxCount > 0 && h.counts[class] > p.MaxCount && p.Action.severity() > h.action.severity() {
+		log.Error().Str("class", string(class)).Uint32("count", h.counts[class]).
+			Str("action", string(p.Action)).Msg("error threshold reached")
+		h.action = p.Action
+		metrics.ErrorAction.Set(float64(h.action.severity()))
+	}
+	return h.action
+}
+
+// reset clears the counters and recovers from every action but Exit.
+func (h *Handler) reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts = make(map[Class]uint32)
+	if h.action != Exit {
+		h.action = None
+	}
+	h.resetAt = h.now().Add(h.conf.ResetTime)
+	metrics.ErrorAction.Set(float64(h.action.severity()))
+}
+
+// Check fails while the handler degrades the adapter, it is a readiness check.
+func (h *Handler) Check() (string, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var count uint32
+	for _, n := range h.counts {
+		count += n
+	}
+	detail := fmt.Sprintf("%d errors, action %s", count, h.action)
+	if h.action != None {
+		return detail, fmt.Errorf("error threshold reached: %s", detail)
+	}
+	return detail, nil
+}
+
+// Shed returns a ShedError while writes are rejected. A nil Handler never sheds.
+func (h *Handler) Shed() error {
+	if h == nil {
+		return nil
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.action.severity() < Shed.severity() {
+		return nil
+	}
+	return &ShedError{RetryAfter: h.resetAt.Sub(h.now())}
+}
+
+// State returns a snapshot of the counters and the current action.
+func (h *Handler) State() *State {
+	if h == nil {
+		return &State{Action: None, Classes: map[Class]*ClassState{}}
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	s := &State{
+		Action:  h.action,
+		ResetAt: h.resetAt,
+		Classes: make(map[Class]*ClassState, len(Classes)),
+	}
+	for _, class := range Classes {
+		p := h.conf.Policies[class]
+		if p.Action == "" {
+			p.Action = None
+		}
+		s.Classes[class] = &ClassState{
+			Count:    h.counts[class],
+			MaxCount: p.MaxCount,
+			Action:   p.Action,
+			Tripped:  p.MaxCount > 0 && h.counts[class] > p.MaxCount,
+		}
+	}
+	return s
 }
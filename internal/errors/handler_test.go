@@ -0,0 +1,130 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ml "github.com/senyast4745/meilisearch-go"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type HandlerUnitTestSuite struct {
+	suite.Suite
+	h   *Handler
+	now time.Time
+}
+
+func (s *HandlerUnitTestSuite) SetupTest() {
+	s.now = time.Unix(1000, 0)
+	s.h = &Handler{
+		conf: &Config{
+			Policies: map[Class]Policy{
+				Client:    {MaxCount: 0, Action: Exit},
+				Transient: {MaxCount: 2, Action: Shed},
+				Permanent: {MaxCount: 1, Action: Degrade},
+				Internal:  {MaxCount: 3, Action: Exit},
+			},
+			ResetTime: time.Minute,
+		},
+		now: func() time.Time {
+			return s.now
+		},
+	}
+	s.h.reset()
+}
+
+func TestRunHandlerUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(HandlerUnitTestSuite))
+}
+
+func (s *HandlerUnitTestSuite) Test_Classify() {
+	for err, class := range map[error]Class{
+		Wrap(Client, fmt.Errorf("bad json")):                              Client,
+		fmt.Errorf("save: %w", Wrap(Permanent, fmt.Errorf("test"))):       Permanent,
+		context.DeadlineExceeded:                                          Transient,
+		&ml.Error{ErrCode: ml.ErrCodeRequestExecution}:                    Transient,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 503}: Transient,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 429}: Transient,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 403}: Permanent,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 400,
+			ResponseToString: `{"message":"invalid index uid","errorCode":"invalid_index_uid"}`}: Client,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 400,
+			ResponseToString: `{"errorCode":"invalid_document_id"}`}: Client,
+		&ml.Error{ErrCode: ml.ErrCodeResponseStatusCode, StatusCode: 400,
+			ResponseToString: `{"errorCode":"unknown"}`}: Permanent,
+		&ml.Error{ErrCode: ml.ErrCodeMarshalRequest}: Internal,
+		fmt.Errorf("test error"):                     Internal,
+	} {
+		s.Equal(class, Classify(err), err.Error())
+	}
+	s.Nil(Wrap(Client, nil))
+}
+
+func (s *HandlerUnitTestSuite) Test_Client_Errors_Do_Not_Stop() {
+	for i := 0; i < 1000; i++ {
+		s.Equal(None, s.h.handle(Wrap(Client, fmt.Errorf("bad json"))))
+	}
+	_, err := s.h.Check()
+	s.NoError(err)
+	s.Equal(uint32(1000), s.h.State().Classes[Client].Count)
+}
+
+func (s *HandlerUnitTestSuite) Test_Thresholds() {
+	s.Equal(None, s.h.handle(Wrap(Permanent, fmt.Errorf("test"))))
+	s.False(s.h.State().Classes[Permanent].Tripped, "the max count itself does not trigger the action")
+	s.Equal(Degrade, s.h.handle(Wrap(Permanent, fmt.Errorf("test"))))
+	detail, err := s.h.Check()
+	s.Error(err)
+	s.Equal("2 errors, action degrade", detail)
+	s.NoError(s.h.Shed())
+
+	s.Equal(Degrade, s.h.handle(context.DeadlineExceeded))
+	s.Equal(Degrade, s.h.handle(context.DeadlineExceeded))
+	s.Equal(Shed, s.h.handle(context.DeadlineExceeded))
+	s.now = s.now.Add(20 * time.Second)
+	err = s.h.Shed()
+	s.True(errors.Is(err, ErrShedding))
+	var sErr *ShedError
+	s.True(errors.As(err, &sErr))
+	s.Equal(40*time.Second, sErr.RetryAfter)
+
+	state := s.h.State()
+	s.Equal(Shed, state.Action)
+	s.True(state.Classes[Transient].Tripped)
+	s.False(state.Classes[Internal].Tripped)
+
+	s.h.reset()
+	s.NoError(s.h.Shed())
+	_, err = s.h.Check()
+	s.NoError(err, "reset recovers from degrade and shed")
+
+	for i := 0; i < 3; i++ {
+		s.Equal(None, s.h.handle(fmt.Errorf("test")))
+	}
+	s.Equal(Exit, s.h.handle(fmt.Errorf("test")))
+	s.Equal(Exit, s.h.handle(context.DeadlineExceeded), "a milder action does not replace exit")
+	s.h.reset()
+	s.Equal(Exit, s.h.State().Action)
+}
+
+func (s *HandlerUnitTestSuite) Test_Exit_Cancels_Context() {
+	ctx, h := NewHandler(context.Background(), &Config{
+		Policies:  map[Class]Policy{Internal: {MaxCount: 1, Action: Exit}},
+		ResetTime: time.Minute,
+	})
+	h.Chan() <- Wrap(Client, fmt.Errorf("bad json"))
+	h.Chan() <- fmt.Errorf("test")
+	h.Chan() <- fmt.Errorf("test")
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		s.Fail("context is not cancelled")
+	}
+}
+
+func (s *HandlerUnitTestSuite) Test_Validate() {
+	s.NoError(s.h.conf.Validate())
+	s.Error((&Config{Policies: map[Class]Policy{Client: {Action: "restart"}}}).Validate())
+}
@@ -0,0 +1,102 @@
+package gelf
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// chunkHeaderSize is the size of the magic bytes, the message id, the
+	// sequence number and the sequence count of a chunk.
+	chunkHeaderSize = 12
+	// maxChunks is the max sequence count of a message.
+	maxChunks = 128
+	// DefaultChunkTimeout is the time all chunks of a message must arrive within.
+	DefaultChunkTimeout = 5 * time.Second
+	// maxPending is the max count of messages waiting for chunks.
+	maxPending = 1024
+)
+
+var errChunk = fmt.Errorf("%w: invalid chunk", ErrMalformed)
+
+// IsChunk reports whether the datagram is a chunk of a larger message.
+func IsChunk(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1e && data[1] == 0x0f
+}
+
+// chunked is a message waiting for its chunks.
+type chunked struct {
+	parts    [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+// assembler joins the chunks of messages. It is not safe for concurrent use.
+type assembler struct {
+	timeout time.Duration
+	max     int
+	pending map[[8]byte]*chunked
+}
+
+func newAssembler(timeout time.Duration, max int) *assembler {
+	return &assembler{timeout: timeout, max: max, pending: map[[8]byte]*chunked{}}
+}
+
+// add keeps the chunk and returns its message once all chunks arrived. The
+// chunk is copied.
+func (a *assembler) add(data []byte, now time.Time) ([]byte, error) {
+	if len(data) <= chunkHeaderSize {
+		return nil, errChunk
+	}
+	var id [8]byte
+	copy(id[:], data[2:10])
+	seq, count := int(data[10]), int(data[11])
+	if count == 0 || count > maxChunks || seq >= count {
+		return nil, errChunk
+	}
+	c, ok := a.pending[id]
+	if !ok {
+		if len(a.pending) >= maxPending {
+			return nil, errors.New("too many incomplete GELF messages")
+		}
+		c = &chunked{parts: make([][]byte, count), first: now}
+		a.pending[id] = c
+	}
+	if len(c.parts) != count {
+		delete(a.pending, id)
+		return nil, errChunk
+	}
+	if c.parts[seq] != nil {
+		return nil, nil
+	}
+	c.parts[seq] = append([]byte(nil), data[chunkHeaderSize:]...)
+	c.received++
+	c.size += len(data) - chunkHeaderSize
+	if c.size > a.max {
+		delete(a.pending, id)
+		return nil, ErrTooLarge
+	}
+	if c.received < count {
+		return nil, nil
+	}
+	delete(a.pending, id)
+	res := make([]byte, 0, c.size)
+	for _, p := range c.parts {
+		res = append(res, p...)
+	}
+	return res, nil
+}
+
+// expire drops the messages whose chunks did not arrive in time and returns their count.
+func (a *assembler) expire(now time.Time) int {
+	n := 0
+	for id, c := range a.pending {
+		if now.Sub(c.first) > a.timeout {
+			delete(a.pending, id)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,143 @@
+// Package gelf decodes GELF messages, as sent by the gelf log driver of
+// Docker and Graylog clients, and receives them over UDP.
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/input"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// Input is the name of the listener in metrics.
+	Input = "gelf"
+	// DefaultTag is the tag of messages without the index field.
+	DefaultTag = "gelf"
+	// DefaultMaxMessageSize is the max size of a decompressed message.
+	DefaultMaxMessageSize = 1 << 20
+	// messageKey is the field holding the short message.
+	messageKey = "message"
+)
+
+var (
+	// ErrMalformed is returned for messages which are not GELF.
+	ErrMalformed = errors.New("malformed GELF message")
+	// ErrTooLarge is returned for messages larger than the max size.
+	ErrTooLarge = errors.New("GELF message is too large")
+)
+
+// Message is a decoded GELF message.
+type Message struct {
+	// Fields of its record: the short message as message, host,
+	// full_message, level and the other fields of the sender, additional
+	// fields without their underscore.
+	Fields map[string]interface{}
+	// Timestamp is zero if the message has none.
+	Timestamp time.Time
+}
+
+// Decode reads a GELF message, which may be gzip or zlib compressed. Messages
+// decompressed to more than max bytes are rejected.
+func Decode(data []byte, max int) (*Message, error) {
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	data, err := decompress(data, max)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, ErrTooLarge
+	}
+	d := json.NewDecoder(bytes.NewReader(bytes.TrimRight(data, "\x00\r\n")))
+	d.UseNumber()
+	var raw map[string]interface{}
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	short, ok := raw["short_message"].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: short_message is missing", ErrMalformed)
+	}
+	m := &Message{Fields: make(map[string]interface{}, len(raw))}
+	for k, v := range raw {
+		switch k {
+		case "version", "short_message", "_id":
+		case "timestamp":
+			ts, err := timestamp(v)
+			if err != nil {
+				return nil, err
+			}
+			m.Timestamp = ts
+		default:
+			m.Fields[strings.TrimPrefix(k, "_")] = v
+		}
+	}
+	m.Fields[messageKey] = short
+	return m, nil
+}
+
+// timestamp reads the seconds since the epoch with optional decimal places.
+func timestamp(v interface{}) (time.Time, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: timestamp is not a number", ErrMalformed)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
+// decompress inflates gzip and zlib payloads up to one byte more than max,
+// others are returned as is.
+func decompress(data []byte, max int) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) > 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	defer r.Close()
+	res, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return res, nil
+}
+
+// Tag returns the tag of the message, the value of the field. The field is
+// named like in GELF, _container_name is the container_name field.
+func (m *Message) Tag(field string) string {
+	v, ok := m.Fields[strings.TrimPrefix(field, "_")]
+	if !ok || v == nil {
+		return DefaultTag
+	}
+	return input.Tag(fmt.Sprint(v), DefaultTag)
+}
+
+// Stream returns the source of the message, its host and the container
+// which logged it, if any.
+func (m *Message) Stream() string {
+	host, _ := m.Fields["host"].(string)
+	container, _ := m.Fields["container_id"].(string)
+	return host + "/" + container
+}
@@ -0,0 +1,208 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type GelfUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunGelfUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(GelfUnitTestSuite))
+}
+
+const testMessage = `{"version":"1.1","host":"docker-1","short_message":"started","full_message":"started\nin 2s",` +
+	`"timestamp":1600000000.25,"level":6,"_container_name":"web","_id":"x","_count":2}`
+
+func (s *GelfUnitTestSuite) Test_Decode() {
+	m, err := Decode([]byte(testMessage), 0)
+	s.NoError(err)
+	s.Equal(time.Unix(1600000000, 250000000).UTC(), m.Timestamp)
+	s.Equal(map[string]interface{}{
+		"host": "docker-1", "message": "started", "full_message": "started\nin 2s",
+		"level": json.Number("6"), "container_name": "web", "count": json.Number("2"),
+	}, m.Fields)
+	s.Equal("web", m.Tag(DefaultIndexField))
+	s.Equal("docker-1", m.Tag("host"))
+	s.Equal(DefaultTag, m.Tag("_missing"))
+
+	m, err = Decode([]byte(`{"short_message":"no time"}`+"\x00"), 0)
+	s.NoError(err)
+	s.True(m.Timestamp.IsZero())
+}
+
+func (s *GelfUnitTestSuite) Test_Decode_Compressed() {
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(testMessage))
+	s.NoError(w.Close())
+	zw := zlib.NewWriter(&zl)
+	_, _ = zw.Write([]byte(testMessage))
+	s.NoError(zw.Close())
+
+	for _, data := range [][]byte{gz.Bytes(), zl.Bytes()} {
+		m, err := Decode(data, 0)
+		s.NoError(err)
+		s.Equal("started", m.Fields["message"])
+	}
+
+	_, err := Decode(gz.Bytes(), 64)
+	s.True(errors.Is(err, ErrTooLarge))
+	_, err = Decode([]byte{0x1f, 0x8b, 0x00}, 0)
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+func (s *GelfUnitTestSuite) Test_Decode_Malformed() {
+	for _, data := range []string{`not json`, `{"host":"h"}`, `{"short_message":"m","timestamp":"now"}`, `[]`} {
+		_, err := Decode([]byte(data), 0)
+		s.True(errors.Is(err, ErrMalformed), data)
+	}
+	_, err := Decode([]byte(testMessage), 16)
+	s.True(errors.Is(err, ErrTooLarge))
+}
+
+// chunks splits the message into chunks of size bytes.
+func chunks(id string, data []byte, size int) [][]byte {
+	var res [][]byte
+	count := (len(data) + size - 1) / size
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		c := append([]byte{0x1e, 0x0f}, id...)
+		c = append(c, byte(i), byte(count))
+		res = append(res, append(c, data[i*size:end]...))
+	}
+	return res
+}
+
+func (s *GelfUnitTestSuite) Test_Assembler() {
+	now := time.Now()
+	a := newAssembler(time.Second, 1024)
+	parts := chunks("abcdefgh", []byte(testMessage), 50)
+	s.True(IsChunk(parts[0]))
+	s.False(IsChunk([]byte(testMessage)))
+
+	// out of order and duplicated chunks
+	for i := len(parts) - 1; i > 0; i-- {
+		data, err := a.add(parts[i], now)
+		s.NoError(err)
+		s.Nil(data)
+	}
+	data, err := a.add(parts[1], now)
+	s.NoError(err)
+	s.Nil(data)
+	data, err = a.add(parts[0], now)
+	s.NoError(err)
+	s.Equal(testMessage, string(data))
+	s.Empty(a.pending)
+
+	_, err = a.add(parts[0], now)
+	s.NoError(err)
+	s.Equal(0, a.expire(now.Add(time.Second)))
+	s.Equal(1, a.expire(now.Add(2*time.Second)))
+
+	_, err = a.add([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 2, 2, 'x'}, now)
+	s.True(errors.Is(err, ErrMalformed))
+	_, err = a.add([]byte{0x1e, 0x0f, 1, 2}, now)
+	s.True(errors.Is(err, ErrMalformed))
+
+	small := newAssembler(time.Second, 60)
+	_, err = small.add(parts[0], now)
+	s.NoError(err)
+	_, err = small.add(parts[1], now)
+	s.True(errors.Is(err, ErrTooLarge))
+	s.Empty(small.pending)
+}
+
+type record struct {
+	tag    string
+	stream string
+	doc    map[string]interface{}
+	ts     time.Time
+}
+
+type testSink struct {
+	lock    sync.Mutex
+	records []record
+}
+
+func (t *testSink) Ingest(_ context.Context, _, tag, stream string, doc map[string]interface{}, ts time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.records = append(t.records, record{tag: tag, stream: stream, doc: doc, ts: ts})
+	return nil
+}
+
+func (t *testSink) count() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.records)
+}
+
+func (s *GelfUnitTestSuite) Test_Server() {
+	sink := &testSink{}
+	srv, closeSrv, err := NewServer(context.Background(), &Config{UDPAddr: "127.0.0.1:0"}, sink)
+	s.NoError(err)
+	defer closeSrv()
+
+	c, err := net.Dial("udp", srv.Addr().String())
+	s.NoError(err)
+	defer c.Close()
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(strings.Replace(testMessage, `"web"`, `"api/v2"`, 1)))
+	s.NoError(w.Close())
+	for _, p := range chunks("12345678", gz.Bytes(), 40) {
+		_, err = c.Write(p)
+		s.NoError(err)
+	}
+	_, err = c.Write([]byte("malformed"))
+	s.NoError(err)
+	_, err = c.Write([]byte(`{"short_message":"plain"}`))
+	s.NoError(err)
+	s.Eventually(func() bool { return sink.count() == 2 }, time.Second, 5*time.Millisecond)
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	s.Equal("api-v2", sink.records[0].tag)
+	s.Equal("docker-1/", sink.records[0].stream)
+	s.Equal("started", sink.records[0].doc["message"])
+	s.Equal(time.Unix(1600000000, 250000000).UTC(), sink.records[0].ts)
+	s.Equal(record{tag: DefaultTag, stream: "/", doc: map[string]interface{}{"message": "plain"}}, sink.records[1])
+}
+
+func (s *GelfUnitTestSuite) Test_Server_Expires_Chunks() {
+	sink := &testSink{}
+	srv, closeSrv, err := NewServer(context.Background(),
+		&Config{UDPAddr: "127.0.0.1:0", ChunkTimeout: 20 * time.Millisecond}, sink)
+	s.NoError(err)
+	defer closeSrv()
+
+	c, err := net.Dial("udp", srv.Addr().String())
+	s.NoError(err)
+	defer c.Close()
+	parts := chunks("12345678", []byte(testMessage), 50)
+	_, err = c.Write(parts[0])
+	s.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+	for _, p := range parts[1:] {
+		_, err = c.Write(p)
+		s.NoError(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.Equal(0, sink.count())
+}
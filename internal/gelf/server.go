@@ -0,0 +1,127 @@
+package gelf
+
+import (
+	"context"
+	"errors"
+	"github.com/polyse/logdb/internal/input"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultIndexField is the field whose value is the tag of messages.
+const DefaultIndexField = "_container_name"
+
+// maxDatagramSize is the max size of a UDP datagram.
+const maxDatagramSize = 64 * 1024
+
+// Config of the GELF UDP listener.
+type Config struct {
+	UDPAddr string
+	// IndexField is the field whose value is the tag of a message.
+	IndexField     string
+	MaxMessageSize int
+	ChunkTimeout   time.Duration
+}
+
+// Server reads messages from the UDP listener and hands them to the sink.
+type Server struct {
+	conf   Config
+	sink   input.Sink
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	pc     net.PacketConn
+}
+
+// NewServer starts the UDP listener. The returned function stops it and
+// waits for the message being written.
+func NewServer(ctx context.Context, conf *Config, sink input.Sink) (*Server, func(), error) {
+	s := &Server{conf: *conf, sink: sink}
+	if s.conf.IndexField == "" {
+		s.conf.IndexField = DefaultIndexField
+	}
+	if s.conf.MaxMessageSize <= 0 {
+		s.conf.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if s.conf.ChunkTimeout <= 0 {
+		s.conf.ChunkTimeout = DefaultChunkTimeout
+	}
+	pc, err := net.ListenPacket("udp", s.conf.UDPAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.pc = pc
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	log.Info().Str("listening", pc.LocalAddr().String()).Msg("gelf udp listener started")
+	s.wg.Add(1)
+	go s.serve()
+	return s, s.close, nil
+}
+
+// Addr returns the address of the UDP listener.
+func (s *Server) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+func (s *Server) close() {
+	s.cancel()
+	_ = s.pc.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	chunks := newAssembler(s.conf.ChunkTimeout, s.conf.MaxMessageSize)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		// wakes up to drop incomplete messages without traffic
+		_ = s.pc.SetReadDeadline(time.Now().Add(s.conf.ChunkTimeout))
+		n, _, err := s.pc.ReadFrom(buf)
+		if n := chunks.expire(time.Now()); n > 0 {
+			metrics.InputRecords.WithLabelValues(Input, input.Incomplete).Add(float64(n))
+		}
+		var nErr net.Error
+		if errors.As(err, &nErr) && nErr.Timeout() {
+			continue
+		}
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Err(err).Msg("can not read gelf datagram")
+			}
+			return
+		}
+		data := buf[:n]
+		if IsChunk(data) {
+			if data, err = chunks.add(data, time.Now()); err != nil || data == nil {
+				s.malformed(err)
+				continue
+			}
+		}
+		s.handle(data)
+	}
+}
+
+// handle writes a received message.
+func (s *Server) handle(data []byte) {
+	m, err := Decode(data, s.conf.MaxMessageSize)
+	if err != nil {
+		s.malformed(err)
+		return
+	}
+	tag := m.Tag(s.conf.IndexField)
+	if err := s.sink.Ingest(s.ctx, Input, tag, m.Stream(), m.Fields, m.Timestamp); err != nil && s.ctx.Err() == nil {
+		log.Warn().Err(err).Str("tag", tag).Msg("gelf message rejected")
+	}
+}
+
+// malformed counts a message which could not be read, if err is not nil.
+func (s *Server) malformed(err error) {
+	if err == nil {
+		return
+	}
+	metrics.InputRecords.WithLabelValues(Input, input.Malformed).Inc()
+	log.Debug().Err(err).Msg("malformed gelf message")
+}
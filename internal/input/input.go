@@ -0,0 +1,43 @@
+// Package input holds what the listeners of other protocols than HTTP share.
+package input
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Results of the records received by listeners in metrics.
+const (
+	Accepted = "accepted"
+	Rejected = "rejected"
+	// Malformed records could not be decoded.
+	Malformed = "malformed"
+	// Incomplete records were split into parts which did not all arrive.
+	Incomplete = "incomplete"
+)
+
+// Sink writes records received by a listener.
+type Sink interface {
+	// Ingest writes the record with the tag, ts is the time it was logged if
+	// known. Stream names the source of the record, lines of one stream may be
+	// joined into multiline events.
+	Ingest(ctx context.Context, input, tag, stream string, doc map[string]interface{}, ts time.Time) error
+}
+
+// Tag returns the tag of records named by the sender, def if the name is
+// empty. Characters which are not allowed in index names are replaced by
+// dashes, so postfix/smtpd becomes postfix-smtpd.
+func Tag(name, def string) string {
+	tag := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '-'
+	}, name)
+	if strings.Trim(tag, "-") == "" {
+		return def
+	}
+	return tag
+}
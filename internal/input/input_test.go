@@ -0,0 +1,22 @@
+package input
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type InputUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunInputUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(InputUnitTestSuite))
+}
+
+func (s *InputUnitTestSuite) Test_Tag() {
+	s.Equal("sshd", Tag("sshd", "syslog"))
+	s.Equal("postfix-smtpd", Tag("postfix/smtpd", "syslog"))
+	s.Equal("a--b", Tag("a__b", "syslog"))
+	s.Equal("syslog", Tag("", "syslog"))
+	s.Equal("syslog", Tag("//", "syslog"))
+}
@@ -0,0 +1,239 @@
+// Package loki decodes the push requests of the Grafana Loki API, sent by
+// Promtail and the Grafana Agent.
+package loki
+
+import (
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/protobuf"
+	"github.com/valyala/fastjson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMalformed is returned for push requests which can not be decoded.
+var ErrMalformed = errors.New("malformed push request")
+
+// Stream is a stream of log entries sharing the same labels.
+type Stream struct {
+	Labels  map[string]string
+	Entries []Entry
+}
+
+// Entry is a log line of a stream.
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+	// Metadata is the structured metadata of the entry.
+	Metadata map[string]string
+}
+
+// IsJSON reports whether the content type is the JSON encoding, push
+// requests of other types are snappy compressed protobuf.
+func IsJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json")
+}
+
+// Decode reads the streams of a push request, a JSON one if the content
+// type is application/json and a protobuf one otherwise. Protobuf push
+// requests are snappy compressed, callers decompress them first.
+func Decode(body []byte, contentType string) ([]Stream, error) {
+	if IsJSON(contentType) {
+		return decodeJSON(body)
+	}
+	return decodeProto(body)
+}
+
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// decodeJSON reads {"streams":[{"stream":{...},"values":[["<ns>","line",{...}]]}]}.
+func decodeJSON(body []byte) ([]Stream, error) {
+	v, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var res []Stream
+	for _, s := range v.GetArray("streams") {
+		st := Stream{Labels: map[string]string{}}
+		o, err := s.Get("stream").Object()
+		if err != nil {
+			return nil, malformed("stream labels must be an object")
+		}
+		o.Visit(func(key []byte, v *fastjson.Value) {
+			st.Labels[string(key)] = string(v.GetStringBytes())
+		})
+		for _, val := range s.GetArray("values") {
+			e, err := decodeJSONEntry(val)
+			if err != nil {
+				return nil, err
+			}
+			st.Entries = append(st.Entries, e)
+		}
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+func decodeJSONEntry(v *fastjson.Value) (Entry, error) {
+	e := Entry{}
+	vals, err := v.Array()
+	if err != nil || len(vals) < 2 {
+		return e, malformed("an entry must be an array of timestamp and line")
+	}
+	ns, err := strconv.ParseInt(string(vals[0].GetStringBytes()), 10, 64)
+	if err != nil {
+		return e, malformed("invalid timestamp %s", vals[0])
+	}
+	e.Timestamp = time.Unix(0, ns)
+	line, err := vals[1].StringBytes()
+	if err != nil {
+		return e, malformed("the line of an entry must be a string")
+	}
+	e.Line = string(line)
+	if len(vals) > 2 {
+		o, err := vals[2].Object()
+		if err != nil {
+			return e, malformed("structured metadata must be an object")
+		}
+		e.Metadata = map[string]string{}
+		o.Visit(func(key []byte, v *fastjson.Value) {
+			e.Metadata[string(key)] = string(v.GetStringBytes())
+		})
+	}
+	return e, nil
+}
+
+// decodeProto reads a logproto.PushRequest:
+//
+//	PushRequest  { repeated Stream streams = 1; }
+//	Stream       { string labels = 1; repeated Entry entries = 2; }
+//	Entry        { Timestamp timestamp = 1; string line = 2; repeated LabelPair structuredMetadata = 3; }
+//	Timestamp    { int64 seconds = 1; int32 nanos = 2; }
+//	LabelPair    { string name = 1; string value = 2; }
+func decodeProto(data []byte) ([]Stream, error) {
+	var res []Stream
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		if !f.IsBytes(1) {
+			return nil
+		}
+		st, err := decodeProtoStream(f.Data)
+		res = append(res, st)
+		return err
+	})
+	if errors.Is(err, protobuf.ErrMalformed) {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return res, err
+}
+
+func decodeProtoStream(data []byte) (Stream, error) {
+	st := Stream{}
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			labels, err := ParseLabels(string(f.Data))
+			st.Labels = labels
+			return err
+		case f.IsBytes(2):
+			e, err := decodeProtoEntry(f.Data)
+			st.Entries = append(st.Entries, e)
+			return err
+		}
+		return nil
+	})
+	return st, err
+}
+
+func decodeProtoEntry(data []byte) (Entry, error) {
+	e := Entry{}
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			var sec, nsec int64
+			err := protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				switch {
+				case f.IsVarint(1):
+					sec = int64(f.Value)
+				case f.IsVarint(2):
+					nsec = int64(int32(f.Value))
+				}
+				return nil
+			})
+			e.Timestamp = time.Unix(sec, nsec)
+			return err
+		case f.IsBytes(2):
+			e.Line = string(f.Data)
+		case f.IsBytes(3):
+			var name, value string
+			err := protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				switch {
+				case f.IsBytes(1):
+					name = string(f.Data)
+				case f.IsBytes(2):
+					value = string(f.Data)
+				}
+				return nil
+			})
+			if e.Metadata == nil {
+				e.Metadata = map[string]string{}
+			}
+			e.Metadata[name] = value
+			return err
+		}
+		return nil
+	})
+	return e, err
+}
+
+// ParseLabels reads labels in the Prometheus format, {job="app", level="info"}.
+func ParseLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, malformed("labels %q must be enclosed in braces", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	res := map[string]string{}
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, malformed("label without value in %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimSpace(s[eq+1:])
+		end := quoteEnd(s)
+		if end < 0 {
+			return nil, malformed("unquoted value of label %s", name)
+		}
+		value, err := strconv.Unquote(s[:end])
+		if err != nil {
+			return nil, malformed("invalid value of label %s: %v", name, err)
+		}
+		res[name] = value
+		s = strings.TrimSpace(s[end:])
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if s != "" {
+			return nil, malformed("expected a comma after label %s", name)
+		}
+	}
+	return res, nil
+}
+
+// quoteEnd returns the index after the double quoted string s starts with, -1 if there is none.
+func quoteEnd(s string) int {
+	if !strings.HasPrefix(s, `"`) {
+		return -1
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,90 @@
+package loki
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/encoding/protowire"
+	"testing"
+	"time"
+)
+
+type LokiUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunLokiUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(LokiUnitTestSuite))
+}
+
+func (s *LokiUnitTestSuite) Test_Decode_JSON() {
+	streams, err := Decode([]byte(`{"streams":[{"stream":{"job":"app","level":"info"},
+		"values":[["1600000000000000001","first"],["1600000001000000000","second",{"trace_id":"abc"}]]}]}`),
+		"application/json")
+	s.NoError(err)
+	s.Equal([]Stream{{
+		Labels: map[string]string{"job": "app", "level": "info"},
+		Entries: []Entry{
+			{Timestamp: time.Unix(0, 1600000000000000001), Line: "first"},
+			{Timestamp: time.Unix(1600000001, 0), Line: "second", Metadata: map[string]string{"trace_id": "abc"}},
+		},
+	}}, streams)
+
+	_, err = Decode([]byte(`{"streams":[{"stream":{"job":"app"},"values":[["now","line"]]}]}`), "application/json")
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+// pushRequest encodes a logproto.PushRequest with one stream.
+func pushRequest(labels string, sec int64, nsec int32, line string) []byte {
+	var ts, entry, stream, req []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(sec))
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(nsec))
+	var meta []byte
+	meta = protowire.AppendTag(meta, 1, protowire.BytesType)
+	meta = protowire.AppendString(meta, "trace_id")
+	meta = protowire.AppendTag(meta, 2, protowire.BytesType)
+	meta = protowire.AppendString(meta, "abc")
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, ts)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, line)
+	entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, meta)
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, labels)
+	stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+	stream = protowire.AppendBytes(stream, entry)
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 42)
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, stream)
+	return req
+}
+
+func (s *LokiUnitTestSuite) Test_Decode_Protobuf() {
+	streams, err := Decode(pushRequest(`{job="app", msg="a \"quoted\", value"}`, 1600000000, 5, "first"),
+		"application/x-protobuf")
+	s.NoError(err)
+	s.Equal([]Stream{{
+		Labels: map[string]string{"job": "app", "msg": `a "quoted", value`},
+		Entries: []Entry{
+			{Timestamp: time.Unix(1600000000, 5), Line: "first", Metadata: map[string]string{"trace_id": "abc"}},
+		},
+	}}, streams)
+
+	_, err = Decode([]byte{0x0a, 0x05}, "application/x-protobuf")
+	s.True(errors.Is(err, ErrMalformed))
+	_, err = Decode(pushRequest(`job="app"`, 0, 0, "line"), "application/x-protobuf")
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+func (s *LokiUnitTestSuite) Test_ParseLabels() {
+	labels, err := ParseLabels(`{}`)
+	s.NoError(err)
+	s.Empty(labels)
+	for _, invalid := range []string{`{job}`, `{job=app}`, `{job="app" level="info"}`, `{job="app}`} {
+		_, err = ParseLabels(invalid)
+		s.True(errors.Is(err, ErrMalformed), invalid)
+	}
+}
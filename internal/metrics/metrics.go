@@ -0,0 +1,181 @@
+// Package metrics contains the Prometheus collectors of the adapter.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ml "github.com/senyast4745/meilisearch-go"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"strconv"
+)
+
+const namespace = "logdb"
+
+var (
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by response status and tag, the tag of accepted requests only.",
+	}, []string{"status", "tag"})
+
+	IngestedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingested_bytes_total",
+		Help:      "Bytes of log records accepted for ingestion by tag.",
+	}, []string{"tag"})
+
+	SaveDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "save_duration_seconds",
+		Help:      "Latency of saving a record to Meilisearch.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"result"})
+
+	RejectedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_records_total",
+		Help:      "Records rejected by validation before ingestion by reason.",
+	}, []string{"reason"})
+
+	DbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "meilisearch_errors_total",
+		Help:      "Failed Meilisearch calls by type.",
+	}, []string{"type"})
+
+	DbConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_connections_in_use",
+		Help:      "Occupied slots of the database connections semaphore.",
+	})
+
+	DbConnectionsMax = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_connections_max",
+		Help:      "Size of the database connections semaphore.",
+	})
+
+	HandledErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handled_errors_total",
+		Help:      "Errors received by the error handler by class.",
+	}, []string{"class"})
+
+	ErrorAction = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "error_action",
+		Help:      "Action of the error handler: 0 none, 1 degrade, 2 shed, 3 exit.",
+	})
+
+	SpooledRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spooled_records_total",
+		Help:      "Records written to the spool because Meilisearch could not take them.",
+	})
+
+	BreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "State of the Meilisearch circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	BreakerRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "breaker_rejections_total",
+		Help:      "Meilisearch calls rejected by the open circuit breaker.",
+	})
+
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected API requests by reason.",
+	}, []string{"reason"})
+
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Requests rejected by a tenant quota.",
+	}, []string{"tenant", "quota"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by a rate limit or the database connections semaphore by limit.",
+	}, []string{"limit"})
+
+	RateLimitBuckets = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_buckets",
+		Help:      "Token buckets kept for rate limited clients.",
+	})
+
+	IndexCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "index_cache_size",
+		Help:      "Indexes known by the adapter without asking Meilisearch.",
+	})
+
+	CompressedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compressed_request_bytes_total",
+		Help:      "Bytes of compressed request bodies by content encoding.",
+	}, []string{"encoding"})
+
+	DecompressedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decompressed_request_bytes_total",
+		Help:      "Bytes of compressed request bodies once decompressed by content encoding.",
+	}, []string{"encoding"})
+
+	ParseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_errors_total",
+		Help:      "Plain text lines the parser of their tag could not read by index.",
+	}, []string{"index"})
+
+	InputRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "input_records_total",
+		Help:      "Records received by the listeners of other protocols than HTTP by result.",
+	}, []string{"input", "result"})
+)
+
+// Handler serves the metrics of the default registry in the Prometheus text format.
+func Handler() fasthttp.RequestHandler {
+	return fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+}
+
+// ObserveDbError counts a failed Meilisearch call.
+func ObserveDbError(err error) {
+	if err == nil {
+		return
+	}
+	DbErrors.WithLabelValues(DbErrorType(err)).Inc()
+}
+
+// DbErrorType returns a short low cardinality description of a Meilisearch client error.
+func DbErrorType(err error) string {
+	cliErr, ok := err.(*ml.Error)
+	if !ok {
+		return "other"
+	}
+	switch cliErr.ErrCode {
+	case ml.ErrCodeMarshalRequest:
+		return "marshal_request"
+	case ml.ErrCodeRequestCreation:
+		return "request_creation"
+	case ml.ErrCodeRequestExecution:
+		return "request_execution"
+	case ml.ErrCodeResponseStatusCode:
+		return "status_" + strconv.Itoa(cliErr.StatusCode)
+	case ml.ErrCodeResponseReadBody:
+		return "response_read_body"
+	case ml.ErrCodeResponseUnmarshalBody:
+		return "response_unmarshal_body"
+	case ml.ErrCodeURLParsing:
+		return "url_parsing"
+	}
+	return "unknown"
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"fmt"
+	ml "github.com/senyast4745/meilisearch-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestDbErrorType(t *testing.T) {
+	assert.Equal(t, "other", DbErrorType(fmt.Errorf("test error")))
+	assert.Equal(t, "request_execution", DbErrorType(&ml.Error{ErrCode: ml.ErrCodeRequestExecution}))
+	assert.Equal(t, "status_500", DbErrorType(&ml.Error{
+		ErrCode:    ml.ErrCodeResponseStatusCode,
+		StatusCode: http.StatusInternalServerError,
+	}))
+}
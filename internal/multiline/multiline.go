@@ -0,0 +1,184 @@
+// Package multiline joins the lines of one event, such as a stack trace,
+// which line-based inputs receive as separate lines.
+package multiline
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLines is the max line count of an event if the rule sets none.
+	DefaultMaxLines = 500
+	// DefaultTimeout is the time an event waits for more lines if the rule sets none.
+	DefaultTimeout = time.Second
+	// checkInterval is the interval events are checked for their timeout.
+	checkInterval = 100 * time.Millisecond
+)
+
+// Rule tells which lines of a stream begin an event. A line matching Start
+// begins an event. Otherwise, with a Continuation pattern, matching lines
+// are appended to the current event and the others begin one; without it
+// every line is appended.
+type Rule struct {
+	Start        *regexp.Regexp
+	Continuation *regexp.Regexp
+	// MaxLines flushes events with that many lines.
+	MaxLines int
+	// Timeout flushes events which got no line for that long.
+	Timeout time.Duration
+}
+
+// NewRule compiles the patterns of a rule, one of them is required.
+func NewRule(start, continuation string, maxLines int, timeout time.Duration) (*Rule, error) {
+	if start == "" && continuation == "" {
+		return nil, errors.New("multiline rule requires a start or a continuation pattern")
+	}
+	if maxLines < 0 || timeout < 0 {
+		return nil, errors.New("multiline max lines and timeout must not be negative")
+	}
+	r := &Rule{MaxLines: maxLines, Timeout: timeout}
+	if r.MaxLines == 0 {
+		r.MaxLines = DefaultMaxLines
+	}
+	if r.Timeout == 0 {
+		r.Timeout = DefaultTimeout
+	}
+	var err error
+	if start != "" {
+		if r.Start, err = regexp.Compile(start); err != nil {
+			return nil, err
+		}
+	}
+	if continuation != "" {
+		if r.Continuation, err = regexp.Compile(continuation); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// begins reports whether the line begins an event.
+func (r *Rule) begins(line string) bool {
+	if r.Start != nil && r.Start.MatchString(line) {
+		return true
+	}
+	return r.Continuation != nil && !r.Continuation.MatchString(line)
+}
+
+// Event is the lines of one event of a stream.
+type Event struct {
+	Lines []string
+	// Data is the value added with the first line.
+	Data interface{}
+
+	rule *Rule
+	last time.Time
+}
+
+// Message returns the lines joined by newlines.
+func (e *Event) Message() string {
+	return strings.Join(e.Lines, "\n")
+}
+
+// Aggregator joins the lines of streams into events and hands every event
+// to the flush function once it is complete: when a line begins the next
+// event, the event has the max line count or it got no line until the
+// timeout of its rule.
+type Aggregator struct {
+	lock    sync.Mutex
+	pending map[string]*Event
+	flush   func(*Event)
+	now     func() time.Time
+}
+
+// NewAggregator returns an aggregator calling flush with complete events.
+func NewAggregator(flush func(*Event)) *Aggregator {
+	return &Aggregator{pending: map[string]*Event{}, flush: flush, now: time.Now}
+}
+
+// Add appends the line to the event of the stream or begins a new event,
+// data is kept with its first line.
+func (a *Aggregator) Add(stream string, rule *Rule, line string, data interface{}) {
+	var done []*Event
+	a.lock.Lock()
+	e := a.pending[stream]
+	if e != nil && rule.begins(line) {
+		done = append(done, e)
+		e = nil
+	}
+	if e == nil {
+		e = &Event{Data: data, rule: rule}
+		a.pending[stream] = e
+	}
+	e.Lines = append(e.Lines, line)
+	e.last = a.now()
+	if len(e.Lines) >= rule.MaxLines {
+		done = append(done, e)
+		delete(a.pending, stream)
+	}
+	a.lock.Unlock()
+	for _, e := range done {
+		a.flush(e)
+	}
+}
+
+// Run flushes the events which reached their timeout until ctx is done.
+func (a *Aggregator) Run(ctx context.Context) {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			a.expire()
+		}
+	}
+}
+
+func (a *Aggregator) expire() {
+	var done []*Event
+	now := a.now()
+	a.lock.Lock()
+	for stream, e := range a.pending {
+		if now.Sub(e.last) >= e.rule.Timeout {
+			done = append(done, e)
+			delete(a.pending, stream)
+		}
+	}
+	a.lock.Unlock()
+	for _, e := range done {
+		a.flush(e)
+	}
+}
+
+// Flush hands all pending events to the flush function.
+func (a *Aggregator) Flush() {
+	a.lock.Lock()
+	done := make([]*Event, 0, len(a.pending))
+	for stream, e := range a.pending {
+		done = append(done, e)
+		delete(a.pending, stream)
+	}
+	a.lock.Unlock()
+	for _, e := range done {
+		a.flush(e)
+	}
+}
+
+// Join groups the lines of one stream into the lines of its events, the
+// last event ends with the lines.
+func Join(rule *Rule, lines []string) [][]string {
+	var events [][]string
+	a := NewAggregator(func(e *Event) { events = append(events, e.Lines) })
+	for _, line := range lines {
+		a.Add("", rule, line, nil)
+	}
+	a.Flush()
+	return events
+}
@@ -0,0 +1,132 @@
+package multiline
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+type MultilineUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunMultilineUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(MultilineUnitTestSuite))
+}
+
+var javaTrace = []string{
+	"2020-10-10 13:55:36 ERROR request failed",
+	"java.lang.IllegalStateException: closed",
+	"\tat com.example.Db.query(Db.java:42)",
+	"\tat com.example.Api.handle(Api.java:7)",
+	"Caused by: java.io.IOException: reset",
+	"\t... 2 more",
+	"2020-10-10 13:55:37 INFO retrying",
+}
+
+func (s *MultilineUnitTestSuite) Test_Join_Start() {
+	r, err := NewRule(`^\d{4}-\d{2}-\d{2} `, "", 0, 0)
+	s.NoError(err)
+	s.Equal([][]string{javaTrace[:6], javaTrace[6:]}, Join(r, javaTrace))
+
+	// lines before the first start line are an event of their own
+	s.Equal([][]string{{"orphan"}, javaTrace[:6]}, Join(r, append([]string{"orphan"}, javaTrace[:6]...)))
+}
+
+func (s *MultilineUnitTestSuite) Test_Join_Continuation() {
+	r, err := NewRule("", `^\s|^Caused by:`, 0, 0)
+	s.NoError(err)
+	s.Equal([][]string{javaTrace[:1], javaTrace[1:6], javaTrace[6:]}, Join(r, javaTrace))
+
+	python := []string{
+		"Traceback (most recent call last):",
+		`  File "app.py", line 3, in <module>`,
+		"    main()",
+		"ValueError: bad",
+	}
+	r, err = NewRule(`^Traceback`, `^\s`, 0, 0)
+	s.NoError(err)
+	s.Equal([][]string{python[:3], python[3:]}, Join(r, python))
+}
+
+func (s *MultilineUnitTestSuite) Test_Join_MaxLines() {
+	r, err := NewRule("", `^\s`, 2, 0)
+	s.NoError(err)
+	s.Equal([][]string{{"a", " 1"}, {" 2", " 3"}, {"b"}}, Join(r, []string{"a", " 1", " 2", " 3", "b"}))
+}
+
+func (s *MultilineUnitTestSuite) Test_NewRule() {
+	r, err := NewRule("^x", "", 0, 0)
+	s.NoError(err)
+	s.Equal(DefaultMaxLines, r.MaxLines)
+	s.Equal(DefaultTimeout, r.Timeout)
+
+	_, err = NewRule("", "", 0, 0)
+	s.Error(err)
+	_, err = NewRule("(", "", 0, 0)
+	s.Error(err)
+	_, err = NewRule("", "(", 0, 0)
+	s.Error(err)
+	_, err = NewRule("^x", "", -1, 0)
+	s.Error(err)
+}
+
+func (s *MultilineUnitTestSuite) Test_Aggregator() {
+	r, err := NewRule("", `^\s`, 0, time.Second)
+	s.NoError(err)
+	now := time.Now()
+	var flushed []*Event
+	a := NewAggregator(func(e *Event) { flushed = append(flushed, e) })
+	a.now = func() time.Time { return now }
+
+	// streams are joined separately
+	a.Add("a", r, "first", 1)
+	a.Add("b", r, "other", 2)
+	a.Add("a", r, "  more", 3)
+	s.Empty(flushed)
+	a.Add("a", r, "second", 4)
+	s.Len(flushed, 1)
+	s.Equal("first\n  more", flushed[0].Message())
+	s.Equal(1, flushed[0].Data)
+
+	now = now.Add(500 * time.Millisecond)
+	a.Add("b", r, "  continued", 5)
+	now = now.Add(600 * time.Millisecond)
+	a.expire()
+	s.Len(flushed, 2)
+	s.Equal([]string{"second"}, flushed[1].Lines)
+	s.Equal(4, flushed[1].Data)
+
+	a.Flush()
+	s.Len(flushed, 3)
+	s.Equal("other\n  continued", flushed[2].Message())
+	s.Empty(a.pending)
+}
+
+func (s *MultilineUnitTestSuite) Test_Aggregator_Run() {
+	r, err := NewRule(`^\S`, "", 0, 10*time.Millisecond)
+	s.NoError(err)
+	var lock sync.Mutex
+	var flushed []string
+	a := NewAggregator(func(e *Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		flushed = append(flushed, e.Message())
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+
+	a.Add("a", r, "panic: boom", nil)
+	a.Add("a", r, "  goroutine 1", nil)
+	s.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(flushed) == 1
+	}, time.Second, 10*time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	s.Equal("panic: boom\n  goroutine 1", flushed[0])
+}
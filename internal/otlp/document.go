@@ -0,0 +1,76 @@
+package otlp
+
+import "time"
+
+// Fields of the documents of log records.
+const (
+	ResourcePrefix   = "resource."
+	ScopePrefix      = "scope."
+	AttributesPrefix = "attributes."
+	MessageKey       = "message"
+	BodyKey          = "body"
+	SeverityKey      = "severity_text"
+	SeverityNumKey   = "severity_number"
+	TraceIdKey       = "trace_id"
+	SpanIdKey        = "span_id"
+	FlagsKey         = "flags"
+)
+
+// Document flattens the log record with its resource and scope into the
+// fields of a record. Nested attribute maps are flattened with dotted
+// keys, a string body is the message and any other body is kept as is.
+// The timestamp is not part of the document.
+func (l *Log) Document() map[string]interface{} {
+	doc := map[string]interface{}{}
+	flatten(doc, ResourcePrefix, l.Resource)
+	if l.Scope.Name != "" {
+		doc[ScopePrefix+"name"] = l.Scope.Name
+	}
+	if l.Scope.Version != "" {
+		doc[ScopePrefix+"version"] = l.Scope.Version
+	}
+	flatten(doc, ScopePrefix, l.Scope.Attributes)
+	flatten(doc, AttributesPrefix, l.Attributes)
+	switch body := l.Body.(type) {
+	case nil:
+	case string:
+		doc[MessageKey] = body
+	default:
+		doc[BodyKey] = body
+	}
+	if l.SeverityText != "" {
+		doc[SeverityKey] = l.SeverityText
+	}
+	if l.SeverityNumber != 0 {
+		doc[SeverityNumKey] = l.SeverityNumber
+	}
+	if l.TraceId != "" {
+		doc[TraceIdKey] = l.TraceId
+	}
+	if l.SpanId != "" {
+		doc[SpanIdKey] = l.SpanId
+	}
+	if l.Flags != 0 {
+		doc[FlagsKey] = l.Flags
+	}
+	return doc
+}
+
+// Timestamp returns when the record was logged, when it was observed if the
+// sender did not know, and the zero time if neither is set.
+func (l *Log) Timestamp() time.Time {
+	if !l.Time.IsZero() {
+		return l.Time
+	}
+	return l.ObservedTime
+}
+
+func flatten(doc map[string]interface{}, prefix string, attrs map[string]interface{}) {
+	for k, v := range attrs {
+		if m, ok := v.(map[string]interface{}); ok {
+			flatten(doc, prefix+k+".", m)
+			continue
+		}
+		doc[prefix+k] = v
+	}
+}
@@ -0,0 +1,164 @@
+package otlp
+
+import (
+	"fmt"
+	"github.com/valyala/fastjson"
+	"strconv"
+	"strings"
+)
+
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// decodeJSON reads the JSON encoding of an ExportLogsServiceRequest, which
+// uses the lowerCamelCase names of the protobuf fields, strings for 64 bit
+// integers and hex for trace and span ids.
+func decodeJSON(body []byte) ([]*Log, error) {
+	v, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var res []*Log
+	for _, rl := range v.GetArray("resourceLogs") {
+		resource, err := jsonAttributes(rl.GetArray("resource", "attributes"), 0)
+		if err != nil {
+			return nil, err
+		}
+		scopes := rl.GetArray("scopeLogs")
+		if scopes == nil {
+			scopes = rl.GetArray("instrumentationLibraryLogs")
+		}
+		for _, sl := range scopes {
+			sv := sl.Get("scope")
+			if sv == nil {
+				sv = sl.Get("instrumentationLibrary")
+			}
+			scope := Scope{}
+			if sv != nil {
+				scope.Name = string(sv.GetStringBytes("name"))
+				scope.Version = string(sv.GetStringBytes("version"))
+				if attrs := sv.GetArray("attributes"); attrs != nil {
+					if scope.Attributes, err = jsonAttributes(attrs, 0); err != nil {
+						return nil, err
+					}
+				}
+			}
+			for _, lr := range sl.GetArray("logRecords") {
+				l := &Log{Resource: resource, Scope: scope}
+				if err = decodeJSONRecord(lr, l); err != nil {
+					return nil, err
+				}
+				res = append(res, l)
+			}
+		}
+	}
+	return res, nil
+}
+
+func decodeJSONRecord(v *fastjson.Value, l *Log) error {
+	ns, err := jsonUint(v.Get("timeUnixNano"))
+	if err != nil {
+		return malformed("invalid timeUnixNano: %v", err)
+	}
+	l.Time = unixNano(ns)
+	if ns, err = jsonUint(v.Get("observedTimeUnixNano")); err != nil {
+		return malformed("invalid observedTimeUnixNano: %v", err)
+	}
+	l.ObservedTime = unixNano(ns)
+	l.SeverityNumber = int32(v.GetInt("severityNumber"))
+	l.SeverityText = string(v.GetStringBytes("severityText"))
+	l.Flags = uint32(v.GetUint("flags"))
+	l.TraceId = strings.ToLower(string(v.GetStringBytes("traceId")))
+	l.SpanId = strings.ToLower(string(v.GetStringBytes("spanId")))
+	if body := v.Get("body"); body != nil {
+		if l.Body, err = jsonAnyValue(body, 0); err != nil {
+			return err
+		}
+	}
+	if attrs := v.GetArray("attributes"); attrs != nil {
+		l.Attributes, err = jsonAttributes(attrs, 0)
+	}
+	return err
+}
+
+func jsonAttributes(kvs []*fastjson.Value, depth int) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		v, err := jsonAnyValue(kv.Get("value"), depth)
+		if err != nil {
+			return nil, err
+		}
+		res[string(kv.GetStringBytes("key"))] = v
+	}
+	return res, nil
+}
+
+func jsonAnyValue(v *fastjson.Value, depth int) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if depth >= maxDepth {
+		return nil, malformed("values are nested deeper than %d levels", maxDepth)
+	}
+	o, err := v.Object()
+	if err != nil {
+		return nil, malformed("a value must be an object")
+	}
+	var res interface{}
+	o.Visit(func(key []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+		switch string(key) {
+		case "stringValue":
+			res = string(v.GetStringBytes())
+		case "boolValue":
+			res = v.GetBool()
+		case "intValue":
+			var n uint64
+			n, err = jsonUint(v)
+			res = int64(n)
+		case "doubleValue":
+			res = v.GetFloat64()
+		case "bytesValue":
+			res = string(v.GetStringBytes())
+		case "arrayValue":
+			values := []interface{}{}
+			for _, item := range v.GetArray("values") {
+				var iv interface{}
+				if iv, err = jsonAnyValue(item, depth+1); err != nil {
+					return
+				}
+				values = append(values, iv)
+			}
+			res = values
+		case "kvlistValue":
+			res, err = jsonAttributes(v.GetArray("values"), depth+1)
+		}
+	})
+	return res, err
+}
+
+// jsonUint reads a 64 bit integer encoded as string or number, 0 if v is nil.
+// Negative numbers keep their two's complement.
+func jsonUint(v *fastjson.Value) (uint64, error) {
+	switch {
+	case v == nil:
+		return 0, nil
+	case v.Type() == fastjson.TypeString:
+		s := string(v.GetStringBytes())
+		if strings.HasPrefix(s, "-") {
+			n, err := strconv.ParseInt(s, 10, 64)
+			return uint64(n), err
+		}
+		return strconv.ParseUint(s, 10, 64)
+	case v.Type() == fastjson.TypeNumber:
+		if n, err := v.Uint64(); err == nil {
+			return n, nil
+		}
+		n, err := v.Int64()
+		return uint64(n), err
+	}
+	return 0, fmt.Errorf("%s is not an integer", v)
+}
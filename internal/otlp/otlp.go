@@ -0,0 +1,291 @@
+// Package otlp decodes the log export requests of the OpenTelemetry
+// protocol over HTTP, in the protobuf and the JSON encoding.
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/protobuf"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrMalformed is returned for export requests which can not be decoded.
+var ErrMalformed = errors.New("malformed export request")
+
+// maxDepth limits the nesting of array and kvlist values.
+const maxDepth = 64
+
+// Content types of the encodings.
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// Log is a log record with the resource and the scope which emitted it.
+// Attribute values are strings, bools, int64s, float64s, slices and maps of
+// them, bytes are base64 strings.
+type Log struct {
+	Resource       map[string]interface{}
+	Scope          Scope
+	Time           time.Time
+	ObservedTime   time.Time
+	SeverityNumber int32
+	SeverityText   string
+	Body           interface{}
+	Attributes     map[string]interface{}
+	// TraceId and SpanId are hex encoded, empty if the record has none.
+	TraceId string
+	SpanId  string
+	Flags   uint32
+}
+
+// Scope is the instrumentation scope of a log record.
+type Scope struct {
+	Name       string
+	Version    string
+	Attributes map[string]interface{}
+}
+
+// IsJSON reports whether the content type is the JSON encoding.
+func IsJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, ContentTypeJSON)
+}
+
+// Decode reads the log records of an ExportLogsServiceRequest.
+func Decode(body []byte, contentType string) ([]*Log, error) {
+	if IsJSON(contentType) {
+		return decodeJSON(body)
+	}
+	logs, err := decodeProto(body)
+	if errors.Is(err, protobuf.ErrMalformed) {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return logs, err
+}
+
+// Response returns the ExportLogsServiceResponse in the encoding of the
+// request, with a partial success if records were rejected.
+func Response(contentType string, rejected int64, message string) []byte {
+	if IsJSON(contentType) {
+		if rejected == 0 {
+			return []byte("{}")
+		}
+		return []byte(fmt.Sprintf(`{"partialSuccess":{"rejectedLogRecords":"%d","errorMessage":%q}}`, rejected, message))
+	}
+	if rejected == 0 {
+		return nil
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, message)
+	res := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(res, partial)
+}
+
+// decodeProto reads the messages of opentelemetry/proto/collector/logs/v1:
+//
+//	ExportLogsServiceRequest { repeated ResourceLogs resource_logs = 1; }
+//	ResourceLogs  { Resource resource = 1; repeated ScopeLogs scope_logs = 2; }
+//	Resource      { repeated KeyValue attributes = 1; }
+//	ScopeLogs     { InstrumentationScope scope = 1; repeated LogRecord log_records = 2; }
+//	InstrumentationScope { string name = 1; string version = 2; repeated KeyValue attributes = 3; }
+//	LogRecord     { fixed64 time_unix_nano = 1; fixed64 observed_time_unix_nano = 11;
+//	                SeverityNumber severity_number = 2; string severity_text = 3; AnyValue body = 5;
+//	                repeated KeyValue attributes = 6; fixed32 flags = 8; bytes trace_id = 9; bytes span_id = 10; }
+//
+// The scope_logs of old senders are the instrumentation_library_logs = 1000.
+func decodeProto(data []byte) ([]*Log, error) {
+	var res []*Log
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		if !f.IsBytes(1) {
+			return nil
+		}
+		logs, err := decodeResourceLogs(f.Data)
+		res = append(res, logs...)
+		return err
+	})
+	return res, err
+}
+
+func decodeResourceLogs(data []byte) ([]*Log, error) {
+	resource := map[string]interface{}{}
+	var scopes [][]byte
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			return protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				if f.IsBytes(1) {
+					return decodeKeyValue(f.Data, resource, 0)
+				}
+				return nil
+			})
+		case f.IsBytes(2), f.IsBytes(1000):
+			scopes = append(scopes, f.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []*Log
+	for _, data := range scopes {
+		logs, err := decodeScopeLogs(data, resource)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, logs...)
+	}
+	return res, nil
+}
+
+func decodeScopeLogs(data []byte, resource map[string]interface{}) ([]*Log, error) {
+	scope := Scope{}
+	var records [][]byte
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			return protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				switch {
+				case f.IsBytes(1):
+					scope.Name = string(f.Data)
+				case f.IsBytes(2):
+					scope.Version = string(f.Data)
+				case f.IsBytes(3):
+					if scope.Attributes == nil {
+						scope.Attributes = map[string]interface{}{}
+					}
+					return decodeKeyValue(f.Data, scope.Attributes, 0)
+				}
+				return nil
+			})
+		case f.IsBytes(2):
+			records = append(records, f.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Log, 0, len(records))
+	for _, data := range records {
+		l := &Log{Resource: resource, Scope: scope}
+		if err := decodeLogRecord(data, l); err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+func decodeLogRecord(data []byte, l *Log) error {
+	return protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsFixed(1):
+			l.Time = unixNano(f.Value)
+		case f.IsFixed(11):
+			l.ObservedTime = unixNano(f.Value)
+		case f.IsVarint(2):
+			l.SeverityNumber = int32(f.Value)
+		case f.IsBytes(3):
+			l.SeverityText = string(f.Data)
+		case f.IsBytes(5):
+			v, err := decodeAnyValue(f.Data, 0)
+			l.Body = v
+			return err
+		case f.IsBytes(6):
+			if l.Attributes == nil {
+				l.Attributes = map[string]interface{}{}
+			}
+			return decodeKeyValue(f.Data, l.Attributes, 0)
+		case f.IsFixed(8):
+			l.Flags = uint32(f.Value)
+		case f.IsBytes(9):
+			l.TraceId = hex.EncodeToString(f.Data)
+		case f.IsBytes(10):
+			l.SpanId = hex.EncodeToString(f.Data)
+		}
+		return nil
+	})
+}
+
+// decodeKeyValue adds the KeyValue { string key = 1; AnyValue value = 2; } to m,
+// depth is the nesting of m.
+func decodeKeyValue(data []byte, m map[string]interface{}, depth int) error {
+	var key string
+	var value interface{}
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			key = string(f.Data)
+		case f.IsBytes(2):
+			v, err := decodeAnyValue(f.Data, depth)
+			value = v
+			return err
+		}
+		return nil
+	})
+	m[key] = value
+	return err
+}
+
+// decodeAnyValue reads the AnyValue { oneof value { string string_value = 1;
+// bool bool_value = 2; int64 int_value = 3; double double_value = 4;
+// ArrayValue array_value = 5; KeyValueList kvlist_value = 6; bytes bytes_value = 7; } }.
+// Arrays and kvlists nested deeper than maxDepth are malformed.
+func decodeAnyValue(data []byte, depth int) (interface{}, error) {
+	if depth >= maxDepth {
+		return nil, malformed("values are nested deeper than %d levels", maxDepth)
+	}
+	var res interface{}
+	err := protobuf.Fields(data, func(f *protobuf.Field) error {
+		switch {
+		case f.IsBytes(1):
+			res = string(f.Data)
+		case f.IsVarint(2):
+			res = f.Value != 0
+		case f.IsVarint(3):
+			res = int64(f.Value)
+		case f.IsFixed(4):
+			res = math.Float64frombits(f.Value)
+		case f.IsBytes(5):
+			values := []interface{}{}
+			err := protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				if !f.IsBytes(1) {
+					return nil
+				}
+				v, err := decodeAnyValue(f.Data, depth+1)
+				values = append(values, v)
+				return err
+			})
+			res = values
+			return err
+		case f.IsBytes(6):
+			values := map[string]interface{}{}
+			res = values
+			return protobuf.Fields(f.Data, func(f *protobuf.Field) error {
+				if f.IsBytes(1) {
+					return decodeKeyValue(f.Data, values, depth+1)
+				}
+				return nil
+			})
+		case f.IsBytes(7):
+			res = base64.StdEncoding.EncodeToString(f.Data)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func unixNano(ns uint64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns))
+}
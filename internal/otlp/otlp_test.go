@@ -0,0 +1,175 @@
+package otlp
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"testing"
+	"time"
+)
+
+type OtlpUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunOtlpUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(OtlpUnitTestSuite))
+}
+
+func message(fields ...func([]byte) []byte) []byte {
+	var res []byte
+	for _, f := range fields {
+		res = f(res)
+	}
+	return res
+}
+
+func bytesField(num protowire.Number, data []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), data)
+	}
+}
+
+func stringField(num protowire.Number, s string) func([]byte) []byte {
+	return bytesField(num, []byte(s))
+}
+
+func varintField(num protowire.Number, v uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+	}
+}
+
+func fixed64Field(num protowire.Number, v uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendFixed64(protowire.AppendTag(b, num, protowire.Fixed64Type), v)
+	}
+}
+
+func keyValue(key string, value []byte) func([]byte) []byte {
+	return bytesField(1, message(stringField(1, key), bytesField(2, value)))
+}
+
+func (s *OtlpUnitTestSuite) Test_Decode_Protobuf() {
+	record := message(
+		fixed64Field(1, 1600000000000000005),
+		varintField(2, 9),
+		stringField(3, "INFO"),
+		bytesField(5, message(stringField(1, "started"))),
+		bytesField(6, message(stringField(1, "http.status"), bytesField(2, message(varintField(3, 200))))),
+		bytesField(6, message(stringField(1, "ratio"), bytesField(2, message(fixed64Field(4, math.Float64bits(0.5)))))),
+		bytesField(6, message(stringField(1, "http"), bytesField(2, message(bytesField(6,
+			message(keyValue("method", message(stringField(1, "GET"))))))))),
+		bytesField(9, []byte{0xab, 0xcd}),
+		bytesField(10, []byte{0x01}),
+	)
+	scopeLogs := message(
+		bytesField(1, message(stringField(1, "logger"), stringField(2, "1.0"))),
+		bytesField(2, record),
+	)
+	resource := message(keyValue("service.name", message(stringField(1, "checkout"))))
+	req := message(bytesField(1, message(bytesField(1, resource), bytesField(2, scopeLogs))))
+
+	logs, err := Decode(req, ContentTypeProtobuf)
+	s.NoError(err)
+	s.Equal([]*Log{{
+		Resource:       map[string]interface{}{"service.name": "checkout"},
+		Scope:          Scope{Name: "logger", Version: "1.0"},
+		Time:           time.Unix(0, 1600000000000000005),
+		SeverityNumber: 9,
+		SeverityText:   "INFO",
+		Body:           "started",
+		Attributes: map[string]interface{}{
+			"http.status": int64(200),
+			"ratio":       0.5,
+			"http":        map[string]interface{}{"method": "GET"},
+		},
+		TraceId: "abcd",
+		SpanId:  "01",
+	}}, logs)
+	s.Equal(int64(1600000000), logs[0].Timestamp().Unix())
+	s.Equal(map[string]interface{}{
+		"resource.service.name":  "checkout",
+		"scope.name":             "logger",
+		"scope.version":          "1.0",
+		"attributes.http.status": int64(200),
+		"attributes.ratio":       0.5,
+		"attributes.http.method": "GET",
+		MessageKey:               "started",
+		SeverityKey:              "INFO",
+		SeverityNumKey:           int32(9),
+		TraceIdKey:               "abcd",
+		SpanIdKey:                "01",
+	}, logs[0].Document())
+
+	_, err = Decode([]byte{0x0a, 0x05}, ContentTypeProtobuf)
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+func (s *OtlpUnitTestSuite) Test_Decode_JSON() {
+	logs, err := Decode([]byte(`{"resourceLogs":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeLogs":[{"scope":{"name":"logger"},"logRecords":[{
+			"observedTimeUnixNano":"1600000000000000000",
+			"severityText":"ERROR",
+			"body":{"kvlistValue":{"values":[{"key":"event","value":{"stringValue":"failed"}}]}},
+			"attributes":[
+				{"key":"retries","value":{"intValue":"3"}},
+				{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"boolValue":true}]}}}],
+			"traceId":"5B8EFFF798038103D269B633813FC60C"}]}]}]}`), "application/json; charset=utf-8")
+	s.NoError(err)
+	s.Len(logs, 1)
+	s.Equal(int64(1600000000), logs[0].Timestamp().Unix())
+	s.Equal(map[string]interface{}{
+		"resource.service.name": "checkout",
+		"scope.name":            "logger",
+		"attributes.retries":    int64(3),
+		"attributes.tags":       []interface{}{"a", true},
+		BodyKey:                 map[string]interface{}{"event": "failed"},
+		SeverityKey:             "ERROR",
+		TraceIdKey:              "5b8efff798038103d269b633813fc60c",
+	}, logs[0].Document())
+
+	_, err = Decode([]byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"soon"}]}]}]}`), ContentTypeJSON)
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+func (s *OtlpUnitTestSuite) Test_Decode_Depth() {
+	nested := func(levels int) []byte {
+		value := message(stringField(1, "deep"))
+		for i := 0; i < levels; i++ {
+			value = message(bytesField(5, message(bytesField(1, value))))
+		}
+		return message(bytesField(1, message(bytesField(2, message(bytesField(2, message(bytesField(5, value))))))))
+	}
+	logs, err := Decode(nested(maxDepth-1), ContentTypeProtobuf)
+	s.NoError(err)
+	s.Len(logs, 1)
+	_, err = Decode(nested(maxDepth), ContentTypeProtobuf)
+	s.True(errors.Is(err, ErrMalformed))
+	_, err = Decode(nested(10*maxDepth), ContentTypeProtobuf)
+	s.True(errors.Is(err, ErrMalformed))
+
+	nestedJSON := func(levels int) []byte {
+		value := `{"stringValue":"deep"}`
+		for i := 0; i < levels; i++ {
+			value = `{"kvlistValue":{"values":[{"key":"k","value":` + value + `}]}}`
+		}
+		return []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":` + value + `}]}]}]}`)
+	}
+	logs, err = Decode(nestedJSON(maxDepth-1), ContentTypeJSON)
+	s.NoError(err)
+	s.Len(logs, 1)
+	_, err = Decode(nestedJSON(maxDepth), ContentTypeJSON)
+	s.True(errors.Is(err, ErrMalformed))
+}
+
+func (s *OtlpUnitTestSuite) Test_Response() {
+	s.Equal("{}", string(Response(ContentTypeJSON, 0, "")))
+	s.JSONEq(`{"partialSuccess":{"rejectedLogRecords":"2","errorMessage":"invalid"}}`,
+		string(Response(ContentTypeJSON, 2, "invalid")))
+	s.Empty(Response(ContentTypeProtobuf, 0, ""))
+	s.Equal(message(bytesField(1, message(varintField(1, 2), stringField(2, "invalid")))),
+		Response(ContentTypeProtobuf, 2, "invalid"))
+}
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxGrokDepth limits the nesting of grok patterns, deeper ones are recursive.
+const maxGrokDepth = 32
+
+// grokRef matches %{NAME}, %{NAME:field} and %{NAME:field:type} references.
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(int|float))?\}`)
+
+// grokPatterns are the built-in grok patterns. They follow the patterns of
+// Logstash, rewritten for RE2 which has neither lookarounds nor atomic groups.
+var grokPatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"EMAILADDRESS": `[a-zA-Z0-9._%+-]+@%{HOSTNAME}`,
+	"INT":          `[+-]?[0-9]+`,
+	"BASE10NUM":    `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"POSINT":       `\b[1-9][0-9]*\b`,
+	"NONNEGINT":    `\b[0-9]+\b`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]?[0-9])`,
+	"IPV6":     `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}(?:%[0-9A-Za-z]+)?`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"UNIXPATH":     `(?:/[\w%!$@:.,+~-]*)+`,
+	"PATH":         `%{UNIXPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]+`,
+	"URIPATHPARAM": `/\S*`,
+	"URI":          `%{URIPROTO}://\S+`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+
+	"SYSLOGTIMESTAMP": `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":            `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":      `%{PROG:program}(?:\[%{POSINT:pid:int}\])?`,
+	"SYSLOGHOST":      `%{IPORHOST}`,
+	"SYSLOGFACILITY":  `<%{NONNEGINT:facility:int}.%{NONNEGINT:priority:int}>`,
+	"SYSLOGBASE":      `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"SYSLOGLINE":      `%{SYSLOGBASE} ?%{GREEDYDATA:message}`,
+
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{HTTPDUSER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+	"HTTPD_COMMONLOG":   `%{COMMONAPACHELOG}`,
+	"HTTPD_COMBINEDLOG": `%{COMBINEDAPACHELOG}`,
+	"HTTPDERROR_DATE":   `%{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{YEAR}`,
+	"HTTPD_ERRORLOG":    `\[%{HTTPDERROR_DATE:timestamp}\] \[(?:%{WORD:module})?:%{LOGLEVEL:loglevel}\] \[pid %{POSINT:pid:int}(?::tid %{INT:tid:int})?\](?: \[client %{IPORHOST:clientip}(?::%{POSINT:clientport:int})?\])?(?: %{DATA:errorcode}:)? %{GREEDYDATA:message}`,
+
+	"NGINXACCESS":     `%{COMBINEDAPACHELOG}`,
+	"NGINXERROR_DATE": `%{YEAR}/%{MONTHNUM}/%{MONTHDAY} %{TIME}`,
+	"NGINXERROR":      `%{NGINXERROR_DATE:timestamp} \[%{LOGLEVEL:loglevel}\] %{POSINT:pid:int}#%{NONNEGINT:tid:int}: (?:\*%{NONNEGINT:connection:int} )?%{GREEDYDATA:message}`,
+}
+
+// capture is a field captured by a group of a pattern.
+type capture struct {
+	group int
+	field string
+	// typ converts the value to an int or a float, it is kept as string if empty.
+	typ string
+}
+
+// PatternParser reads the fields captured by a regular expression.
+type PatternParser struct {
+	re *regexp.Regexp
+	// captures are ordered by group, the first group matching a field sets it
+	captures []capture
+}
+
+// NewRegex returns a parser of the named groups of the regular expression.
+func NewRegex(pattern string) (*PatternParser, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &PatternParser{re: re}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			p.captures = append(p.captures, capture{group: i, field: name})
+		}
+	}
+	if len(p.captures) == 0 {
+		return nil, errors.New("pattern has no named groups")
+	}
+	return p, nil
+}
+
+// NewGrok returns a parser of the grok pattern. Custom patterns may be used
+// in it and replace the built-in ones.
+func NewGrok(pattern string, custom map[string]string) (*PatternParser, error) {
+	var captures []capture
+	expanded, err := expandGrok(pattern, custom, &captures, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+	p := &PatternParser{re: re}
+	for i, name := range re.SubexpNames() {
+		if n, ok := grokGroup(name); ok && n < len(captures) {
+			c := captures[n]
+			c.group = i
+			p.captures = append(p.captures, c)
+		} else if name != "" {
+			p.captures = append(p.captures, capture{group: i, field: name})
+		}
+	}
+	if len(p.captures) == 0 {
+		return nil, errors.New("pattern captures no fields")
+	}
+	return p, nil
+}
+
+// grokGroup returns the number of the capture of a group named by expandGrok.
+func grokGroup(name string) (int, bool) {
+	if !strings.HasPrefix(name, "grok_") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[len("grok_"):])
+	return n, err == nil
+}
+
+// expandGrok replaces the references of the pattern by their regular
+// expressions, references with a field by named groups.
+func expandGrok(pattern string, custom map[string]string, captures *[]capture, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", errors.New("grok patterns are recursive")
+	}
+	var err error
+	res := grokRef.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokRef.FindStringSubmatch(ref)
+		def, ok := custom[m[1]]
+		if !ok {
+			def, ok = grokPatterns[m[1]]
+		}
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", m[1])
+			return ""
+		}
+		var expanded string
+		if expanded, err = expandGrok(def, custom, captures, depth+1); err != nil {
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + expanded + ")"
+		}
+		*captures = append(*captures, capture{field: m[2], typ: m[3]})
+		return fmt.Sprintf("(?P<grok_%d>%s)", len(*captures)-1, expanded)
+	})
+	return res, err
+}
+
+func (p *PatternParser) Parse(line string) (map[string]interface{}, error) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return nil, ErrNoMatch
+	}
+	fields := make(map[string]interface{}, len(p.captures))
+	for _, c := range p.captures {
+		start, end := m[2*c.group], m[2*c.group+1]
+		if start < 0 {
+			continue
+		}
+		if _, ok := fields[c.field]; !ok {
+			fields[c.field] = convert(line[start:end], c.typ)
+		}
+	}
+	return fields, nil
+}
+
+// convert returns the value as the type, as string if it is not one.
+func convert(value, typ string) interface{} {
+	switch typ {
+	case "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errNoPairs = errors.New("line has no key=value pairs")
+
+// LogfmtParser reads lines of key=value pairs, where values with spaces are
+// quoted. Keys without value are true, lines without any key=value pair are
+// not logfmt.
+type LogfmtParser struct{}
+
+func (p *LogfmtParser) Parse(line string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	pairs := 0
+	for s := strings.TrimLeft(line, " \t"); s != ""; s = strings.TrimLeft(s, " \t") {
+		end := strings.IndexAny(s, " \t=")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		if key == "" {
+			return nil, errors.New("value without key")
+		}
+		s = s[end:]
+		if !strings.HasPrefix(s, "=") {
+			fields[key] = true
+			continue
+		}
+		s = s[1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			n := quotedLen(s)
+			if n < 0 {
+				return nil, errors.New("unterminated quoted value")
+			}
+			v, err := strconv.Unquote(s[:n])
+			if err != nil {
+				return nil, err
+			}
+			value, s = v, s[n:]
+		} else {
+			end = strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		fields[key] = value
+		pairs++
+	}
+	if pairs == 0 {
+		return nil, errNoPairs
+	}
+	return fields, nil
+}
+
+// quotedLen returns the length of the quoted string s starts with, -1 if it is not terminated.
+func quotedLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
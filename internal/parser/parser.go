@@ -0,0 +1,242 @@
+// Package parser turns plain text log lines into records with per-tag
+// parsers: regular expressions with named groups, grok patterns or logfmt.
+// Tags may also join the lines of multiline events, like stack traces.
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/multiline"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MessageKey is the field holding the line, unless the parser sets it.
+	MessageKey = "message"
+	// ErrorKey flags the records of lines the parser could not read.
+	ErrorKey = "@parse_error"
+	// AnyTag is the tag of the parser of tags without their own.
+	AnyTag = "*"
+)
+
+// Types of parsers.
+const (
+	Regex  = "regex"
+	Grok   = "grok"
+	Logfmt = "logfmt"
+)
+
+// ErrNoMatch is returned for lines which do not match the pattern of a parser.
+var ErrNoMatch = errors.New("line does not match the pattern")
+
+// Parser reads the fields of a log line.
+type Parser interface {
+	Parse(line string) (map[string]interface{}, error)
+}
+
+// Spec is an entry of the parsers file.
+type Spec struct {
+	// Tag whose lines are parsed, * for tags without their own parser.
+	Tag string `json:"tag"`
+	// Type of the parser, it may be empty for tags which only join multiline events.
+	Type string `json:"type"`
+	// Pattern is the regular expression of a regex parser or the grok pattern of a grok parser.
+	Pattern string `json:"pattern"`
+	// Patterns adds grok patterns, which replace built-in ones of the same name.
+	Patterns map[string]string `json:"patterns"`
+	// Multiline joins the lines of an event before it is parsed.
+	Multiline *MultilineSpec `json:"multiline"`
+}
+
+// MultilineSpec is the multiline rule of a tag.
+type MultilineSpec struct {
+	// Start matches the first line of an event.
+	Start string `json:"start"`
+	// Continuation matches the following lines of an event.
+	Continuation string `json:"continuation"`
+	// MaxLines flushes events with that many lines, 500 by default.
+	MaxLines int `json:"max_lines"`
+	// Timeout flushes events of listeners which got no line for that long, 1s by default.
+	Timeout string `json:"timeout"`
+}
+
+// Rule returns the multiline rule of the spec.
+func (s *MultilineSpec) Rule() (*multiline.Rule, error) {
+	var timeout time.Duration
+	if s.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return multiline.NewRule(s.Start, s.Continuation, s.MaxLines, timeout)
+}
+
+// New returns the parser of the spec.
+func New(s *Spec) (Parser, error) {
+	switch s.Type {
+	case Regex:
+		return NewRegex(s.Pattern)
+	case Grok:
+		return NewGrok(s.Pattern, s.Patterns)
+	case Logfmt:
+		return &LogfmtParser{}, nil
+	}
+	return nil, fmt.Errorf("unknown parser type %q", s.Type)
+}
+
+// Config is the parsers and the multiline rules by tag.
+type Config struct {
+	Parsers   map[string]Parser
+	Multiline map[string]*multiline.Rule
+}
+
+// LoadParsers reads the specs of a JSON file and returns the parsers and
+// the multiline rules by tag.
+func LoadParsers(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var specs []*Spec
+	if err = json.Unmarshal(data, &specs); err != nil {
+		return nil, err
+	}
+	c := &Config{Parsers: make(map[string]Parser, len(specs)), Multiline: map[string]*multiline.Rule{}}
+	tags := make(map[string]bool, len(specs))
+	for _, s := range specs {
+		if s.Tag == "" {
+			return nil, errors.New("parser without tag")
+		}
+		if tags[s.Tag] {
+			return nil, fmt.Errorf("parser %s: duplicate tag", s.Tag)
+		}
+		tags[s.Tag] = true
+		if s.Multiline != nil {
+			r, err := s.Multiline.Rule()
+			if err != nil {
+				return nil, fmt.Errorf("parser %s: %w", s.Tag, err)
+			}
+			c.Multiline[s.Tag] = r
+			if s.Type == "" {
+				continue
+			}
+		}
+		p, err := New(s)
+		if err != nil {
+			return nil, fmt.Errorf("parser %s: %w", s.Tag, err)
+		}
+		c.Parsers[s.Tag] = p
+	}
+	return c, nil
+}
+
+// Registry holds the parsers and multiline rules of the tags. A nil
+// Registry has none.
+type Registry struct {
+	lock   sync.RWMutex
+	config *Config
+}
+
+// NewRegistry loads the parsers file, an empty file name configures no parsers.
+func NewRegistry(file string) (*Registry, error) {
+	r := &Registry{}
+	if err := r.Reload(file); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload replaces the parsers with the ones of the file. They are left
+// unchanged if the file is invalid.
+func (r *Registry) Reload(file string) error {
+	var c *Config
+	if file != "" {
+		var err error
+		if c, err = LoadParsers(file); err != nil {
+			return err
+		}
+	}
+	r.Set(c)
+	return nil
+}
+
+// Set replaces the parsers and multiline rules, nil removes them.
+func (r *Registry) Set(c *Config) {
+	if c == nil {
+		c = &Config{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.config = c
+	log.Debug().Int("parsers", len(c.Parsers)).Int("multiline", len(c.Multiline)).Msg("parsers loaded")
+}
+
+// Lookup returns the parser of the tag, nil if there is none.
+func (r *Registry) Lookup(tag string) Parser {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.config == nil {
+		return nil
+	}
+	if p, ok := r.config.Parsers[tag]; ok {
+		return p
+	}
+	return r.config.Parsers[AnyTag]
+}
+
+// Multiline returns the multiline rule of the tag, nil if its lines are not joined.
+func (r *Registry) Multiline(tag string) *multiline.Rule {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.config == nil {
+		return nil
+	}
+	if m, ok := r.config.Multiline[tag]; ok {
+		return m
+	}
+	return r.config.Multiline[AnyTag]
+}
+
+// Record returns the fields of the record of a line. The line is its
+// message unless the parser sets one. Lines the parser can not read are
+// kept as message with the parse error flag, lines without parser as is.
+func Record(p Parser, line string) map[string]interface{} {
+	if p == nil {
+		return map[string]interface{}{MessageKey: line}
+	}
+	fields, err := p.Parse(line)
+	if err != nil {
+		return map[string]interface{}{MessageKey: line, ErrorKey: true}
+	}
+	if _, ok := fields[MessageKey]; !ok {
+		fields[MessageKey] = line
+	}
+	return fields
+}
+
+// Event returns the fields of the record of the lines of a multiline event.
+// Its first line is parsed, the following ones are appended to the message.
+func Event(p Parser, lines []string) map[string]interface{} {
+	rec := Record(p, lines[0])
+	if len(lines) == 1 {
+		return rec
+	}
+	if msg, ok := rec[MessageKey].(string); ok {
+		rec[MessageKey] = msg + "\n" + strings.Join(lines[1:], "\n")
+	} else {
+		rec[MessageKey] = strings.Join(lines, "\n")
+	}
+	return rec
+}
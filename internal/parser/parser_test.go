@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type ParserUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunParserUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(ParserUnitTestSuite))
+}
+
+func (s *ParserUnitTestSuite) Test_Regex() {
+	p, err := NewRegex(`^(?P<level>\w+) \[(?P<module>[^\]]+)\](?: (?P<message>.*))?$`)
+	s.NoError(err)
+	fields, err := p.Parse("WARN [db] slow query")
+	s.NoError(err)
+	s.Equal(map[string]interface{}{"level": "WARN", "module": "db", "message": "slow query"}, fields)
+
+	fields, err = p.Parse("INFO [db]")
+	s.NoError(err)
+	s.Equal(map[string]interface{}{"level": "INFO", "module": "db"}, fields)
+
+	_, err = p.Parse("no match")
+	s.True(errors.Is(err, ErrNoMatch))
+
+	_, err = NewRegex(`(\w+)`)
+	s.Error(err)
+	_, err = NewRegex(`(?P<x>`)
+	s.Error(err)
+}
+
+func (s *ParserUnitTestSuite) Test_Grok_Nginx() {
+	p, err := NewGrok("%{NGINXACCESS}", nil)
+	s.NoError(err)
+	fields, err := p.Parse(`192.168.1.10 - alice [10/Oct/2020:13:55:36 +0000] "GET /index.html?a=1 HTTP/1.1" 200 612 ` +
+		`"https://example.com/" "curl/7.68.0"`)
+	s.NoError(err)
+	s.Equal(map[string]interface{}{
+		"clientip": "192.168.1.10", "ident": "-", "auth": "alice", "timestamp": "10/Oct/2020:13:55:36 +0000",
+		"verb": "GET", "request": "/index.html?a=1", "httpversion": "1.1", "response": int64(200),
+		"bytes": int64(612), "referrer": `"https://example.com/"`, "agent": `"curl/7.68.0"`,
+	}, fields)
+
+	p, err = NewGrok("%{NGINXERROR}", nil)
+	s.NoError(err)
+	fields, err = p.Parse(`2020/10/10 13:55:36 [error] 12#7: *3 open() "/x" failed (2: No such file or directory)`)
+	s.NoError(err)
+	s.Equal(map[string]interface{}{
+		"timestamp": "2020/10/10 13:55:36", "loglevel": "error", "pid": int64(12), "tid": int64(7),
+		"connection": int64(3), "message": `open() "/x" failed (2: No such file or directory)`,
+	}, fields)
+}
+
+func (s *ParserUnitTestSuite) Test_Grok_Apache_Syslog() {
+	p, err := NewGrok("%{HTTPD_COMMONLOG}", nil)
+	s.NoError(err)
+	fields, err := p.Parse(`::1 - - [10/Oct/2020:13:55:36 -0700] "-" 408 -`)
+	s.NoError(err)
+	s.Equal("::1", fields["clientip"])
+	s.Equal("-", fields["rawrequest"])
+	s.Equal(int64(408), fields["response"])
+	s.NotContains(fields, "bytes")
+
+	p, err = NewGrok("%{HTTPD_ERRORLOG}", nil)
+	s.NoError(err)
+	fields, err = p.Parse(`[Sat Oct 10 13:55:36 2020] [core:error] [pid 35708:tid 4328636416] ` +
+		`[client 72.15.99.187:5000] File does not exist: /usr/local/apache2/htdocs/favicon.ico`)
+	s.NoError(err)
+	s.Equal("core", fields["module"])
+	s.Equal("error", fields["loglevel"])
+	s.Equal(int64(35708), fields["pid"])
+	s.Equal("72.15.99.187", fields["clientip"])
+
+	p, err = NewGrok("%{SYSLOGLINE}", nil)
+	s.NoError(err)
+	fields, err = p.Parse("Oct  1 22:14:15 host1 sshd[4321]: Accepted publickey for root")
+	s.NoError(err)
+	s.Equal(map[string]interface{}{
+		"timestamp": "Oct  1 22:14:15", "logsource": "host1", "program": "sshd", "pid": int64(4321),
+		"message": "Accepted publickey for root",
+	}, fields)
+}
+
+func (s *ParserUnitTestSuite) Test_Grok_Custom() {
+	p, err := NewGrok(`%{ID:id} took %{NUMBER:duration:float}ms (?P<unit>\w+)`, map[string]string{"ID": `[a-f0-9]{4}`})
+	s.NoError(err)
+	fields, err := p.Parse("req beef took 12.5ms total")
+	s.NoError(err)
+	s.Equal(map[string]interface{}{"id": "beef", "duration": 12.5, "unit": "total"}, fields)
+
+	_, err = NewGrok("%{MISSING:x}", nil)
+	s.Error(err)
+	_, err = NewGrok("%{A:x}", map[string]string{"A": "%{B}", "B": "%{A}"})
+	s.Error(err)
+	_, err = NewGrok("%{WORD}", nil)
+	s.Error(err)
+}
+
+func (s *ParserUnitTestSuite) Test_Logfmt() {
+	p := &LogfmtParser{}
+	fields, err := p.Parse(`level=info msg="request done" status=200 cached duration=1.5ms path="/a \"b\""`)
+	s.NoError(err)
+	s.Equal(map[string]interface{}{
+		"level": "info", "msg": "request done", "status": "200", "cached": true, "duration": "1.5ms",
+		"path": `/a "b"`,
+	}, fields)
+
+	for _, line := range []string{"just some words", `msg="unterminated`, `=value`, ""} {
+		_, err = p.Parse(line)
+		s.Error(err, line)
+	}
+}
+
+func (s *ParserUnitTestSuite) Test_Record() {
+	p := &LogfmtParser{}
+	s.Equal(map[string]interface{}{"level": "info", "message": "level=info"}, Record(p, "level=info"))
+	s.Equal(map[string]interface{}{"message": "free text", "@parse_error": true}, Record(p, "free text"))
+	s.Equal(map[string]interface{}{"message": "free text"}, Record(nil, "free text"))
+}
+
+func (s *ParserUnitTestSuite) Test_Event() {
+	p, err := NewRegex(`^(?P<level>[A-Z]+) (?P<message>.*)$`)
+	s.NoError(err)
+	trace := []string{"ERROR request failed", "java.lang.IllegalStateException: closed", "\tat Db.query(Db.java:42)"}
+	s.Equal(map[string]interface{}{
+		"level": "ERROR", "message": "request failed\njava.lang.IllegalStateException: closed\n\tat Db.query(Db.java:42)",
+	}, Event(p, trace))
+	s.Equal(map[string]interface{}{"level": "ERROR", "message": "request failed"}, Event(p, trace[:1]))
+	s.Equal(map[string]interface{}{"message": "a\n b"}, Event(nil, []string{"a", " b"}))
+	s.Equal(map[string]interface{}{"message": "a\n b", "@parse_error": true}, Event(p, []string{"a", " b"}))
+}
+
+func (s *ParserUnitTestSuite) Test_Registry() {
+	dir, err := ioutil.TempDir("", "parsers")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "parsers.json")
+	s.NoError(ioutil.WriteFile(file, []byte(`[
+		{"tag":"nginx","type":"grok","pattern":"%{NGINXACCESS}"},
+		{"tag":"java","multiline":{"start":"^\\d{4}-","max_lines":100,"timeout":"2s"}},
+		{"tag":"*","type":"logfmt"}]`), 0600))
+
+	r, err := NewRegistry(file)
+	s.NoError(err)
+	s.IsType(&PatternParser{}, r.Lookup("nginx"))
+	s.IsType(&LogfmtParser{}, r.Lookup("other"))
+	s.IsType(&LogfmtParser{}, r.Lookup("java"))
+	s.Nil(r.Multiline("nginx"))
+	rule := r.Multiline("java")
+	s.Require().NotNil(rule)
+	s.Equal(100, rule.MaxLines)
+	s.Equal(2*time.Second, rule.Timeout)
+	s.True(rule.Start.MatchString("2020-10-10 ERROR"))
+
+	for _, content := range []string{`[{"tag":"a","type":"xml"}]`, `[{"type":"logfmt"}]`,
+		`[{"tag":"a","type":"logfmt"},{"tag":"a","type":"logfmt"}]`, `[{"tag":"a","type":"grok","pattern":"%{X:y}"}]`,
+		`[{"tag":"a","multiline":{}}]`, `[{"tag":"a","multiline":{"start":"^x","timeout":"soon"}}]`} {
+		s.NoError(ioutil.WriteFile(file, []byte(content), 0600))
+		s.Error(r.Reload(file), content)
+	}
+	s.IsType(&PatternParser{}, r.Lookup("nginx"))
+
+	s.NoError(r.Reload(""))
+	s.Nil(r.Lookup("nginx"))
+	s.Nil(r.Multiline("java"))
+	var none *Registry
+	s.Nil(none.Lookup("nginx"))
+	s.Nil(none.Multiline("java"))
+}
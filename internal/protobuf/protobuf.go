@@ -0,0 +1,71 @@
+// Package protobuf reads protobuf messages without generated code, for the
+// few messages of the log protocols the adapter accepts.
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrMalformed is returned for data which is not a protobuf message.
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// Field is a field of a message. Data holds the value of length delimited
+// fields, Value the one of varint and fixed size fields.
+type Field struct {
+	Num   protowire.Number
+	Type  protowire.Type
+	Data  []byte
+	Value uint64
+}
+
+// Fields calls fn for every field of the message in data, in wire order.
+// It stops at the first error fn returns.
+func Fields(data []byte, fn func(f *Field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrMalformed, protowire.ParseError(n))
+		}
+		data = data[n:]
+		f := &Field{Num: num, Type: typ}
+		switch typ {
+		case protowire.BytesType:
+			f.Data, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			f.Value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			f.Value, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			f.Value = uint64(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrMalformed, protowire.ParseError(n))
+		}
+		data = data[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsBytes reports whether the field is the length delimited field num.
+func (f *Field) IsBytes(num protowire.Number) bool {
+	return f.Num == num && f.Type == protowire.BytesType
+}
+
+// IsVarint reports whether the field is the varint field num.
+func (f *Field) IsVarint(num protowire.Number) bool {
+	return f.Num == num && f.Type == protowire.VarintType
+}
+
+// IsFixed reports whether the field is the fixed size field num.
+func (f *Field) IsFixed(num protowire.Number) bool {
+	return f.Num == num && (f.Type == protowire.Fixed64Type || f.Type == protowire.Fixed32Type)
+}
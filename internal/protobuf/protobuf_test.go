@@ -0,0 +1,49 @@
+package protobuf
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/encoding/protowire"
+	"testing"
+)
+
+type ProtobufUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunProtobufUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(ProtobufUnitTestSuite))
+}
+
+func (s *ProtobufUnitTestSuite) Test_Fields() {
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, "name")
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	data = protowire.AppendVarint(data, 300)
+	data = protowire.AppendTag(data, 3, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 1<<40)
+	data = protowire.AppendTag(data, 4, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 7)
+
+	var got []Field
+	s.NoError(Fields(data, func(f *Field) error {
+		got = append(got, *f)
+		return nil
+	}))
+	s.Equal([]Field{
+		{Num: 1, Type: protowire.BytesType, Data: []byte("name")},
+		{Num: 2, Type: protowire.VarintType, Value: 300},
+		{Num: 3, Type: protowire.Fixed64Type, Value: 1 << 40},
+		{Num: 4, Type: protowire.Fixed32Type, Value: 7},
+	}, got)
+	s.True(got[0].IsBytes(1))
+	s.False(got[0].IsBytes(2))
+	s.True(got[1].IsVarint(2))
+	s.True(got[2].IsFixed(3))
+	s.True(got[3].IsFixed(4))
+
+	stop := errors.New("stop")
+	s.Equal(stop, Fields(data, func(f *Field) error { return stop }))
+	s.True(errors.Is(Fields(data[:len(data)-1], func(f *Field) error { return nil }), ErrMalformed))
+}
@@ -0,0 +1,196 @@
+// Package ratelimit limits the request rate of clients with token buckets
+// per client IP, credential and tag.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// Kind is the client attribute a bucket is kept for.
+type Kind string
+
+const (
+	IP         Kind = "ip"
+	Credential Kind = "credential"
+	Tag        Kind = "tag"
+)
+
+var kinds = []Kind{IP, Credential, Tag}
+
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Limit is the sustained rate in requests per second and the burst of a
+// bucket. A zero Rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Config struct {
+	IP         Limit
+	Credential Limit
+	Tag        Limit
+	// IdleTimeout drops buckets which have not been used for that long,
+	// it should exceed the time a bucket takes to refill.
+	IdleTimeout time.Duration
+}
+
+// Validate checks that every enabled limit allows at least one request.
+func (c *Config) Validate() error {
+	for _, kind := range kinds {
+		l := c.limit(kind)
+		if l.Rate < 0 {
+			return fmt.Errorf("%s rate limit must not be negative", kind)
+		}
+		if l.Rate > 0 && l.Burst < 1 {
+			return fmt.Errorf("%s rate limit burst must be at least 1", kind)
+		}
+	}
+	return nil
+}
+
+func (c *Config) limit(kind Kind) Limit {
+	switch kind {
+	case IP:
+		return c.IP
+	case Credential:
+		return c.Credential
+	case Tag:
+		return c.Tag
+	}
+	return Limit{}
+}
+
+// Key identifies a bucket.
+type Key struct {
+	Kind Kind
+	Name string
+}
+
+// LimitError is returned for requests exceeding a limit.
+type LimitError struct {
+	Kind Kind
+	// RetryAfter is the time until the bucket has a token again.
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrLimited, e.Kind)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+// Limiter holds the buckets of the clients. A nil Limiter allows every request.
+type Limiter struct {
+	lock    sync.Mutex
+	conf    Config
+	buckets map[Key]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	lim  *rate.Limiter
+	used time.Time
+}
+
+// NewLimiter creates a limiter which drops idle buckets until the context is done.
+func NewLimiter(ctx context.Context, conf *Config) *Limiter {
+	l := &Limiter{now: time.Now}
+	l.SetConfig(conf)
+	if conf.IdleTimeout > 0 {
+		go l.run(ctx, conf.IdleTimeout)
+	}
+	return l
+}
+
+// SetConfig replaces the limits, every bucket starts full again.
+func (l *Limiter) SetConfig(conf *Config) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.conf = *conf
+	l.buckets = make(map[Key]*bucket)
+	metrics.RateLimitBuckets.Set(0)
+	log.Debug().Interface("limits", conf).Msg("rate limits set")
+}
+
+// Allow takes a token from the bucket of every key. If any bucket is empty
+// no token is taken and a LimitError with the longest wait is returned.
+func (l *Limiter) Allow(keys ...Key) error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	var err *LimitError
+	res := make([]*rate.Reservation, 0, len(keys))
+	for _, k := range keys {
+		b := l.bucket(k, now)
+		if b == nil {
+			continue
+		}
+		r := b.lim.ReserveN(now, 1)
+		if d := r.DelayFrom(now); d > 0 && (err == nil || d > err.RetryAfter) {
+			err = &LimitError{Kind: k.Kind, RetryAfter: d}
+		}
+		res = append(res, r)
+	}
+	if err == nil {
+		return nil
+	}
+	for _, r := range res {
+		r.CancelAt(now)
+	}
+	return err
+}
+
+// bucket returns the bucket of the key, nil if its kind is not limited.
+func (l *Limiter) bucket(k Key, now time.Time) *bucket {
+	limit := l.conf.limit(k.Kind)
+	if limit.Rate == 0 {
+		return nil
+	}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{lim: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[k] = b
+		metrics.RateLimitBuckets.Set(float64(len(l.buckets)))
+	}
+	b.used = now
+	return b
+}
+
+func (l *Limiter) run(ctx context.Context, idle time.Duration) {
+	t := time.NewTicker(idle / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			l.dropIdle(idle)
+		}
+	}
+}
+
+// dropIdle removes the buckets which have not been used for the idle duration.
+func (l *Limiter) dropIdle(idle time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	deadline := l.now().Add(-idle)
+	for k, b := range l.buckets {
+		if b.used.Before(deadline) {
+			delete(l.buckets, k)
+		}
+	}
+	metrics.RateLimitBuckets.Set(float64(len(l.buckets)))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type RateLimitUnitTestSuite struct {
+	suite.Suite
+	lim *Limiter
+	now time.Time
+}
+
+func (s *RateLimitUnitTestSuite) SetupTest() {
+	s.now = time.Unix(1000, 0)
+	s.lim = &Limiter{now: func() time.Time {
+		return s.now
+	}}
+	s.lim.SetConfig(&Config{
+		IP:  Limit{Rate: 1, Burst: 2},
+		Tag: Limit{Rate: 10, Burst: 3},
+	})
+}
+
+func TestRunRateLimitUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitUnitTestSuite))
+}
+
+func (s *RateLimitUnitTestSuite) Test_Burst_And_Refill() {
+	ip := Key{Kind: IP, Name: "10.0.0.1"}
+	s.NoError(s.lim.Allow(ip))
+	s.NoError(s.lim.Allow(ip))
+
+	err := s.lim.Allow(ip)
+	s.True(errors.Is(err, ErrLimited))
+	var lErr *LimitError
+	s.True(errors.As(err, &lErr))
+	s.Equal(IP, lErr.Kind)
+	s.Equal(time.Second, lErr.RetryAfter)
+
+	s.NoError(s.lim.Allow(Key{Kind: IP, Name: "10.0.0.2"}), "other clients have their own bucket")
+
+	s.now = s.now.Add(time.Second)
+	s.NoError(s.lim.Allow(ip))
+}
+
+func (s *RateLimitUnitTestSuite) Test_Rejected_Request_Takes_No_Token() {
+	tag := Key{Kind: Tag, Name: "app"}
+	for i := 0; i < 2; i++ {
+		s.NoError(s.lim.Allow(Key{Kind: IP, Name: "a"}, tag))
+	}
+	err := s.lim.Allow(Key{Kind: IP, Name: "a"}, tag)
+	var lErr *LimitError
+	s.True(errors.As(err, &lErr))
+	s.Equal(IP, lErr.Kind)
+
+	s.NoError(s.lim.Allow(Key{Kind: IP, Name: "b"}, tag), "the tag bucket kept its last token")
+	s.Error(s.lim.Allow(Key{Kind: IP, Name: "c"}, tag))
+}
+
+func (s *RateLimitUnitTestSuite) Test_Disabled_Kind() {
+	for i := 0; i < 100; i++ {
+		s.NoError(s.lim.Allow(Key{Kind: Credential, Name: "ci"}))
+	}
+	var lim *Limiter
+	s.NoError(lim.Allow(Key{Kind: IP, Name: "a"}))
+}
+
+func (s *RateLimitUnitTestSuite) Test_Drop_Idle() {
+	s.NoError(s.lim.Allow(Key{Kind: IP, Name: "a"}))
+	s.now = s.now.Add(time.Minute)
+	s.NoError(s.lim.Allow(Key{Kind: IP, Name: "b"}))
+	s.lim.dropIdle(30 * time.Second)
+	s.Len(s.lim.buckets, 1)
+	s.Contains(s.lim.buckets, Key{Kind: IP, Name: "b"})
+}
+
+func (s *RateLimitUnitTestSuite) Test_Validate() {
+	s.NoError((&Config{}).Validate())
+	s.Error((&Config{IP: Limit{Rate: -1}}).Validate())
+	s.Error((&Config{Tag: Limit{Rate: 1}}).Validate())
+}
@@ -0,0 +1,289 @@
+// Package spool keeps records which could not be written to Meilisearch in
+// files on disk until they are replayed.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	filePrefix = "spool-"
+	fileSuffix = ".jsonl"
+)
+
+// ErrDisabled is returned by a nil Spool.
+var ErrDisabled = errors.New("spool is disabled")
+
+// Record is a spooled record, one JSON line in a spool file.
+type Record struct {
+	Index string          `json:"index"`
+	Data  json.RawMessage `json:"data"`
+	// Id is the @id of the record, a replay replaces a write which completed
+	// after the record was spooled.
+	Id string `json:"id,omitempty"`
+	// Timestamp is the time the record was logged or accepted in Unix
+	// nanoseconds, 0 if it is unknown.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// Time returns the time of the Timestamp, the zero time if it is unknown.
+func (r *Record) Time() time.Time {
+	if r.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, r.Timestamp)
+}
+
+// Spool appends records to a file of its own in a directory. A nil Spool
+// drops every record.
+type Spool struct {
+	lock sync.Mutex
+	dir  string
+	file *os.File
+	// backlog counts the records in the spool files, it is kept up to date by
+	// Write and Replay so it needs not read the files.
+	backlog int
+}
+
+// NewSpool uses dir for spool files, it returns a nil Spool if dir is empty.
+// The records in the spool files of earlier runs are counted.
+func NewSpool(dir string) (*Spool, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		n, err := countLines(name)
+		if err != nil {
+			return nil, err
+		}
+		s.backlog += n
+	}
+	return s, nil
+}
+
+// countLines returns the count of lines of the file without reading it at once.
+func countLines(name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	count := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(buf)
+		count += bytes.Count(buf[:n], []byte("\n"))
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write appends the record to the spool file, which is created on the
+// first write.
+func (s *Spool) Write(rec *Record) error {
+	if s == nil {
+		return ErrDisabled
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		name := filepath.Join(s.dir, fmt.Sprintf("%s%d%s", filePrefix, time.Now().UnixNano(), fileSuffix))
+		if s.file, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640); err != nil {
+			return err
+		}
+		log.Info().Str("file", name).Msg("spooling records")
+	}
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.backlog++
+	metrics.SpooledRecords.Inc()
+	return nil
+}
+
+// Close syncs and closes the spool file, a later Write starts a new one.
+func (s *Spool) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cErr := s.file.Close(); err == nil {
+		err = cErr
+	}
+	s.file = nil
+	return err
+}
+
+// Replay calls fn for the records of every spool file but the one written
+// by this Spool and removes the files. The records fn fails for are spooled
+// again.
+func (s *Spool) Replay(fn func(rec *Record) error) (replayed, failed int, err error) {
+	if s == nil {
+		return 0, 0, nil
+	}
+	files, err := s.files()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, name := range files {
+		r, f, err := s.replayFile(name, fn)
+		replayed, failed = replayed+r, failed+f
+		if err != nil {
+			return replayed, failed, err
+		}
+	}
+	return replayed, failed, nil
+}
+
+// Backlog returns the count of records in the spool files, the ones waiting
+// for a replay and the ones written by this Spool.
+func (s *Spool) Backlog() int {
+	if s == nil {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.backlog
+}
+
+// done counts a record of a spool file as replayed, or spooled again.
+func (s *Spool) done() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.backlog > 0 {
+		s.backlog--
+	}
+}
+
+// files returns the spool files of earlier runs in the order they were written.
+func (s *Spool) files() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	current := ""
+	if s.file != nil {
+		current = s.file.Name()
+	}
+	s.lock.Unlock()
+	var files []string
+	for _, info := range infos {
+		name := filepath.Join(s.dir, info.Name())
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), filePrefix) &&
+			strings.HasSuffix(info.Name(), fileSuffix) && name != current {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// replayFile replays the records of the file and removes it. If it fails
+// partway, the file is cut to the records not replayed yet, so the next
+// replay does not save the others again.
+func (s *Spool) replayFile(name string, fn func(rec *Record) error) (replayed, failed int, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			rec := &Record{}
+			if uErr := json.Unmarshal(line, rec); uErr != nil {
+				log.Warn().Err(uErr).Str("file", name).Msg("dropping corrupt spooled record")
+			} else if fErr := fn(rec); fErr != nil {
+				if wErr := s.Write(rec); wErr != nil {
+					return replayed, failed, s.cut(name, offset, wErr)
+				}
+				failed++
+			} else {
+				replayed++
+			}
+			offset += int64(len(line))
+			s.done()
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return replayed, failed, s.cut(name, offset, err)
+		}
+	}
+	return replayed, failed, os.Remove(name)
+}
+
+// cut removes the first offset bytes of the file, the records replayed
+// before err, and returns err.
+func (s *Spool) cut(name string, offset int64, err error) error {
+	if offset == 0 {
+		return err
+	}
+	if cErr := cutFile(name, offset); cErr != nil {
+		log.Err(cErr).Str("file", name).Msg("can not remove replayed records from spool file")
+	}
+	return err
+}
+
+func cutFile(name string, offset int64) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err = src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if sErr := dst.Sync(); err == nil {
+		err = sErr
+	}
+	if cErr := dst.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,147 @@
+package spool
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type SpoolUnitTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *SpoolUnitTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "spool")
+	s.NoError(err)
+	s.dir = dir
+}
+
+func (s *SpoolUnitTestSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func TestRunSpoolUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(SpoolUnitTestSuite))
+}
+
+func (s *SpoolUnitTestSuite) Test_Write_And_Replay() {
+	sp, err := NewSpool(s.dir)
+	s.NoError(err)
+	s.NoError(sp.Write(&Record{Index: "app", Data: []byte(`{"message":"first"}`), Timestamp: 1600000000000000005}))
+	s.NoError(sp.Write(&Record{Index: "team-a__app", Data: []byte("{\n\"message\": \"second\"\n}")}))
+	s.Equal(2, sp.Backlog())
+	s.NoError(sp.Close())
+
+	next, err := NewSpool(s.dir)
+	s.NoError(err)
+	s.Equal(2, next.Backlog(), "the records of earlier runs are counted")
+	var got []string
+	var times []time.Time
+	replayed, failed, err := next.Replay(func(rec *Record) error {
+		got = append(got, rec.Index+" "+string(rec.Data))
+		times = append(times, rec.Time())
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(2, replayed)
+	s.Equal(0, failed)
+	s.Equal([]string{`app {"message":"first"}`, `team-a__app {"message":"second"}`}, got)
+	s.Equal(time.Unix(1600000000, 5), times[0])
+	s.True(times[1].IsZero())
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"))
+	s.NoError(err)
+	s.Empty(files, "replayed files are removed")
+	s.Equal(0, next.Backlog())
+}
+
+func (s *SpoolUnitTestSuite) Test_Failed_Records_Are_Spooled_Again() {
+	sp, err := NewSpool(s.dir)
+	s.NoError(err)
+	s.NoError(sp.Write(&Record{Index: "app", Data: []byte(`{"n":1}`)}))
+	s.NoError(sp.Write(&Record{Index: "app", Data: []byte(`{"n":2}`)}))
+	s.NoError(sp.Close())
+	s.NoError(ioutil.WriteFile(filepath.Join(s.dir, "spool-1.jsonl"), []byte("{\"index\":\n"), 0640))
+
+	next, err := NewSpool(s.dir)
+	s.NoError(err)
+	replayed, failed, err := next.Replay(func(rec *Record) error {
+		if string(rec.Data) == `{"n":2}` {
+			return fmt.Errorf("test error")
+		}
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(1, replayed)
+	s.Equal(1, failed)
+	s.Equal(1, next.Backlog(), "records spooled again are counted")
+
+	replayed, _, err = next.Replay(func(*Record) error {
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(0, replayed, "the current spool file is not replayed")
+	s.NoError(next.Close())
+
+	var last []string
+	_, _, err = (&Spool{dir: s.dir}).Replay(func(rec *Record) error {
+		last = append(last, string(rec.Data))
+		return nil
+	})
+	s.NoError(err)
+	s.Equal([]string{`{"n":2}`}, last)
+}
+
+func (s *SpoolUnitTestSuite) Test_Failed_Replay_Keeps_Remaining_Records() {
+	sp, err := NewSpool(s.dir)
+	s.NoError(err)
+	for i := 1; i <= 3; i++ {
+		s.NoError(sp.Write(&Record{Index: "app", Data: []byte(fmt.Sprintf(`{"n":%d}`, i))}))
+	}
+	s.NoError(sp.Close())
+
+	// a closed spool file fails to spool the record again
+	closed, err := ioutil.TempFile("", "closed")
+	s.NoError(err)
+	defer os.Remove(closed.Name())
+	s.NoError(closed.Close())
+
+	next, err := NewSpool(s.dir)
+	s.NoError(err)
+	replayed, _, err := next.Replay(func(rec *Record) error {
+		if string(rec.Data) == `{"n":2}` {
+			next.file = closed
+			return fmt.Errorf("test error")
+		}
+		return nil
+	})
+	s.Error(err)
+	s.Equal(1, replayed)
+	s.Equal(2, next.Backlog())
+
+	next.file = nil
+	var left []string
+	_, _, err = next.Replay(func(rec *Record) error {
+		left = append(left, string(rec.Data))
+		return nil
+	})
+	s.NoError(err)
+	s.Equal([]string{`{"n":2}`, `{"n":3}`}, left, "replayed records are not replayed again")
+	s.Equal(0, next.Backlog())
+}
+
+func (s *SpoolUnitTestSuite) Test_Disabled() {
+	sp, err := NewSpool("")
+	s.NoError(err)
+	s.Nil(sp)
+	s.Equal(ErrDisabled, sp.Write(&Record{Index: "app", Data: []byte(`{}`)}))
+	s.NoError(sp.Close())
+	_, _, err = sp.Replay(nil)
+	s.NoError(err)
+	s.Equal(0, sp.Backlog())
+}
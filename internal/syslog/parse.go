@@ -0,0 +1,240 @@
+package syslog
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority of messages without one, user.notice.
+const defaultPriority = 13
+
+var facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron",
+	"authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message is a syslog message. Fields the message does not have are empty,
+// the timestamp is zero if it has none.
+type Message struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcId    string
+	MsgId     string
+	// StructuredData maps the SD-IDs of the message to their parameters.
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// Parse reads a message in the RFC 5424 or the RFC 3164 format. It does
+// not fail: the parts of a message which do not follow either format are
+// kept in Message. now completes RFC 3164 timestamps, which have no year.
+func Parse(data []byte, now time.Time) *Message {
+	s := string(bytes.TrimRight(data, "\r\n\x00"))
+	pri, rest, ok := parsePriority(s)
+	if !ok {
+		pri, rest = defaultPriority, s
+	}
+	m := &Message{Facility: pri / 8, Severity: pri % 8}
+	if strings.HasPrefix(rest, "1 ") {
+		parse5424(m, rest[2:])
+	} else {
+		parse3164(m, rest, now)
+	}
+	return m
+}
+
+func parsePriority(s string) (int, string, bool) {
+	end := strings.IndexByte(s, '>')
+	if !strings.HasPrefix(s, "<") || end < 2 || end > 4 {
+		return 0, s, false
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, s, false
+	}
+	return pri, s[end+1:], true
+}
+
+// nextField splits the space separated field s starts with from the rest.
+func nextField(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// nilValue returns the field, empty for the NILVALUE "-".
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parse5424 reads TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parse5424(m *Message, s string) {
+	var ts string
+	ts, s = nextField(s)
+	if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		m.Timestamp = t
+	}
+	var field string
+	field, s = nextField(s)
+	m.Hostname = nilValue(field)
+	field, s = nextField(s)
+	m.AppName = nilValue(field)
+	field, s = nextField(s)
+	m.ProcId = nilValue(field)
+	field, s = nextField(s)
+	m.MsgId = nilValue(field)
+	if strings.HasPrefix(s, "-") {
+		s = strings.TrimPrefix(s[1:], " ")
+	} else if strings.HasPrefix(s, "[") {
+		m.StructuredData, s = parseStructuredData(s)
+	}
+	m.Message = strings.TrimPrefix(s, "\ufeff")
+}
+
+// parseStructuredData reads SD-ELEMENTs, [id name="value" ...], and returns
+// the rest of s. A malformed element ends the structured data.
+func parseStructuredData(s string) (map[string]map[string]string, string) {
+	res := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return res, s
+		}
+		id, params, rest := s[1:end], map[string]string{}, s[end:]
+		for strings.HasPrefix(rest, " ") {
+			rest = strings.TrimLeft(rest, " ")
+			eq := strings.Index(rest, `="`)
+			if eq <= 0 {
+				return res, s
+			}
+			name := rest[:eq]
+			value, n, ok := sdValue(rest[eq+2:])
+			if !ok {
+				return res, s
+			}
+			params[name] = value
+			rest = rest[eq+2+n:]
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return res, s
+		}
+		res[id] = params
+		s = rest[1:]
+	}
+	return res, strings.TrimPrefix(s, " ")
+}
+
+// sdValue reads a PARAM-VALUE up to its closing quote, where \", \\ and \]
+// are escaped, and returns the length read with the quote.
+func sdValue(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']'):
+			b.WriteByte(s[i+1])
+			i++
+		case c == '"':
+			return b.String(), i + 1, true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
+
+// parse3164 reads TIMESTAMP HOSTNAME TAG[PID]: MSG, where senders often
+// leave out the hostname or use RFC 3339 timestamps.
+func parse3164(m *Message, s string, now time.Time) {
+	if len(s) >= 15 {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], now.Location()); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Timestamp, s = t, strings.TrimPrefix(s[15:], " ")
+		}
+	}
+	if m.Timestamp.IsZero() {
+		ts, rest := nextField(s)
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			m.Timestamp, s = t, rest
+		}
+	}
+	if m.Timestamp.IsZero() {
+		m.Message = s
+		return
+	}
+	if field, rest := nextField(s); !isTag(field) && rest != "" {
+		m.Hostname, s = field, rest
+	}
+	field, rest := nextField(s)
+	if !isTag(field) {
+		m.Message = s
+		return
+	}
+	field = strings.TrimSuffix(field, ":")
+	if i := strings.IndexByte(field, '['); i > 0 && strings.HasSuffix(field, "]") {
+		m.ProcId = field[i+1 : len(field)-1]
+		field = field[:i]
+	}
+	m.AppName, m.Message = field, rest
+}
+
+// isTag reports whether the field is a TAG with an optional [PID] and the
+// colon which ends it.
+func isTag(field string) bool {
+	if !strings.HasSuffix(field, ":") || len(field) < 2 {
+		return false
+	}
+	field = field[:len(field)-1]
+	if i := strings.IndexByte(field, '['); i >= 0 {
+		return i > 0 && strings.HasSuffix(field, "]")
+	}
+	return true
+}
+
+// FacilityName returns the keyword of the facility.
+func FacilityName(facility int) string {
+	if facility >= 0 && facility < len(facilities) {
+		return facilities[facility]
+	}
+	return strconv.Itoa(facility)
+}
+
+// SeverityName returns the keyword of the severity.
+func SeverityName(severity int) string {
+	if severity >= 0 && severity < len(severities) {
+		return severities[severity]
+	}
+	return strconv.Itoa(severity)
+}
+
+// Document returns the fields of a record of the message, without its timestamp.
+func (m *Message) Document() map[string]interface{} {
+	doc := map[string]interface{}{
+		"facility": FacilityName(m.Facility),
+		"severity": SeverityName(m.Severity),
+		"message":  m.Message,
+	}
+	for k, v := range map[string]string{"hostname": m.Hostname, "app_name": m.AppName, "procid": m.ProcId, "msgid": m.MsgId} {
+		if v != "" {
+			doc[k] = v
+		}
+	}
+	for id, params := range m.StructuredData {
+		for name, value := range params {
+			doc["sd."+id+"."+name] = value
+		}
+	}
+	return doc
+}
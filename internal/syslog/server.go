@@ -0,0 +1,304 @@
+// Package syslog receives syslog messages over UDP, TCP and TLS and writes
+// them to the adapter like records of the HTTP API.
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/polyse/logdb/internal/input"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Input is the name of the listener in metrics.
+	Input = "syslog"
+	// DefaultTag is the tag of messages without an app-name.
+	DefaultTag = "syslog"
+	// DefaultMaxMessageSize is the max size of a message, the max size of a UDP datagram.
+	DefaultMaxMessageSize = 64 * 1024
+	// DefaultIdleTimeout is the time TCP connections may send nothing before they are closed.
+	DefaultIdleTimeout = 5 * time.Minute
+)
+
+// ErrTooLarge ends TCP connections sending messages larger than the max size.
+var ErrTooLarge = errors.New("message is too large")
+
+// Config of the syslog listeners, a listener with an empty address is disabled.
+type Config struct {
+	UDPAddr string
+	TCPAddr string
+	// TLSAddr is the address of the TCP listener with the TLS configuration.
+	TLSAddr string
+	TLS     *tls.Config
+	// Tag is the tag of every message, the app-name of a message if empty.
+	Tag            string
+	MaxMessageSize int
+	// IdleTimeout closes TCP connections sending nothing for that long.
+	IdleTimeout time.Duration
+}
+
+// Server reads messages from the listeners and hands them to the sink.
+type Server struct {
+	conf   Config
+	sink   input.Sink
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lock  sync.Mutex
+	udp   net.PacketConn
+	tcp   net.Listener
+	tls   net.Listener
+	conns map[net.Conn]struct{}
+}
+
+// NewServer starts the configured listeners. The returned function stops
+// them, closes the open connections and waits for the messages being
+// written.
+func NewServer(ctx context.Context, conf *Config, sink input.Sink) (*Server, func(), error) {
+	s := &Server{conf: *conf, sink: sink, conns: map[net.Conn]struct{}{}}
+	if s.conf.MaxMessageSize <= 0 {
+		s.conf.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if s.conf.IdleTimeout <= 0 {
+		s.conf.IdleTimeout = DefaultIdleTimeout
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	if err := s.listen(); err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	return s, s.close, nil
+}
+
+func (s *Server) listen() error {
+	if s.conf.UDPAddr != "" {
+		pc, err := net.ListenPacket("udp", s.conf.UDPAddr)
+		if err != nil {
+			return err
+		}
+		s.udp = pc
+		log.Info().Str("listening", pc.LocalAddr().String()).Msg("syslog udp listener started")
+		s.wg.Add(1)
+		go s.serveUDP(pc)
+	}
+	if s.conf.TCPAddr != "" {
+		l, err := net.Listen("tcp", s.conf.TCPAddr)
+		if err != nil {
+			return err
+		}
+		s.tcp = l
+		s.serveTCP(l)
+	}
+	if s.conf.TLSAddr != "" {
+		if s.conf.TLS == nil {
+			return errors.New("syslog TLS listener requires a TLS configuration")
+		}
+		l, err := net.Listen("tcp", s.conf.TLSAddr)
+		if err != nil {
+			return err
+		}
+		s.tls = tls.NewListener(l, s.conf.TLS)
+		s.serveTCP(s.tls)
+	}
+	return nil
+}
+
+// Addrs returns the addresses of the UDP, TCP and TLS listeners, nil for disabled ones.
+func (s *Server) Addrs() (udp, tcp, tls net.Addr) {
+	if s.udp != nil {
+		udp = s.udp.LocalAddr()
+	}
+	if s.tcp != nil {
+		tcp = s.tcp.Addr()
+	}
+	if s.tls != nil {
+		tls = s.tls.Addr()
+	}
+	return
+}
+
+func (s *Server) close() {
+	s.cancel()
+	s.lock.Lock()
+	for _, c := range []io.Closer{s.udp, s.tcp, s.tls} {
+		if c != nil {
+			_ = c.Close()
+		}
+	}
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, s.conf.MaxMessageSize)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Err(err).Msg("can not read syslog datagram")
+			}
+			return
+		}
+		s.handle(buf[:n])
+	}
+}
+
+func (s *Server) serveTCP(l net.Listener) {
+	log.Info().Str("listening", l.Addr().String()).Msg("syslog tcp listener started")
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if s.ctx.Err() == nil {
+					log.Err(err).Msg("can not accept syslog connection")
+				}
+				return
+			}
+			if !s.track(c) {
+				_ = c.Close()
+				return
+			}
+			s.wg.Add(1)
+			go s.serveConn(c)
+		}
+	}()
+}
+
+// track registers an open connection, it fails once the server is closed.
+func (s *Server) track(c net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+		_ = c.Close()
+	}()
+	r := NewReader(c, s.conf.MaxMessageSize)
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(s.conf.IdleTimeout)); err != nil {
+			return
+		}
+		msg, err := r.ReadMessage()
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				log.Debug().Str("remote", c.RemoteAddr().String()).Msg("idle syslog connection closed")
+			} else if err != io.EOF && s.ctx.Err() == nil {
+				log.Warn().Err(err).Str("remote", c.RemoteAddr().String()).Msg("syslog connection closed")
+			}
+			return
+		}
+		s.handle(msg)
+	}
+}
+
+// handle writes a received message.
+func (s *Server) handle(data []byte) {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return
+	}
+	m := Parse(data, time.Now())
+	tag := s.conf.Tag
+	if tag == "" {
+		tag = input.Tag(m.AppName, DefaultTag)
+	}
+	stream := m.Hostname + "/" + m.AppName + "/" + m.ProcId
+	if err := s.sink.Ingest(s.ctx, Input, tag, stream, m.Document(), m.Timestamp); err != nil && s.ctx.Err() == nil {
+		log.Warn().Err(err).Str("tag", tag).Msg("syslog message rejected")
+	}
+}
+
+// Reader reads the messages of a TCP stream framed by octet counting, a
+// length and a space before each message, or by newlines. The framing is
+// chosen for every message: one starting with a digit is octet counted.
+type Reader struct {
+	r   *bufio.Reader
+	max int
+}
+
+// NewReader returns a reader of messages up to max bytes.
+func NewReader(r io.Reader, max int) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, max+1), max: max}
+}
+
+// ReadMessage returns the next message, it is valid until the next call.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	for {
+		b, err := r.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] >= '0' && b[0] <= '9' {
+			return r.readCounted()
+		}
+		line, err := r.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, ErrTooLarge
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		if line = trimLine(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (r *Reader) readCounted() ([]byte, error) {
+	prefix, err := r.r.ReadSlice(' ')
+	if err != nil {
+		if err == bufio.ErrBufferFull || err == io.EOF {
+			err = fmt.Errorf("malformed octet count %q", prefix)
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("malformed octet count %q", prefix)
+	}
+	if n > r.max {
+		return nil, ErrTooLarge
+	}
+	msg, err := r.r.Peek(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	_, _ = r.r.Discard(n)
+	return msg, nil
+}
+
+func trimLine(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r' || line[len(line)-1] == 0) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
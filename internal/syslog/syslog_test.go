@@ -0,0 +1,229 @@
+package syslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SyslogUnitTestSuite struct {
+	suite.Suite
+}
+
+func TestRunSyslogUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(SyslogUnitTestSuite))
+}
+
+func (s *SyslogUnitTestSuite) Test_Parse_RFC5424() {
+	m := Parse([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 `+
+		`[exampleSDID@32473 iut="3" eventSource="App\"lication"][meta seq="1"] An application event`+"\n"), time.Now())
+	s.Equal(&Message{
+		Facility:  20,
+		Severity:  5,
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		Hostname:  "mymachine.example.com",
+		AppName:   "evntslog",
+		MsgId:     "ID47",
+		StructuredData: map[string]map[string]string{
+			"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication`},
+			"meta":              {"seq": "1"},
+		},
+		Message: "An application event",
+	}, m)
+	s.Equal(map[string]interface{}{
+		"facility": "local4", "severity": "notice", "hostname": "mymachine.example.com", "app_name": "evntslog",
+		"msgid": "ID47", "sd.exampleSDID@32473.iut": "3", "sd.exampleSDID@32473.eventSource": `App"lication`,
+		"sd.meta.seq": "1", "message": "An application event",
+	}, m.Document())
+
+	m = Parse([]byte("<34>1 - - su 42 - - \ufeff'su root' failed"), time.Now())
+	s.True(m.Timestamp.IsZero())
+	s.Equal("", m.Hostname)
+	s.Equal("su", m.AppName)
+	s.Equal("42", m.ProcId)
+	s.Nil(m.StructuredData)
+	s.Equal("'su root' failed", m.Message)
+}
+
+func (s *SyslogUnitTestSuite) Test_Parse_RFC3164() {
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	m := Parse([]byte("<38>Jan  9 22:14:15 host1 sshd[4321]: Accepted publickey for root"), now)
+	s.Equal(&Message{
+		Facility:  4,
+		Severity:  6,
+		Timestamp: time.Date(2021, 1, 9, 22, 14, 15, 0, time.UTC),
+		Hostname:  "host1",
+		AppName:   "sshd",
+		ProcId:    "4321",
+		Message:   "Accepted publickey for root",
+	}, m)
+
+	// the year of the message is the previous one
+	m = Parse([]byte("<13>Dec 31 23:59:59 host1 cron: job"), now)
+	s.Equal(time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC), m.Timestamp)
+	s.Equal("cron", m.AppName)
+
+	// without hostname
+	m = Parse([]byte("<13>Jan 10 00:00:00 kernel: oops"), now)
+	s.Equal("", m.Hostname)
+	s.Equal("kernel", m.AppName)
+	s.Equal("oops", m.Message)
+
+	m = Parse([]byte("<13>2021-01-09T10:00:00+01:00 host1 app: started"), now)
+	s.Equal(time.Date(2021, 1, 9, 9, 0, 0, 0, time.UTC), m.Timestamp.UTC())
+	s.Equal("host1", m.Hostname)
+	s.Equal("app", m.AppName)
+}
+
+func (s *SyslogUnitTestSuite) Test_Parse_Malformed() {
+	m := Parse([]byte("just a line"), time.Now())
+	s.Equal(&Message{Facility: 1, Severity: 5, Message: "just a line"}, m)
+
+	m = Parse([]byte("<999>1 text"), time.Now())
+	s.Equal(1, m.Facility)
+	s.Equal("<999>1 text", m.Message)
+
+	m = Parse([]byte(`<13>1 - h a - - [broken x="1] rest`), time.Now())
+	s.Empty(m.StructuredData)
+	s.Equal(`[broken x="1] rest`, m.Message)
+}
+
+func (s *SyslogUnitTestSuite) Test_Reader() {
+	r := NewReader(strings.NewReader("11 <13>1 first\n<13>second\r\n\n10 <13>third\n8 <13>last"), 64)
+	var msgs []string
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		s.NoError(err)
+		msgs = append(msgs, string(msg))
+	}
+	s.Equal([]string{"<13>1 first", "<13>second", "<13>third\n", "<13>last"}, msgs)
+
+	_, err := NewReader(strings.NewReader("100 <13>x"), 64).ReadMessage()
+	s.True(errors.Is(err, ErrTooLarge))
+	_, err = NewReader(strings.NewReader("<13>"+strings.Repeat("x", 100)), 64).ReadMessage()
+	s.True(errors.Is(err, ErrTooLarge))
+	_, err = NewReader(strings.NewReader("12x <13>"), 64).ReadMessage()
+	s.Error(err)
+	_, err = NewReader(strings.NewReader("20 <13>short"), 64).ReadMessage()
+	s.True(errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+type record struct {
+	input  string
+	tag    string
+	stream string
+	doc    map[string]interface{}
+	ts     time.Time
+}
+
+type testSink struct {
+	lock    sync.Mutex
+	records []record
+}
+
+func (t *testSink) Ingest(_ context.Context, input, tag, stream string, doc map[string]interface{}, ts time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.records = append(t.records, record{input: input, tag: tag, stream: stream, doc: doc, ts: ts})
+	if tag == "bad" {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (t *testSink) tags() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var res []string
+	for _, r := range t.records {
+		res = append(res, r.tag)
+	}
+	return res
+}
+
+func (s *SyslogUnitTestSuite) Test_Server() {
+	sink := &testSink{}
+	srv, closeSrv, err := NewServer(context.Background(), &Config{UDPAddr: "127.0.0.1:0", TCPAddr: "127.0.0.1:0"}, sink)
+	s.NoError(err)
+	udp, tcp, tls := srv.Addrs()
+	s.Nil(tls)
+
+	c, err := net.Dial("udp", udp.String())
+	s.NoError(err)
+	_, err = c.Write([]byte("<13>1 2020-09-13T12:26:40Z host app - - - over udp"))
+	s.NoError(err)
+	s.NoError(c.Close())
+	s.Eventually(func() bool { return len(sink.tags()) == 1 }, time.Second, 5*time.Millisecond)
+
+	c, err = net.Dial("tcp", tcp.String())
+	s.NoError(err)
+	msg := "<13>1 - host postfix/smtpd - - - counted"
+	_, err = fmt.Fprintf(c, "%d %s<14>Jan  1 00:00:00 host bad: line\n", len(msg), msg)
+	s.NoError(err)
+	s.Eventually(func() bool { return len(sink.tags()) == 3 }, time.Second, 5*time.Millisecond)
+
+	closeSrv()
+	// open connections are closed
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	s.Error(err)
+
+	s.Equal([]string{"app", "postfix-smtpd", "bad"}, sink.tags())
+	s.Equal(record{input: Input, tag: "app", stream: "host/app/", doc: map[string]interface{}{
+		"facility": "user", "severity": "notice", "hostname": "host", "app_name": "app", "message": "over udp",
+	}, ts: time.Unix(1600000000, 0).UTC()}, sink.records[0])
+	s.True(sink.records[1].ts.IsZero())
+}
+
+func (s *SyslogUnitTestSuite) Test_Server_Idle() {
+	sink := &testSink{}
+	srv, closeSrv, err := NewServer(context.Background(), &Config{TCPAddr: "127.0.0.1:0", IdleTimeout: 50 * time.Millisecond}, sink)
+	s.NoError(err)
+	defer closeSrv()
+	_, tcp, _ := srv.Addrs()
+
+	c, err := net.Dial("tcp", tcp.String())
+	s.NoError(err)
+	defer c.Close()
+	_, err = c.Write([]byte("<13>Jan  1 00:00:00 host app: line\n"))
+	s.NoError(err)
+	s.Eventually(func() bool { return len(sink.tags()) == 1 }, time.Second, 5*time.Millisecond)
+	// the idle connection is closed by the server
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	s.Equal(io.EOF, err)
+}
+
+func (s *SyslogUnitTestSuite) Test_Server_Tag() {
+	sink := &testSink{}
+	srv, closeSrv, err := NewServer(context.Background(), &Config{UDPAddr: "127.0.0.1:0", Tag: "network"}, sink)
+	s.NoError(err)
+	defer closeSrv()
+	udp, tcp, _ := srv.Addrs()
+	s.Nil(tcp)
+
+	c, err := net.Dial("udp", udp.String())
+	s.NoError(err)
+	defer c.Close()
+	_, err = c.Write([]byte("<13>Jan  1 00:00:00 router sshd: line"))
+	s.NoError(err)
+	s.Eventually(func() bool { return len(sink.tags()) == 1 }, time.Second, 5*time.Millisecond)
+	s.Equal([]string{"network"}, sink.tags())
+}
+
+func (s *SyslogUnitTestSuite) Test_NewServer_Fails() {
+	_, _, err := NewServer(context.Background(), &Config{TLSAddr: "127.0.0.1:0"}, &testSink{})
+	s.Error(err)
+	_, _, err = NewServer(context.Background(), &Config{TCPAddr: "256.0.0.1:0"}, &testSink{})
+	s.Error(err)
+}
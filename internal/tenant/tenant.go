@@ -0,0 +1,236 @@
+// Package tenant isolates teams sharing one adapter. Every tenant writes to
+// its own index namespace and is limited by its own quotas.
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Header selects the tenant of requests whose credential is not bound to one.
+	Header = "X-Scope-OrgID"
+	// Separator joins the tenant and the tag in an index uid.
+	Separator = "__"
+
+	secondsPerDay = 24 * 60 * 60
+)
+
+var (
+	ErrInvalidTenant = errors.New("invalid tenant")
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrInvalidTag    = fmt.Errorf("tag must only contain letters, digits, - and _, and not %q", Separator)
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+var (
+	idPattern = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+	// tagPattern matches the characters Meilisearch allows in index uids.
+	tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Tenant is an entry of the tenants file. The default tenant has an empty
+// ID, its indexes are named after the tags like before tenants existed.
+type Tenant struct {
+	ID string `json:"id"`
+	// MaxIndexes limits the number of indexes of the tenant, 0 is unlimited.
+	MaxIndexes int `json:"max_indexes"`
+	// MaxDailyBytes limits the bytes ingested per UTC day, 0 is unlimited.
+	MaxDailyBytes int64 `json:"max_daily_bytes"`
+}
+
+// QuotaError is returned when a request exceeds a quota of the tenant.
+type QuotaError struct {
+	Quota string
+	// RetryAfter is the time until the quota is available again, 0 if it is not reset.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrQuotaExceeded, e.Quota)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Index returns the uid of the index holding the records of the tag.
+func (t *Tenant) Index(tag string) string {
+	if t.ID == "" {
+		return tag
+	}
+	return t.ID + Separator + tag
+}
+
+// Tag returns the tag of an index uid and whether the index belongs to the tenant.
+func (t *Tenant) Tag(uid string) (string, bool) {
+	if t.ID == "" {
+		return uid, !strings.Contains(uid, Separator)
+	}
+	prefix := t.ID + Separator
+	if !strings.HasPrefix(uid, prefix) || len(uid) == len(prefix) {
+		return "", false
+	}
+	return uid[len(prefix):], true
+}
+
+// Split returns the tenant and the tag of an index uid, the tenant is empty
+// for indexes created without tenancy.
+func Split(uid string) (string, string) {
+	if i := strings.Index(uid, Separator); i > 0 && i+len(Separator) < len(uid) {
+		return uid[:i], uid[i+len(Separator):]
+	}
+	return "", uid
+}
+
+// ValidateTag checks that the tag is a valid index uid which can not be
+// mistaken for an index of another tenant.
+func ValidateTag(tag string) error {
+	if !tagPattern.MatchString(tag) || strings.Contains(tag, Separator) {
+		return ErrInvalidTag
+	}
+	return nil
+}
+
+// LoadTenants reads and validates tenants from a JSON file.
+func LoadTenants(file string) ([]*Tenant, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err = json.Unmarshal(data, &tenants); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{}, len(tenants))
+	for _, t := range tenants {
+		if err = t.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := ids[t.ID]; ok {
+			return nil, fmt.Errorf("tenant %s: duplicate id", t.ID)
+		}
+		ids[t.ID] = struct{}{}
+	}
+	return tenants, nil
+}
+
+func (t *Tenant) validate() error {
+	if !idPattern.MatchString(t.ID) {
+		return fmt.Errorf("tenant %q: id must match %s", t.ID, idPattern)
+	}
+	if t.MaxIndexes < 0 || t.MaxDailyBytes < 0 {
+		return fmt.Errorf("tenant %s: quotas must not be negative", t.ID)
+	}
+	return nil
+}
+
+// Registry resolves tenants and accounts their usage. Without a tenants
+// file any well formed tenant is accepted without quotas. A nil Registry
+// behaves like one without file.
+type Registry struct {
+	lock    sync.Mutex
+	enabled bool
+	tenants map[string]*Tenant
+	usage   map[string]*usage
+	now     func() time.Time
+}
+
+// usage is the number of bytes ingested by a tenant during a UTC day.
+type usage struct {
+	day   int64
+	bytes int64
+}
+
+// NewRegistry loads the tenants file, an empty file name accepts any tenant.
+func NewRegistry(file string) (*Registry, error) {
+	r := &Registry{now: time.Now}
+	if err := r.Reload(file); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload replaces the tenants with the ones of the file. They are left
+// unchanged if the file is invalid. Usage of the current day is kept.
+func (r *Registry) Reload(file string) error {
+	var tenants []*Tenant
+	if file != "" {
+		var err error
+		if tenants, err = LoadTenants(file); err != nil {
+			return err
+		}
+	}
+	r.Set(tenants, file != "")
+	return nil
+}
+
+// Set replaces the tenants, unknown tenants are rejected only if enabled is set.
+func (r *Registry) Set(tenants []*Tenant, enabled bool) {
+	m := make(map[string]*Tenant, len(tenants))
+	for _, t := range tenants {
+		m[t.ID] = t
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.enabled = enabled
+	r.tenants = m
+	if r.usage == nil {
+		r.usage = make(map[string]*usage)
+	}
+	log.Debug().Bool("enabled", enabled).Int("tenants", len(m)).Msg("tenants loaded")
+}
+
+// Lookup returns the tenant with the id, an empty id is the default tenant.
+func (r *Registry) Lookup(id string) (*Tenant, error) {
+	if id == "" {
+		return &Tenant{}, nil
+	}
+	if !idPattern.MatchString(id) {
+		return nil, ErrInvalidTenant
+	}
+	if r == nil {
+		return &Tenant{ID: id}, nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	t, ok := r.tenants[id]
+	if !ok {
+		if r.enabled {
+			return nil, ErrUnknownTenant
+		}
+		return &Tenant{ID: id}, nil
+	}
+	c := *t
+	return &c, nil
+}
+
+// Consume accounts n ingested bytes to the tenant. It fails without
+// accounting them if they exceed the daily quota of the tenant.
+func (r *Registry) Consume(t *Tenant, n int64) error {
+	if r == nil || t.MaxDailyBytes == 0 {
+		return nil
+	}
+	now := r.now()
+	day := now.Unix() / secondsPerDay
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	u, ok := r.usage[t.ID]
+	if !ok || u.day != day {
+		u = &usage{day: day}
+		r.usage[t.ID] = u
+	}
+	if u.bytes+n > t.MaxDailyBytes {
+		midnight := time.Unix((day+1)*secondsPerDay, 0)
+		return &QuotaError{Quota: "daily bytes", RetryAfter: midnight.Sub(now)}
+	}
+	u.bytes += n
+	return nil
+}
@@ -0,0 +1,141 @@
+package tenant
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type TenantUnitTestSuite struct {
+	suite.Suite
+	reg *Registry
+	now time.Time
+}
+
+func (s *TenantUnitTestSuite) SetupTest() {
+	s.now = time.Date(2020, 10, 1, 23, 0, 0, 0, time.UTC)
+	s.reg = &Registry{now: func() time.Time {
+		return s.now
+	}}
+	s.reg.Set([]*Tenant{
+		{ID: "team-a", MaxIndexes: 2, MaxDailyBytes: 100},
+		{ID: "team-b"},
+	}, true)
+}
+
+func TestRunTenantUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(TenantUnitTestSuite))
+}
+
+func (s *TenantUnitTestSuite) Test_Index_Namespace() {
+	a := &Tenant{ID: "team-a"}
+	s.Equal("team-a__app", a.Index("app"))
+	tag, ok := a.Tag("team-a__app")
+	s.True(ok)
+	s.Equal("app", tag)
+	_, ok = a.Tag("team-b__app")
+	s.False(ok)
+	_, ok = a.Tag("app")
+	s.False(ok)
+
+	def := &Tenant{}
+	s.Equal("app", def.Index("app"))
+	tag, ok = def.Tag("app")
+	s.True(ok)
+	s.Equal("app", tag)
+	_, ok = def.Tag("team-a__app")
+	s.False(ok, "the default tenant does not own indexes of other tenants")
+
+	t, tag := Split("team-a__app")
+	s.Equal("team-a", t)
+	s.Equal("app", tag)
+	t, tag = Split("app")
+	s.Empty(t)
+	s.Equal("app", tag)
+
+	s.Equal(ErrInvalidTag, ValidateTag("team-a__app"))
+	for _, tag := range []string{"", "a.b", "ns/app", "app 1", "äpp"} {
+		s.Equal(ErrInvalidTag, ValidateTag(tag), tag)
+	}
+	s.NoError(ValidateTag("app_1"))
+	s.NoError(ValidateTag("Web-2"))
+}
+
+func (s *TenantUnitTestSuite) Test_Lookup() {
+	t, err := s.reg.Lookup("team-a")
+	s.NoError(err)
+	s.Equal(100, int(t.MaxDailyBytes))
+
+	t, err = s.reg.Lookup("")
+	s.NoError(err)
+	s.Equal("", t.ID)
+
+	_, err = s.reg.Lookup("team-c")
+	s.Equal(ErrUnknownTenant, err)
+	_, err = s.reg.Lookup("team_a")
+	s.Equal(ErrInvalidTenant, err)
+
+	s.reg.Set(nil, false)
+	t, err = s.reg.Lookup("team-c")
+	s.NoError(err, "any tenant is accepted without tenants file")
+	s.Equal("team-c", t.ID)
+
+	var nilReg *Registry
+	t, err = nilReg.Lookup("team-c")
+	s.NoError(err)
+	s.Equal("team-c", t.ID)
+}
+
+func (s *TenantUnitTestSuite) Test_Daily_Bytes() {
+	t, err := s.reg.Lookup("team-a")
+	s.NoError(err)
+	s.NoError(s.reg.Consume(t, 60))
+
+	err = s.reg.Consume(t, 60)
+	s.True(errors.Is(err, ErrQuotaExceeded))
+	var qErr *QuotaError
+	s.True(errors.As(err, &qErr))
+	s.Equal(time.Hour, qErr.RetryAfter)
+	s.NoError(s.reg.Consume(t, 40), "rejected bytes are not accounted")
+
+	s.now = s.now.Add(time.Hour)
+	s.NoError(s.reg.Consume(t, 60), "usage is reset on the next day")
+
+	b, err := s.reg.Lookup("team-b")
+	s.NoError(err)
+	s.NoError(s.reg.Consume(b, 1000))
+}
+
+func (s *TenantUnitTestSuite) Test_Reload() {
+	f, err := ioutil.TempFile("", "tenants*.json")
+	s.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"id":"team-c","max_indexes":1}]`)
+	s.NoError(err)
+	s.NoError(f.Close())
+
+	s.NoError(s.reg.Reload(f.Name()))
+	t, err := s.reg.Lookup("team-c")
+	s.NoError(err)
+	s.Equal(1, t.MaxIndexes)
+	_, err = s.reg.Lookup("team-a")
+	s.Equal(ErrUnknownTenant, err)
+
+	s.Error(s.reg.Reload(os.DevNull))
+	_, err = s.reg.Lookup("team-c")
+	s.NoError(err, "invalid file keeps the current tenants")
+}
+
+func (s *TenantUnitTestSuite) Test_Invalid_Tenants() {
+	for _, t := range []*Tenant{
+		{},
+		{ID: "a__b"},
+		{ID: "a", MaxIndexes: -1},
+		{ID: "a", MaxDailyBytes: -1},
+	} {
+		s.Error(t.validate(), t.ID)
+	}
+}
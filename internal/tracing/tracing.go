@@ -0,0 +1,167 @@
+// Package tracing records spans of the ingestion path with OpenTelemetry and
+// exports them to a collector over OTLP/HTTP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TraceparentHeader is the W3C trace context header.
+	TraceparentHeader = "traceparent"
+
+	tracesPath      = "/v1/traces"
+	instrumentation = "github.com/polyse/logdb"
+)
+
+type Config struct {
+	// Endpoint is the collector base URL, spans are posted to Endpoint/v1/traces.
+	// Tracing is disabled when it is empty.
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of new traces which are recorded, incoming
+	// traces keep the decision of their parent.
+	SampleRatio   float64
+	BatchSize     int
+	QueueSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+var propagator = propagation.TraceContext{}
+
+// Span is a timed operation.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute records a string, bool or numeric attribute of the span.
+func (s *Span) SetAttribute(key string, val interface{}) {
+	s.span.SetAttributes(keyValue(key, val))
+}
+
+// RecordError marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End finishes the span.
+func (s *Span) End() {
+	s.span.End()
+}
+
+// Init starts exporting spans with the given configuration and returns a
+// function flushing the pending spans. Tracing stays disabled without an endpoint.
+func Init(ctx context.Context, conf *Config) (func(), error) {
+	if conf.Endpoint == "" {
+		return func() {}, nil
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("tracing endpoint %s is not an http(s) URL", conf.Endpoint)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + tracesPath),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if conf.Timeout > 0 {
+		opts = append(opts, otlptracehttp.WithTimeout(conf.Timeout))
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch []sdktrace.BatchSpanProcessorOption
+	if conf.BatchSize > 0 {
+		batch = append(batch, sdktrace.WithMaxExportBatchSize(conf.BatchSize))
+	}
+	if conf.QueueSize > 0 {
+		batch = append(batch, sdktrace.WithMaxQueueSize(conf.QueueSize))
+	}
+	if conf.FlushInterval > 0 {
+		batch = append(batch, sdktrace.WithBatchTimeout(conf.FlushInterval))
+	}
+	if conf.Timeout > 0 {
+		batch = append(batch, sdktrace.WithExportTimeout(conf.Timeout))
+	}
+	name := conf.ServiceName
+	if name == "" {
+		name = "logdb"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp, batch...),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(name))),
+	)
+	otel.SetTracerProvider(tp)
+	return func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout(conf))
+		defer cancel()
+		_ = tp.Shutdown(ctx)
+	}, nil
+}
+
+// exportTimeout is the time the pending spans have to be exported on shutdown.
+func exportTimeout(conf *Config) time.Duration {
+	if conf.Timeout > 0 {
+		return conf.Timeout
+	}
+	return 5 * time.Second
+}
+
+// Start starts a span which is a child of the span in the context.
+// It records nothing when tracing is disabled.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, name)
+	return ctx, &Span{span: span}
+}
+
+// StartServer starts a server span continuing the trace of a traceparent header value.
+func StartServer(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set(TraceparentHeader, traceparent)
+	ctx = propagator.Extract(ctx, carrier)
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return ctx, &Span{span: span}
+}
+
+func keyValue(key string, val interface{}) attribute.KeyValue {
+	switch v := val.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(val))
+}
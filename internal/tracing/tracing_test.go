@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type TracingUnitTestSuite struct {
+	suite.Suite
+	srv      *httptest.Server
+	received chan *coltracepb.ExportTraceServiceRequest
+	stop     func()
+}
+
+func (s *TracingUnitTestSuite) SetupTest() {
+	s.received = make(chan *coltracepb.ExportTraceServiceRequest, 10)
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("/otel"+tracesPath, r.URL.Path)
+		s.Equal("application/x-protobuf", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		s.NoError(err)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		s.NoError(proto.Unmarshal(body, req))
+		s.received <- req
+	}))
+	s.stop = s.init(1)
+}
+
+func (s *TracingUnitTestSuite) init(ratio float64) func() {
+	stop, err := Init(context.Background(), &Config{
+		Endpoint:      s.srv.URL + "/otel/",
+		ServiceName:   "test",
+		SampleRatio:   ratio,
+		FlushInterval: time.Hour,
+		Timeout:       time.Second,
+	})
+	s.Require().NoError(err)
+	return stop
+}
+
+func (s *TracingUnitTestSuite) TearDownTest() {
+	s.stop()
+	s.srv.Close()
+}
+
+func TestRunTracingUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingUnitTestSuite))
+}
+
+func (s *TracingUnitTestSuite) exported() []*tracepb.Span {
+	s.stop()
+	select {
+	case req := <-s.received:
+		s.Require().Len(req.ResourceSpans, 1)
+		rs := req.ResourceSpans[0]
+		var service string
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" {
+				service = kv.Value.GetStringValue()
+			}
+		}
+		s.Equal("test", service)
+		s.Require().Len(rs.InstrumentationLibrarySpans, 1)
+		return rs.InstrumentationLibrarySpans[0].Spans
+	case <-time.After(time.Second):
+		s.Fail("no spans received")
+		return nil
+	}
+}
+
+func (s *TracingUnitTestSuite) Test_Continues_Traceparent() {
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx, server := StartServer(context.Background(), "HandleNewLog", parent)
+	server.SetAttribute("logdb.tag", "test")
+	server.SetAttribute("logdb.size", 10)
+	_, child := Start(ctx, "parse")
+	child.RecordError(fmt.Errorf("bad json"))
+	child.End()
+	server.End()
+
+	spans := s.exported()
+	s.Require().Len(spans, 2)
+	s.Equal("parse", spans[0].Name)
+	s.Equal("HandleNewLog", spans[1].Name)
+	for _, sp := range spans {
+		s.Equal("0af7651916cd43dd8448eb211c80319c", hex.EncodeToString(sp.TraceId))
+	}
+	s.Equal("b7ad6b7169203331", hex.EncodeToString(spans[1].ParentSpanId))
+	s.Equal(tracepb.Span_SPAN_KIND_SERVER, spans[1].Kind)
+	s.Equal(spans[1].SpanId, spans[0].ParentSpanId)
+	s.Equal(tracepb.Span_SPAN_KIND_INTERNAL, spans[0].Kind)
+	s.Require().NotNil(spans[0].Status)
+	s.Equal(tracepb.Status_STATUS_CODE_ERROR, spans[0].Status.Code)
+	s.Equal("bad json", spans[0].Status.Message)
+	s.Require().Len(spans[1].Attributes, 2)
+	s.Equal("logdb.tag", spans[1].Attributes[0].Key)
+	s.Equal("test", spans[1].Attributes[0].Value.GetStringValue())
+	s.Equal(int64(10), spans[1].Attributes[1].Value.GetIntValue())
+}
+
+func (s *TracingUnitTestSuite) Test_Starts_New_Trace() {
+	_, span := StartServer(context.Background(), "HandleNewLog", "garbage")
+	span.End()
+
+	spans := s.exported()
+	s.Require().Len(spans, 1)
+	s.Len(spans[0].TraceId, 16)
+	s.Len(spans[0].SpanId, 8)
+	s.Empty(spans[0].ParentSpanId)
+}
+
+func (s *TracingUnitTestSuite) Test_Unsampled_Trace_Not_Exported() {
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	ctx, span := StartServer(context.Background(), "HandleNewLog", parent)
+	_, child := Start(ctx, "parse")
+	child.End()
+	span.End()
+	s.stop()
+
+	select {
+	case <-s.received:
+		s.Fail("unsampled spans exported")
+	default:
+	}
+}
+
+func (s *TracingUnitTestSuite) Test_Sample_Ratio() {
+	s.stop()
+	s.stop = s.init(0)
+	_, span := StartServer(context.Background(), "HandleNewLog", "")
+	span.End()
+	s.stop()
+
+	select {
+	case <-s.received:
+		s.Fail("new traces are exported with a zero sample ratio")
+	default:
+	}
+}
+
+func (s *TracingUnitTestSuite) Test_Disabled_Tracing() {
+	s.stop()
+	stop, err := Init(context.Background(), &Config{})
+	s.NoError(err)
+	defer stop()
+	_, span := Start(context.Background(), "parse")
+	span.SetAttribute("key", "value")
+	span.RecordError(fmt.Errorf("error"))
+	span.End()
+	s.False(span.span.IsRecording())
+}
+
+func (s *TracingUnitTestSuite) Test_Invalid_Endpoint() {
+	_, err := Init(context.Background(), &Config{Endpoint: "collector:4318"})
+	s.Error(err)
+}